@@ -2,9 +2,13 @@ package main
 
 import (
 	"log"
+	"os"
+	"time"
 
 	"github.com/Jailior/open-search/backend/internal/api"
+	"github.com/Jailior/open-search/backend/internal/ltr"
 	"github.com/Jailior/open-search/backend/internal/storage"
+	"github.com/Jailior/open-search/backend/internal/suggest"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/ulule/limiter/v3"
@@ -24,12 +28,77 @@ func main() {
 	db.AddCollection(api.DB_NAME, api.COLL_NAME)
 	db.AddCollection(api.DB_NAME, "pages")
 	db.AddCollection(api.DB_NAME, "pagerank")
+	db.AddCollection(api.DB_NAME, storage.INDEX_ALIAS_COLLECTION)
+	db.AddCollection(api.DB_NAME, storage.GENERATION_COLLECTION)
+	db.AddCollection(api.DB_NAME, storage.LINKS_COLLECTION)
+	db.AddCollection(api.DB_NAME, storage.LINK_EDGES_COLLECTION)
+	db.AddCollection(api.DB_NAME, storage.HOST_LINKS_COLLECTION)
+	db.AddCollection(api.DB_NAME, storage.REWRITE_RULES_COLLECTION)
+
+	// modified terms are read from the change log to keep cached postings fresh
+	if err := db.EnsureIndexChanges(api.DB_NAME); err != nil {
+		log.Fatalf("Failed to create index change log: %v", err)
+	}
 
 	// initialize corpus stats if not already initialized
 	db.InitializeIndexCorpus(api.COLL_NAME)
 
 	// give SearchService wrapper access to database reference
-	svc := &api.SearchService{DB: db}
+	svc := &api.SearchService{
+		DB:        db,
+		Ranking:   api.LoadRankingConfig(),
+		Suggester: suggest.NewSuggester(),
+		Postings:  api.LoadPostingsCache(),
+		Cache:     api.LoadResultCache(),
+
+		AdminToken: os.Getenv("ADMIN_TOKEN"),
+	}
+
+	// record searches for analytics, search still works without the log
+	queryLog, err := api.StartQueryLog(db)
+	if err != nil {
+		log.Println("Failed to start query log, searches will not be recorded: ", err)
+	} else {
+		svc.QueryLog = queryLog
+	}
+
+	// record clicks on results, ranking uses the click-through aggregated by cmd/clicks
+	clickTracker, err := api.StartClickTracker(db)
+	if err != nil {
+		log.Println("Failed to start click tracking, clicks will not be recorded: ", err)
+	} else {
+		svc.Clicks = clickTracker
+	}
+
+	// re-score the best results with a learned ranking model if one is configured
+	if path := os.Getenv("LTR_MODEL"); path != "" {
+		model, err := ltr.LoadModel(path)
+		if err != nil {
+			log.Println("Failed to load ranking model, results will not be reranked: ", err)
+		} else {
+			svc.Reranker = model
+			log.Printf("Loaded %s ranking model from %s\n", model.Objective, path)
+		}
+	}
+
+	// follow the index alias so reindexed versions are served once swapped in
+	svc.WatchIndexAlias(5 * time.Second)
+
+	// follow the search generation so cached results are dropped once the index, PageRank or click-through change
+	svc.WatchGeneration(api.GENERATION_POLL_INTERVAL)
+
+	// drop cached postings the indexer modifies, then warm the cache with the terms of popular queries
+	svc.WatchIndexChanges(api.INDEX_CHANGES_POLL_INTERVAL)
+	svc.PreloadPostings(api.PRELOAD_QUERIES)
+
+	// expand synonyms and acronyms, reloading the rewrite rules admins manage with cmd/rewrites
+	svc.WatchRewriteRules(api.LoadDictionaryRules(), api.REWRITE_RULES_RELOAD_INTERVAL)
+
+	// build the spelling dictionary in the background, searches go uncorrected until it is ready
+	svc.WatchSpeller(api.SPELLER_REBUILD_INTERVAL)
+
+	// serve autocomplete from memory, updated in the background as pages are indexed
+	svc.WatchAutocomplete(api.AUTOCOMPLETE_UPDATE_INTERVAL)
 
 	router := gin.Default()
 
@@ -54,7 +123,7 @@ func main() {
 
 	// log and run on port 8080 with error handling
 	log.Println("Starting API on localhost:8080")
-	err := router.Run(":8080")
+	err = router.Run(":8080")
 	if err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/api"
+	"github.com/Jailior/open-search/backend/internal/storage"
+)
+
+// Latencies and work of one retrieval mode over all runs
+type modeStats struct {
+	total     []time.Duration
+	retrieval []time.Duration
+	evaluated int
+}
+
+/*
+Benchmarks top-k retrieval against the exhaustive path it replaces, on the live index
+
+	benchsearch -queries queries.txt [-runs N] [-limit N] [-offset N] [-ranking bm25]
+
+Each query is run in both modes, the results are checked to be identical and latencies are summarized
+*/
+func main() {
+
+	// define flags
+	queriesFile := flag.String("queries", "", "File with one query per line, required")
+	runs := flag.Int("runs", 5, "Times each query is run in each mode")
+	limit := flag.Int("limit", api.DEFAULT_PAGE_LIMIT, "Results per page")
+	offset := flag.Int("offset", api.DEFAULT_PAGE_OFFSET, "Offset of the page")
+	ranking := flag.String("ranking", "", "Ranking model, defaults to RANKING_MODEL or tfidf")
+
+	flag.Parse()
+
+	if *queriesFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	queries, err := readQueries(*queriesFile)
+	if err != nil {
+		log.Fatalf("Failed to read queries: %v", err)
+	}
+
+	// connect to database
+	db := storage.MakeDB()
+	db.Connect()
+	defer db.Disconnect()
+	db.AddCollection(api.DB_NAME, api.COLL_NAME)
+	db.AddCollection(api.DB_NAME, "pages")
+	db.AddCollection(api.DB_NAME, "pagerank")
+	db.AddCollection(api.DB_NAME, storage.INDEX_ALIAS_COLLECTION)
+
+	svc := &api.SearchService{DB: db, Ranking: api.LoadRankingConfig()}
+	svc.RefreshIndexAlias()
+	if *ranking != "" {
+		svc.Ranking.Model = *ranking
+	}
+	if err := svc.Ranking.Validate(); err != nil {
+		log.Fatalf("Invalid ranking: %v", err)
+	}
+
+	exhaustive, topk := &modeStats{}, &modeStats{}
+	mismatches := 0
+
+	for _, query := range queries {
+		var baseline, pruned *api.SearchResponse
+
+		// alternate modes so caching on the database side favors neither
+		for run := 0; run < *runs; run++ {
+			baseline = runQuery(svc, query, *limit, *offset, true, exhaustive)
+			pruned = runQuery(svc, query, *limit, *offset, false, topk)
+			if baseline == nil || pruned == nil {
+				break
+			}
+		}
+		if baseline == nil || pruned == nil {
+			continue
+		}
+
+		same := sameResults(baseline, pruned)
+		if !same {
+			mismatches++
+		}
+		fmt.Printf("%-40q total=%d/%d evaluated=%d/%d same=%v\n",
+			query,
+			pruned.TotalResults, baseline.TotalResults,
+			pruned.Stats.Evaluated, baseline.Stats.Evaluated,
+			same,
+		)
+	}
+
+	fmt.Printf("\n%d queries, %d runs each, k=%d, ranking=%s\n", len(queries), *runs, *offset+*limit, svc.Ranking.Model)
+	printStats("exhaustive", exhaustive)
+	printStats("top-k", topk)
+	if mean(topk.retrieval) > 0 {
+		fmt.Printf("retrieval speedup %.2fx\n", float64(mean(exhaustive.retrieval))/float64(mean(topk.retrieval)))
+	}
+	fmt.Printf("%d queries with different results\n", mismatches)
+	if mismatches > 0 {
+		os.Exit(1)
+	}
+}
+
+// Runs one search and records its latency, nil if it failed
+func runQuery(svc *api.SearchService, query string, limit, offset int, exhaustive bool, stats *modeStats) *api.SearchResponse {
+	start := time.Now()
+	resp, err := svc.Search(&api.SearchRequest{
+		Query:      query,
+		Limit:      limit,
+		Offset:     offset,
+		Ranking:    svc.Ranking,
+		Exhaustive: exhaustive,
+	})
+	if err != nil {
+		log.Printf("Query %q failed: %v\n", query, err)
+		return nil
+	}
+	stats.total = append(stats.total, time.Since(start))
+	stats.retrieval = append(stats.retrieval, resp.Stats.Retrieval)
+	stats.evaluated += resp.Stats.Evaluated
+	return resp
+}
+
+// Returns true if both responses rank the same documents in the same order
+func sameResults(a, b *api.SearchResponse) bool {
+	if len(a.Results) != len(b.Results) {
+		return false
+	}
+	for i := range a.Results {
+		if a.Results[i].DocID != b.Results[i].DocID {
+			return false
+		}
+	}
+	return true
+}
+
+// Prints mean and percentile latencies of a mode
+func printStats(name string, stats *modeStats) {
+	fmt.Printf("%-10s retrieval mean=%v p50=%v p95=%v | total mean=%v p50=%v p95=%v | evaluated=%d\n",
+		name,
+		mean(stats.retrieval), percentile(stats.retrieval, 0.5), percentile(stats.retrieval, 0.95),
+		mean(stats.total), percentile(stats.total, 0.5), percentile(stats.total, 0.95),
+		stats.evaluated,
+	)
+}
+
+// Returns the mean of durations, 0 if empty
+func mean(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	return sum / time.Duration(len(durations))
+}
+
+// Returns the p-th percentile of durations, 0 if empty
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// Reads non-empty lines of a file, lines starting with # are comments
+func readQueries(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var queries []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			queries = append(queries, line)
+		}
+	}
+	return queries, scanner.Err()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/clicks"
+	"github.com/Jailior/open-search/backend/internal/storage"
+)
+
+// Mongo database name
+const DB_NAME = "opensearch"
+
+/*
+Aggregates result impressions from the query log and recorded clicks into the
+position-bias corrected click-through of each (query, document), used by the API for ranking
+*/
+func main() {
+
+	// define flags
+	window := flag.Duration("window", 30*24*time.Hour, "Only impressions and clicks this recent are aggregated")
+
+	flag.Parse()
+
+	// connect to database
+	db := storage.MakeDB()
+	db.Connect()
+	defer db.Disconnect()
+
+	db.AddCollection(DB_NAME, storage.QUERY_LOG_COLLECTION)
+	db.AddCollection(DB_NAME, storage.CLICK_COLLECTION)
+	db.AddCollection(DB_NAME, storage.CLICK_STATS_COLLECTION)
+	db.AddCollection(DB_NAME, storage.GENERATION_COLLECTION)
+
+	since := time.Now().Add(-*window)
+
+	impressions, err := db.CountImpressions(since)
+	if err != nil {
+		log.Fatal("Failed to count impressions: ", err)
+	}
+	counted, err := db.CountClicks(since)
+	if err != nil {
+		log.Fatal("Failed to count clicks: ", err)
+	}
+
+	// click rate of the first positions, how strongly position biases clicks
+	ctr := clicks.PositionCTR(impressions, counted)
+	for i := 0; i < 10; i++ {
+		log.Printf("Position %d click rate %.4f\n", i+1, ctr[i])
+	}
+
+	stats := clicks.Aggregate(impressions, counted)
+	if err := db.SaveClickStats(stats); err != nil {
+		log.Fatal("Failed to save click stats: ", err)
+	}
+	log.Printf("Saved click-through of %d (query, document) pairs\n", len(stats))
+
+	// searches cached with the previous click-through are stale
+	if _, err := db.BumpGeneration(storage.SEARCH_GENERATION); err != nil {
+		log.Println("Failed to bump search generation: ", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Jailior/open-search/backend/internal/storage"
+)
+
+// Redis stream name for pages to be indexed
+const REDIS_INDEX_QUEUE = "pages_to_index"
+
+// Redis stream failed index messages are moved to
+const REDIS_DEAD_LETTER_QUEUE = "pages_to_index_dlq"
+
+// Fields added to a message when it is dead-lettered
+var deadLetterFields = map[string]bool{
+	"original_id":   true,
+	"source_stream": true,
+	"reason":        true,
+	"deliveries":    true,
+	"failed_at":     true,
+}
+
+/*
+Inspects, replays or purges the indexer dead-letter stream
+
+	deadletter list [-count N] [-stream NAME]
+	deadletter replay [-id ID] [-count N] [-stream NAME]
+	deadletter purge [-id ID] [-all] [-stream NAME]
+*/
+func main() {
+
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s list|replay|purge [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	// define flags of the subcommand, parsed after its name
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	stream := flags.String("stream", REDIS_DEAD_LETTER_QUEUE, "Dead-letter stream name")
+	var count *int64
+	var id *string
+	var all *bool
+	switch command {
+	case "list":
+		count = flags.Int64("count", 100, "Maximum number of messages to list")
+	case "replay":
+		count = flags.Int64("count", 100, "Maximum number of messages to replay")
+		id = flags.String("id", "", "Only replay the dead-letter message with this id")
+	case "purge":
+		id = flags.String("id", "", "Only purge the dead-letter message with this id")
+		all = flags.Bool("all", false, "Purge the entire dead-letter stream")
+	default:
+		usage()
+	}
+	flags.Parse(os.Args[2:])
+	if flags.NArg() != 0 {
+		usage()
+	}
+
+	rd := storage.MakeRedisClient()
+
+	switch command {
+	case "list":
+		list(rd, *stream, *count)
+	case "replay":
+		replay(rd, *stream, *id, *count)
+	case "purge":
+		purge(rd, *stream, *id, *all)
+	}
+}
+
+// Prints dead-lettered messages with their failure reason
+func list(rd *storage.RedisClient, stream string, count int64) {
+	messages, err := rd.RangeStream(stream, "-", "+", count)
+	if err != nil {
+		log.Fatalf("Failed to read dead-letter stream: %v", err)
+	}
+
+	for _, message := range messages {
+		fmt.Printf("%s\tpage=%v\tdeliveries=%v\tfailed_at=%v\treason=%v\n",
+			message.ID,
+			message.Values["id"],
+			message.Values["deliveries"],
+			message.Values["failed_at"],
+			message.Values["reason"],
+		)
+	}
+	fmt.Printf("%d dead-lettered messages shown\n", len(messages))
+}
+
+// Pushes dead-lettered messages back onto their source stream and removes them from the dead-letter stream
+func replay(rd *storage.RedisClient, stream, id string, count int64) {
+	// a single message or the oldest count messages
+	start, end := "-", "+"
+	if id != "" {
+		start, end = id, id
+	}
+	messages, err := rd.RangeStream(stream, start, end, count)
+	if err != nil {
+		log.Fatalf("Failed to read dead-letter stream: %v", err)
+	}
+
+	replayed := 0
+	for _, message := range messages {
+
+		// replay onto the stream the message came from
+		source, _ := message.Values["source_stream"].(string)
+		if source == "" {
+			source = REDIS_INDEX_QUEUE
+		}
+
+		// strip dead-letter metadata, keeping the original fields
+		values := make(map[string]interface{})
+		for key, value := range message.Values {
+			if !deadLetterFields[key] {
+				values[key] = value
+			}
+		}
+
+		if err := rd.PushValuesToStream(source, values); err != nil {
+			log.Printf("Failed to replay %s: %v\n", message.ID, err)
+			continue
+		}
+
+		if _, err := rd.DeleteFromStream(stream, message.ID); err != nil {
+			log.Printf("Replayed %s but failed to remove it from %s: %v\n", message.ID, stream, err)
+		}
+		replayed++
+	}
+	fmt.Printf("Replayed %d messages\n", replayed)
+}
+
+// Deletes a single dead-lettered message, or the whole dead-letter stream
+func purge(rd *storage.RedisClient, stream, id string, all bool) {
+	if id != "" {
+		deleted, err := rd.DeleteFromStream(stream, id)
+		if err != nil {
+			log.Fatalf("Failed to purge %s: %v", id, err)
+		}
+		fmt.Printf("Purged %d messages\n", deleted)
+		return
+	}
+
+	if !all {
+		log.Fatal("Refusing to purge the whole dead-letter stream without -all")
+	}
+	rd.ResetStream(stream)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Jailior/open-search/backend/internal/api"
+	"github.com/Jailior/open-search/backend/internal/cache"
+	"github.com/Jailior/open-search/backend/internal/ltr"
+	"github.com/Jailior/open-search/backend/internal/relevance"
+	"github.com/Jailior/open-search/backend/internal/storage"
+)
+
+// A ranking configuration under evaluation
+type config struct {
+	name string
+	svc  *api.SearchService
+
+	// applied as /search applies them, so the served ranking is measured
+	rewrite   bool
+	hostLimit int
+	diversity float64
+}
+
+/*
+Evaluates ranking configurations against a judgment list on the live index
+
+	evaluate -judgments judgments.tsv [-a params] [-b params] [-k 10] [-depth 100] [-threshold 1] [-min-delta d]
+
+A configuration is given as search parameters, e.g. "ranking=bm25&k1=1.5&alpha=0.4&boost=title:5&rewrite=false", plus
+index=<collection> to evaluate an index version other than the alias target and model=<path> to rerank with a
+LightGBM model. Query rewriting, host_limit and diversity default to those of /search, and results are ranked
+page by page as /search serves them. Reports nDCG@k, P@k, MAP and MRR of each configuration. With -b, the two are compared query by query,
+and with -min-delta the exit status is 1 unless b improves mean nDCG@k over a by at least the delta.
+*/
+func main() {
+
+	// define flags
+	judgmentsFile := flag.String("judgments", "", "Judgment list, tab separated query, url and grade per line, required")
+	paramsA := flag.String("a", "", "Parameters of the baseline configuration, defaults to the configured ranking")
+	paramsB := flag.String("b", "", "Parameters of a configuration compared against the baseline")
+	k := flag.Int("k", 10, "Cutoff of nDCG and precision")
+	depth := flag.Int("depth", 100, "Results retrieved per query for MAP and MRR")
+	threshold := flag.Int("threshold", 1, "Lowest grade counted as relevant by precision, MAP and MRR")
+	top := flag.Int("top", 10, "Queries with the largest changes shown when comparing")
+	minDelta := flag.Float64("min-delta", math.NaN(), "Fail unless b improves mean nDCG@k over a by at least this much")
+
+	flag.Parse()
+
+	if *judgmentsFile == "" || *k <= 0 || *depth < *k {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(*judgmentsFile)
+	if err != nil {
+		log.Fatalf("Failed to open judgments: %v", err)
+	}
+	judgments, err := ltr.ReadJudgments(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Failed to read judgments: %v", err)
+	}
+
+	// group judgments by query, keeping the order queries first appear in
+	var queries []string
+	grades := make(map[string]relevance.Grades)
+	for _, j := range judgments {
+		if grades[j.Query] == nil {
+			grades[j.Query] = make(relevance.Grades)
+			queries = append(queries, j.Query)
+		}
+		grades[j.Query][j.URL] = j.Grade
+	}
+
+	// connect to database
+	db := storage.MakeDB()
+	db.Connect()
+	defer db.Disconnect()
+	db.AddCollection(api.DB_NAME, api.COLL_NAME)
+	db.AddCollection(api.DB_NAME, "pages")
+	db.AddCollection(api.DB_NAME, "pagerank")
+	db.AddCollection(api.DB_NAME, storage.INDEX_ALIAS_COLLECTION)
+	db.AddCollection(api.DB_NAME, storage.REWRITE_RULES_COLLECTION)
+
+	configs := []*config{newConfig(db, "a", *paramsA)}
+	if *paramsB != "" {
+		configs = append(configs, newConfig(db, "b", *paramsB))
+	}
+
+	// metrics of each configuration, only for queries every configuration ran
+	results := make([][]relevance.Metrics, len(configs))
+	var evaluated []string
+	for _, query := range queries {
+		metrics := make([]relevance.Metrics, len(configs))
+		ok := true
+		for i, cfg := range configs {
+			ranking, err := cfg.rank(query, *depth)
+			if err != nil {
+				log.Printf("Skipping query %q: %v\n", query, err)
+				ok = false
+				break
+			}
+			metrics[i] = relevance.Evaluate(ranking, grades[query], *k, *threshold)
+		}
+		if !ok {
+			continue
+		}
+		evaluated = append(evaluated, query)
+		for i := range configs {
+			results[i] = append(results[i], metrics[i])
+		}
+	}
+	if len(evaluated) == 0 {
+		log.Fatal("No queries evaluated")
+	}
+
+	fmt.Printf("Evaluated %d of %d queries, relevant grade >= %d\n\n", len(evaluated), len(queries), *threshold)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "config\tnDCG@%d\tP@%d\tMAP\tMRR\tjudged@%d\t\n", *k, *k, *k)
+	means := make([]relevance.Metrics, len(configs))
+	for i, cfg := range configs {
+		means[i] = relevance.Mean(results[i])
+		m := means[i]
+		fmt.Fprintf(tw, "%s\t%.4f\t%.4f\t%.4f\t%.4f\t%.2f\t\n", cfg.name, m.NDCG, m.Precision, m.AP, m.RR, m.Judged)
+	}
+	if len(configs) == 2 {
+		a, b := means[0], means[1]
+		fmt.Fprintf(tw, "b - a\t%+.4f\t%+.4f\t%+.4f\t%+.4f\t\t\n", b.NDCG-a.NDCG, b.Precision-a.Precision, b.AP-a.AP, b.RR-a.RR)
+	}
+	tw.Flush()
+
+	if len(configs) < 2 {
+		return
+	}
+	compare(evaluated, results[0], results[1], *k, *top)
+
+	if !math.IsNaN(*minDelta) {
+		delta := means[1].NDCG - means[0].NDCG
+		if delta < *minDelta {
+			fmt.Printf("\nFAIL: nDCG@%d changed by %+.4f, required at least %+.4f\n", *k, delta, *minDelta)
+			os.Exit(1)
+		}
+		fmt.Printf("\nPASS: nDCG@%d changed by %+.4f\n", *k, delta)
+	}
+}
+
+// Returns a configuration from search parameters on top of the configured ranking
+func newConfig(db *storage.Database, name, params string) *config {
+	values, err := url.ParseQuery(params)
+	if err != nil {
+		log.Fatalf("Invalid parameters of %s: %v", name, err)
+	}
+
+	// pages of a query are served from one ranking, as they are by the API
+	svc := &api.SearchService{DB: db, Ranking: api.LoadRankingConfig(), Cache: cache.NewLRU(api.RESULT_CACHE_BYTES)}
+	if svc.Ranking, err = api.ParseRankingQuery(svc.Ranking, params); err != nil {
+		log.Fatalf("Invalid ranking of %s: %v", name, err)
+	}
+	cfg := &config{name: name, svc: svc, rewrite: values.Get("rewrite") != "false"}
+	if cfg.hostLimit, cfg.diversity, err = api.ParseDiversityQuery(params); err != nil {
+		log.Fatalf("Invalid diversity of %s: %v", name, err)
+	}
+
+	if index := values.Get("index"); index != "" {
+		svc.UseIndex(index)
+	} else {
+		svc.RefreshIndexAlias()
+	}
+	// click-through as served, neutral if clicks were never aggregated
+	if clicks, err := api.StartClickTracker(db); err == nil {
+		svc.Clicks = clicks
+	}
+	if path := values.Get("model"); path != "" {
+		if svc.Reranker, err = ltr.LoadModel(path); err != nil {
+			log.Fatalf("Failed to load model of %s: %v", name, err)
+		}
+	}
+	// synonyms, acronyms and admin rules as served
+	if cfg.rewrite {
+		svc.RefreshRewriteRules(api.LoadDictionaryRules())
+	}
+
+	log.Printf("Config %s: index %s, %+v, reranked %t, rewritten %t, host limit %d, diversity %g\n",
+		name, svc.IndexCollection(), svc.Ranking, svc.Reranker != nil, cfg.rewrite, cfg.hostLimit, cfg.diversity)
+	return cfg
+}
+
+// Returns the URLs of the best depth results of a query, best first
+// Results are fetched in pages of the default size, since the per host cap applies per page
+func (cfg *config) rank(query string, depth int) ([]string, error) {
+	var urls []string
+	for len(urls) < depth {
+		resp, err := cfg.svc.Search(&api.SearchRequest{
+			Query:     query,
+			Limit:     min(api.DEFAULT_PAGE_LIMIT, depth-len(urls)),
+			Offset:    len(urls),
+			Ranking:   cfg.svc.Ranking,
+			Rerank:    true,
+			Rewrite:   cfg.rewrite,
+			HostLimit: cfg.hostLimit,
+			Diversity: cfg.diversity,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range resp.Results {
+			urls = append(urls, result.URL)
+		}
+		if len(resp.Results) == 0 || resp.NextCursor == "" {
+			break
+		}
+	}
+	return urls, nil
+}
+
+// Prints the queries whose nDCG changed the most between a and b, and how many improved or regressed
+func compare(queries []string, a, b []relevance.Metrics, k, top int) {
+	order := make([]int, len(queries))
+	wins, losses := 0, 0
+	for i := range queries {
+		order[i] = i
+		switch delta := b[i].NDCG - a[i].NDCG; {
+		case delta > 1e-9:
+			wins++
+		case delta < -1e-9:
+			losses++
+		}
+	}
+	sort.SliceStable(order, func(x, y int) bool {
+		return math.Abs(b[order[x]].NDCG-a[order[x]].NDCG) > math.Abs(b[order[y]].NDCG-a[order[y]].NDCG)
+	})
+
+	fmt.Printf("\nImproved %d, regressed %d, unchanged %d queries\n", wins, losses, len(queries)-wins-losses)
+	fmt.Printf("\nLargest changes in nDCG@%d:\n", k)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "b - a\ta\tb\tquery")
+	for _, i := range order[:min(top, len(order))] {
+		delta := b[i].NDCG - a[i].NDCG
+		if math.Abs(delta) <= 1e-9 {
+			break
+		}
+		fmt.Fprintf(tw, "%+.4f\t%.4f\t%.4f\t%s\n", delta, a[i].NDCG, b[i].NDCG, queries[i])
+	}
+	tw.Flush()
+}
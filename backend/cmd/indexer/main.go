@@ -20,11 +20,16 @@ const REDIS_INDEX_QUEUE = "pages_to_index"
 // Redis stream consumer group
 const REDIS_STREAM_GROUP = "indexer_group"
 
+// Redis stream messages are moved to after exceeding their delivery budget
+const REDIS_DEAD_LETTER_QUEUE = "pages_to_index_dlq"
+
 func main() {
 
 	// initialize flags
 	workers := flag.Int("workers", 8, "Number of concurrent indexer workers")
 	reset := flag.Bool("reset", false, "Clear Redis indexer stream before indexing.")
+	maxDeliveries := flag.Int64("max-deliveries", indexer.DEFAULT_MAX_DELIVERIES, "Deliveries before a failing message is moved to the dead-letter stream")
+	claimIdle := flag.Duration("claim-idle", indexer.DEFAULT_CLAIM_IDLE, "Idle time before a pending message is claimed from a dead consumer")
 
 	flag.Parse()
 
@@ -37,6 +42,13 @@ func main() {
 	// Add raw page, inverted index collections
 	db.AddCollection(indexer.DB_NAME, indexer.PAGE_INSERT_COLLECTION)
 	db.AddCollection(indexer.DB_NAME, indexer.PAGE_INDEX_COLLECTION)
+	db.AddCollection(indexer.DB_NAME, storage.INDEX_ALIAS_COLLECTION)
+	db.AddCollection(indexer.DB_NAME, storage.GENERATION_COLLECTION)
+
+	// record modified terms so the API drops its cached postings of them
+	if err := db.EnsureIndexChanges(indexer.DB_NAME); err != nil {
+		log.Fatalf("Failed to create index change log: %v", err)
+	}
 
 	// add corpus stats document in inverted index
 	db.InitializeIndexCorpus(indexer.PAGE_INDEX_COLLECTION)
@@ -73,10 +85,13 @@ func main() {
 			defer wg.Done()
 			consumerName := fmt.Sprintf("indexer-%d", workerID)
 			idx := &indexer.Indexer{
-				Database:    db,
-				RedisClient: rd,
-				StreamName:  REDIS_INDEX_QUEUE,
-				GroupName:   REDIS_STREAM_GROUP,
+				Database:         db,
+				RedisClient:      rd,
+				StreamName:       REDIS_INDEX_QUEUE,
+				GroupName:        REDIS_STREAM_GROUP,
+				DeadLetterStream: REDIS_DEAD_LETTER_QUEUE,
+				MaxDeliveries:    *maxDeliveries,
+				ClaimIdle:        *claimIdle,
 			}
 			idx.RunWorker(ctx, consumerName)
 		}(i)
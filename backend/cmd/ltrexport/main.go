@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Jailior/open-search/backend/internal/api"
+	"github.com/Jailior/open-search/backend/internal/ltr"
+	"github.com/Jailior/open-search/backend/internal/storage"
+)
+
+/*
+Exports learning to rank training data from a judgment list, on the live index
+
+	ltrexport -judgments judgments.tsv [-out train.txt] [-candidates N] [-rewrite=false]
+
+Each judged document matching its query is written as an SVMlight/LETOR line, "grade qid:N 1:v1 2:v2 ... # doc_id url".
+With -candidates, the best N unjudged documents of each query are also written with grade 0.
+Feature names, in order, are written to the -out file with a .features suffix, for training with named features.
+*/
+func main() {
+
+	// define flags
+	judgmentsFile := flag.String("judgments", "", "Judgment list, tab separated query, url and grade per line, required")
+	out := flag.String("out", "", "Output file, defaults to stdout")
+	candidates := flag.Int("candidates", 0, "Best unjudged documents of each query also written as irrelevant")
+	ranking := flag.String("ranking", "", "Ranking model retrieving candidates, defaults to RANKING_MODEL or tfidf")
+	rewrite := flag.Bool("rewrite", true, "Rewrite queries with synonyms, acronyms and admin rules, as /search does")
+
+	flag.Parse()
+
+	if *judgmentsFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(*judgmentsFile)
+	if err != nil {
+		log.Fatalf("Failed to open judgments: %v", err)
+	}
+	judgments, err := ltr.ReadJudgments(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("Failed to read judgments: %v", err)
+	}
+
+	// connect to database
+	db := storage.MakeDB()
+	db.Connect()
+	defer db.Disconnect()
+	db.AddCollection(api.DB_NAME, api.COLL_NAME)
+	db.AddCollection(api.DB_NAME, "pages")
+	db.AddCollection(api.DB_NAME, "pagerank")
+	db.AddCollection(api.DB_NAME, storage.INDEX_ALIAS_COLLECTION)
+	db.AddCollection(api.DB_NAME, storage.REWRITE_RULES_COLLECTION)
+
+	svc := &api.SearchService{DB: db, Ranking: api.LoadRankingConfig()}
+	svc.RefreshIndexAlias()
+	if *ranking != "" {
+		svc.Ranking.Model = *ranking
+	}
+	if err := svc.Ranking.Validate(); err != nil {
+		log.Fatalf("Invalid ranking: %v", err)
+	}
+
+	// click-through features, neutral if clicks were never aggregated
+	if clicks, err := api.StartClickTracker(db); err == nil {
+		svc.Clicks = clicks
+	}
+	// features of the query as served, expanded terms included
+	if *rewrite {
+		svc.RefreshRewriteRules(api.LoadDictionaryRules())
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create output: %v", err)
+		}
+		defer file.Close()
+		w = bufio.NewWriter(file)
+
+		if err := os.WriteFile(*out+".features", []byte(strings.Join(ltr.FEATURE_NAMES, "\n")+"\n"), 0644); err != nil {
+			log.Fatalf("Failed to write feature names: %v", err)
+		}
+	}
+	defer w.Flush()
+
+	// group judgments by query, keeping the order queries first appear in
+	var queries []string
+	grades := make(map[string]map[string]int)
+	for _, j := range judgments {
+		if grades[j.Query] == nil {
+			grades[j.Query] = make(map[string]int)
+			queries = append(queries, j.Query)
+		}
+		grades[j.Query][j.URL] = j.Grade
+	}
+
+	written, missing := 0, 0
+	for qid, query := range queries {
+		urls := make([]string, 0, len(grades[query]))
+		for url := range grades[query] {
+			urls = append(urls, url)
+		}
+
+		rows, err := svc.ExtractFeatures(&api.SearchRequest{Query: query, Limit: *candidates, Ranking: svc.Ranking, Rewrite: *rewrite}, urls)
+		if err != nil {
+			log.Printf("Skipping query %q: %v\n", query, err)
+			continue
+		}
+
+		found := 0
+		for _, row := range rows {
+			grade, judged := grades[query][row.URL]
+			if judged {
+				found++
+			}
+			comment := fmt.Sprintf("%s %s", row.DocID, row.URL)
+			if err := ltr.WriteSVMLight(w, grade, qid+1, row.Features, comment); err != nil {
+				log.Fatalf("Failed to write features: %v", err)
+			}
+			written++
+		}
+		missing += len(urls) - found
+	}
+
+	log.Printf("Wrote %d rows for %d queries, %d judged documents did not match their query\n", written, len(queries), missing)
+}
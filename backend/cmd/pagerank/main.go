@@ -59,4 +59,27 @@ func main() {
 	if err != nil {
 		log.Fatal("Error saving PageRank scores: ", err)
 	}
+
+	// persist the graph so the API can serve inlinks, outlinks and host link stats
+	// the scores are saved already, so a failure here leaves the previous graph served
+	db.AddCollection(DB_NAME, storage.LINKS_COLLECTION)
+	db.AddCollection(DB_NAME, storage.LINK_EDGES_COLLECTION)
+	db.AddCollection(DB_NAME, storage.HOST_LINKS_COLLECTION)
+	if err := db.MakeLinkIndexes(); err != nil {
+		log.Println("Failed to make link graph indexes: ", err)
+	}
+	err = pagerank.SaveLinkGraph(graph, normRanks,
+		db.GetCollection(storage.LINKS_COLLECTION),
+		db.GetCollection(storage.LINK_EDGES_COLLECTION),
+		db.GetCollection(storage.HOST_LINKS_COLLECTION),
+		*db.GetContext())
+	if err != nil {
+		log.Println("Error saving link graph: ", err)
+	}
+
+	// searches cached with the previous scores and links are stale
+	db.AddCollection(DB_NAME, storage.GENERATION_COLLECTION)
+	if _, err := db.BumpGeneration(storage.SEARCH_GENERATION); err != nil {
+		log.Println("Failed to bump search generation: ", err)
+	}
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/Jailior/open-search/backend/internal/indexer"
+	"github.com/Jailior/open-search/backend/internal/storage"
+)
+
+/*
+Rebuilds the inverted index from scratch into a new versioned collection
+while the API keeps serving the current one, then atomically swaps the index alias.
+Also lists versions and rolls the alias back to the previous version.
+*/
+func main() {
+
+	// define flags
+	workers := flag.Int("workers", 8, "Number of concurrent indexing workers")
+	noSwap := flag.Bool("no-swap", false, "Build the new version without pointing the alias at it")
+	rollback := flag.Bool("rollback", false, "Point the alias back at the previous version and exit")
+	list := flag.Bool("list", false, "List index versions and the current alias target and exit")
+	drop := flag.String("drop", "", "Drop the given version collection and exit, refuses to drop the alias target")
+	maxFailures := flag.Int("max-failures", 0, "Pages allowed to fail to index before the build is marked failed and not swapped in")
+
+	flag.Parse()
+
+	// connect to database
+	db := storage.MakeDB()
+	db.Connect()
+	defer db.Disconnect()
+
+	// raw pages, alias and version metadata collections
+	db.AddCollection(indexer.DB_NAME, indexer.PAGE_INSERT_COLLECTION)
+	db.AddCollection(indexer.DB_NAME, storage.INDEX_ALIAS_COLLECTION)
+	db.AddCollection(indexer.DB_NAME, storage.INDEX_VERSION_COLLECTION)
+
+	// record modified terms so the API drops its cached postings of them
+	if err := db.EnsureIndexChanges(indexer.DB_NAME); err != nil {
+		log.Fatalf("Failed to create index change log: %v", err)
+	}
+
+	switch {
+	case *list:
+		listVersions(db)
+	case *rollback:
+		alias, err := db.RollbackAlias(indexer.PAGE_INDEX_COLLECTION)
+		if err != nil {
+			log.Fatal("Rollback failed: ", err)
+		}
+		log.Printf("Alias %s rolled back to %s (previous %s)\n", alias.Name, alias.Target, alias.Previous)
+	case *drop != "":
+		dropVersion(db, *drop)
+	default:
+		version, err := indexer.Reindex(db, *workers, !*noSwap, *maxFailures)
+		if err != nil {
+			log.Fatal("Reindex failed: ", err)
+		}
+		log.Printf("Built %s with %d documents in %s\n",
+			version.Collection, version.DocCount, version.FinishedAt.Sub(version.StartedAt))
+	}
+}
+
+// Prints every index version, marking the alias target and previous target
+func listVersions(db *storage.Database) {
+	alias, err := db.GetAlias(indexer.PAGE_INDEX_COLLECTION)
+	if err != nil {
+		log.Fatal("Failed to read alias: ", err)
+	}
+	versions, err := db.ListIndexVersions()
+	if err != nil {
+		log.Fatal("Failed to list index versions: ", err)
+	}
+
+	fmt.Printf("alias %s -> %s (previous %q)\n", alias.Name, alias.Target, alias.Previous)
+	for _, v := range versions {
+		marker := " "
+		if v.Collection == alias.Target {
+			marker = "*"
+		} else if v.Collection == alias.Previous {
+			marker = "-"
+		}
+		fmt.Printf("%s %-24s v%-3d %-9s analyzer=%s docs=%d failed=%d started=%s finished=%s\n",
+			marker, v.Collection, v.Version, v.Status, v.Analyzer, v.DocCount, v.Failed,
+			v.StartedAt.Format("2006-01-02 15:04:05"), v.FinishedAt.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// Drops an old version collection, never the one currently served
+func dropVersion(db *storage.Database, collectionname string) {
+	alias, err := db.GetAlias(indexer.PAGE_INDEX_COLLECTION)
+	if err != nil {
+		log.Fatal("Failed to read alias: ", err)
+	}
+	if collectionname == alias.Target {
+		log.Fatalf("Refusing to drop %s, it is the current alias target", collectionname)
+	}
+	if collectionname == alias.Previous {
+		log.Fatalf("Refusing to drop %s, it is the previous alias target -rollback returns to", collectionname)
+	}
+
+	// drop the index and its per-document stats
+	for _, name := range []string{collectionname, storage.DocStatsCollection(collectionname)} {
+		db.AddCollection(indexer.DB_NAME, name)
+		if err := db.DropCollection(name); err != nil {
+			log.Fatal("Failed to drop collection: ", err)
+		}
+		log.Println("Dropped ", name)
+	}
+}
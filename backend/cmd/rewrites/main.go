@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Jailior/open-search/backend/internal/api"
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/rewrite"
+	"github.com/Jailior/open-search/backend/internal/storage"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+/*
+Manages the query rewrite rules applied by the API on top of the synonym and acronym dictionaries
+
+	rewrites list
+	rewrites add [-replace] [-comment TEXT] "js, javascript" | "usa => united states"
+	rewrites enable|disable|remove -id ID
+	rewrites test "query"
+*/
+func main() {
+
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s list|add|enable|disable|remove|test [flags] [RULE|QUERY]\n", os.Args[0])
+		os.Exit(2)
+	}
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	// define flags of the subcommand, parsed after its name
+	command := os.Args[1]
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	var id, comment *string
+	var replace *bool
+	switch command {
+	case "list", "test":
+	case "add":
+		replace = flags.Bool("replace", false, "Search matches of a one-way rule as its rewrites only")
+		comment = flags.String("comment", "", "Note on why the rule was added")
+	case "enable", "disable", "remove":
+		id = flags.String("id", "", "Id of the rule to "+command)
+	default:
+		usage()
+	}
+	flags.Parse(os.Args[2:])
+	arg := strings.Join(flags.Args(), " ")
+
+	// connect to database
+	db := storage.MakeDB()
+	db.Connect()
+	defer db.Disconnect()
+
+	db.AddCollection(api.DB_NAME, storage.REWRITE_RULES_COLLECTION)
+	db.AddCollection(api.DB_NAME, storage.GENERATION_COLLECTION)
+
+	switch command {
+	case "list":
+		list(db)
+	case "add":
+		add(db, arg, *replace, *comment)
+	case "enable", "disable":
+		requireID(*id)
+		if err := db.SetRewriteRuleEnabled(*id, command == "enable"); err != nil {
+			log.Fatalf("Failed to %s rule %s: %v", command, *id, ruleError(err))
+		}
+		log.Printf("Rule %s %sd\n", *id, command)
+		bumpGeneration(db)
+	case "remove":
+		requireID(*id)
+		if err := db.DeleteRewriteRule(*id); err != nil {
+			log.Fatalf("Failed to remove rule %s: %v", *id, ruleError(err))
+		}
+		log.Printf("Rule %s removed\n", *id)
+		bumpGeneration(db)
+	case "test":
+		test(db, arg)
+	}
+}
+
+// Prints every admin rule, disabled ones included
+func list(db *storage.Database) {
+	rules, err := db.FetchRewriteRules(false)
+	if err != nil {
+		log.Fatalf("Failed to read rewrite rules: %v", err)
+	}
+	for _, rule := range rules {
+		fmt.Printf("%s\t%s\tenabled=%t\t%s", rule.ID.Hex(), rule.Type, rule.Enabled, strings.Join(rule.Match, ", "))
+		if len(rule.Rewrite) > 0 {
+			fmt.Printf(" => %s", strings.Join(rule.Rewrite, ", "))
+		}
+		if rule.Comment != "" {
+			fmt.Printf("\t# %s", rule.Comment)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d rewrite rules\n", len(rules))
+}
+
+// Adds a rule written in dictionary syntax, enabled
+func add(db *storage.Database, line string, replace bool, comment string) {
+	rule, err := rewrite.ParseRule(line)
+	if err != nil {
+		log.Fatalf("Invalid rule '%s': %v", line, err)
+	}
+	if replace {
+		if rule.Type != rewrite.RULE_ONEWAY {
+			log.Fatal("Only a one-way rule, matches => rewrites, can replace its matches")
+		}
+		rule.Type = rewrite.RULE_REPLACE
+	}
+	rule.Comment = comment
+
+	id, err := db.InsertRewriteRule(rule)
+	if err != nil {
+		log.Fatalf("Failed to add rule: %v", err)
+	}
+	log.Printf("Added %s rule %s\n", rule.Type, id)
+	bumpGeneration(db)
+}
+
+// Prints how a query is rewritten by the dictionaries and the enabled admin rules
+func test(db *storage.Database, query string) {
+	if query == "" {
+		log.Fatal("Missing query to rewrite")
+	}
+	rules, err := db.FetchRewriteRules(true)
+	if err != nil {
+		log.Fatalf("Failed to read rewrite rules: %v", err)
+	}
+	node, err := parsing.Parse(query, models.IndexedFields)
+	if err != nil {
+		log.Fatalf("Invalid query: %v", err)
+	}
+
+	rewriter := rewrite.NewRewriter(append(api.LoadDictionaryRules(), rules...))
+	fmt.Printf("query:     %s\n", node.String())
+	fmt.Printf("rewritten: %s\n", rewriter.Rewrite(node).String())
+}
+
+// Exits unless a rule id was given
+func requireID(id string) {
+	if id == "" {
+		log.Fatal("Missing -id of the rule")
+	}
+}
+
+// Returns a readable error for a rule lookup
+func ruleError(err error) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("no rule with this id")
+	}
+	return err
+}
+
+// Bumps the search generation, so results cached under the previous rules are no longer served
+// The API applies the change once it reloads the rules
+func bumpGeneration(db *storage.Database) {
+	if _, err := db.BumpGeneration(storage.SEARCH_GENERATION); err != nil {
+		log.Println("Failed to bump search generation: ", err)
+	}
+}
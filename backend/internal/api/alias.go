@@ -0,0 +1,58 @@
+package api
+
+import (
+	"log"
+	"time"
+)
+
+// Returns the index collection currently served, COLL_NAME until the alias is first resolved
+func (svc *SearchService) IndexCollection() string {
+	svc.indexMu.RLock()
+	defer svc.indexMu.RUnlock()
+	if svc.index == "" {
+		return COLL_NAME
+	}
+	return svc.index
+}
+
+// Resolves the index alias now and then every interval in the background,
+// so an alias swap or rollback is picked up without restarting the API
+func (svc *SearchService) WatchIndexAlias(interval time.Duration) {
+	svc.RefreshIndexAlias()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			svc.RefreshIndexAlias()
+		}
+	}()
+}
+
+// Resolves the index alias, keeping the current collection on error
+func (svc *SearchService) RefreshIndexAlias() {
+	target, err := svc.DB.ResolveAlias(COLL_NAME)
+	if err != nil {
+		log.Println("Failed to resolve index alias: ", err)
+		return
+	}
+
+	// collection must be known to the database before handlers use it
+	svc.DB.EnsureCollection(DB_NAME, target)
+
+	svc.indexMu.Lock()
+	defer svc.indexMu.Unlock()
+	if svc.index != target {
+		log.Printf("Serving index %s\n", target)
+		svc.index = target
+	}
+}
+
+// Serves a given index collection instead of the alias target, e.g. to evaluate an index version before swapping it in
+func (svc *SearchService) UseIndex(collection string) {
+	svc.DB.EnsureCollection(DB_NAME, collection)
+
+	svc.indexMu.Lock()
+	defer svc.indexMu.Unlock()
+	svc.index = collection
+}
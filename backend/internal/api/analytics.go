@@ -0,0 +1,140 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Default and longest window analytics are computed over
+const DEFAULT_ANALYTICS_WINDOW = 24 * time.Hour
+const MAX_ANALYTICS_WINDOW = 30 * 24 * time.Hour
+
+// Default and largest number of queries returned by analytics endpoints
+const DEFAULT_ANALYTICS_LIMIT = 20
+const MAX_ANALYTICS_LIMIT = 100
+
+// Latency percentiles reported, in percent
+var LATENCY_PERCENTILES = []float64{50, 90, 95, 99}
+
+// Lets through requests bearing the admin token, "Authorization: Bearer <token>"
+// Analytics reveal what users search for, so they are unavailable without a configured token
+func (svc *SearchService) RequireAdmin(c *gin.Context) {
+	if svc.AdminToken == "" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(svc.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+// Returns the most searched queries, e.g. /analytics/top-queries?window=7d&limit=50
+func (svc *SearchService) TopQueriesHandler(c *gin.Context) {
+	svc.respondQueryCounts(c, false)
+}
+
+// Returns the most searched queries that found nothing, e.g. /analytics/zero-result-queries?window=24h
+func (svc *SearchService) ZeroResultQueriesHandler(c *gin.Context) {
+	svc.respondQueryCounts(c, true)
+}
+
+// Returns search latency percentiles in milliseconds, e.g. /analytics/latency?window=1h
+func (svc *SearchService) LatencyHandler(c *gin.Context) {
+	if svc.QueryLog == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "query log unavailable"})
+		return
+	}
+	window, err := getAnalyticsWindow(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// aggregated by Mongo, the searches are never loaded
+	stats, err := svc.DB.QueryLatencyStats(storage.QueryLogFilter{Since: time.Now().Add(-window)}, LATENCY_PERCENTILES)
+	if err != nil {
+		log.Println("Failed to aggregate search latencies: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	resp := gin.H{"window": window.String(), "searches": stats.Searches}
+	if stats.Searches > 0 {
+		resp["mean_ms"] = stats.MeanMs
+		resp["max_ms"] = stats.MaxMs
+		resp["sampled"] = stats.Sampled
+		for i, p := range LATENCY_PERCENTILES {
+			if i < len(stats.Percentiles) {
+				resp[fmt.Sprintf("p%g_ms", p)] = stats.Percentiles[i]
+			}
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Responds with the most searched first page queries in the requested window, only those finding nothing if zeroResults
+func (svc *SearchService) respondQueryCounts(c *gin.Context, zeroResults bool) {
+	if svc.QueryLog == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "query log unavailable"})
+		return
+	}
+	window, err := getAnalyticsWindow(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit := DEFAULT_ANALYTICS_LIMIT
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = min(l, MAX_ANALYTICS_LIMIT)
+	}
+
+	queries, err := svc.DB.TopQueries(storage.QueryLogFilter{
+		Since:       time.Now().Add(-window),
+		ZeroResults: zeroResults,
+		FirstPage:   true,
+	}, limit)
+	if err != nil {
+		log.Println("Failed to aggregate query log: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"window": window.String(), "queries": queries})
+}
+
+// Parses the window param, a Go duration such as "90m" or a number of days such as "7d"
+func getAnalyticsWindow(c *gin.Context) (time.Duration, error) {
+	value := c.Query("window")
+	if value == "" {
+		return DEFAULT_ANALYTICS_WINDOW, nil
+	}
+
+	var window time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil {
+			return 0, fmt.Errorf("invalid window %q", value)
+		}
+	}
+
+	if window <= 0 || window > MAX_ANALYTICS_WINDOW {
+		return 0, fmt.Errorf("window must be positive and at most %s", MAX_ANALYTICS_WINDOW)
+	}
+	return window, nil
+}
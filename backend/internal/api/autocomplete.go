@@ -0,0 +1,195 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/storage"
+	"github.com/Jailior/open-search/backend/internal/suggest"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How often terms modified in the live index and titles of newly crawled pages are added to autocomplete
+const AUTOCOMPLETE_UPDATE_INTERVAL = time.Minute
+
+// How often autocomplete terms and titles are rebuilt from scratch, dropping titles of removed pages
+const AUTOCOMPLETE_REBUILD_INTERVAL = 24 * time.Hour
+
+// What autocomplete was built from, owned by the WatchAutocomplete goroutine
+type autocompleteProgress struct {
+	index    string                           // index collection terms were read from, rebuilt once the alias moves
+	lastPage primitive.ObjectID               // greatest _id of the pages whose titles were added
+	since    time.Time                        // index changes recorded before this were applied
+	seen     map[primitive.ObjectID]time.Time // changes applied within the overlap, so they are not applied twice
+	built    time.Time                        // last full build
+}
+
+// Default and largest number of completions returned
+const DEFAULT_SUGGEST_LIMIT = 8
+const MAX_SUGGEST_LIMIT = 20
+
+// Returns completions of a query prefix, e.g. /suggest?q=distributed%20sys
+func (svc *SearchService) SuggestHandler(c *gin.Context) {
+	prefix := c.Query("q")
+	if prefix == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing query"})
+		return
+	}
+	if len(prefix) > MAX_QUERY_LENGTH {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query too long"})
+		return
+	}
+	if svc.Suggester == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "autocomplete unavailable"})
+		return
+	}
+
+	limit := DEFAULT_SUGGEST_LIMIT
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = min(l, MAX_SUGGEST_LIMIT)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":       prefix,
+		"suggestions": svc.Suggester.Suggest(prefix, limit),
+	})
+}
+
+// Builds autocomplete terms and titles in the background, then adds modified terms and new titles every interval
+// It is rebuilt from scratch every AUTOCOMPLETE_REBUILD_INTERVAL and when the index alias moves to a new version
+// Searched queries are added as they come in and survive rebuilds, past ones are counted from the query log first
+func (svc *SearchService) WatchAutocomplete(interval time.Duration) {
+	go func() {
+		svc.SeedAutocomplete()
+		progress := &autocompleteProgress{}
+		svc.rebuildAutocomplete(progress)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if progress.index != svc.IndexCollection() || time.Since(progress.built) > AUTOCOMPLETE_REBUILD_INTERVAL {
+				svc.rebuildAutocomplete(progress)
+			} else {
+				svc.updateAutocomplete(progress)
+			}
+		}
+	}()
+}
+
+// Rebuilds autocomplete terms and titles, keeping the current ones on error
+func (svc *SearchService) rebuildAutocomplete(progress *autocompleteProgress) {
+	start := time.Now()
+	index := svc.IndexCollection()
+
+	dfs, err := svc.DB.FetchTermDFs(index, suggest.MIN_SUGGEST_DF)
+	if err != nil {
+		log.Println("Failed to fetch terms for autocomplete: ", err)
+		return
+	}
+	titles, lastPage, err := svc.DB.FetchPageTitlesAfter("pages", primitive.NilObjectID)
+	if err != nil {
+		log.Println("Failed to fetch titles for autocomplete: ", err)
+		return
+	}
+
+	svc.Suggester.Rebuild(dfs, titles)
+	*progress = autocompleteProgress{
+		index:    index,
+		lastPage: lastPage,
+		since:    start,
+		seen:     make(map[primitive.ObjectID]time.Time),
+		built:    start,
+	}
+	log.Printf("Built autocomplete from %d terms and %d titles in %s\n", len(dfs), len(titles), time.Since(start))
+}
+
+// Sets the document frequency of terms the indexer modified and adds titles of pages crawled since the last update
+// Whatever fails is retried on the next update
+func (svc *SearchService) updateAutocomplete(progress *autocompleteProgress) {
+	start := time.Now()
+
+	changes, err := svc.DB.FetchIndexChanges(progress.since.Add(-INDEX_CHANGES_OVERLAP))
+	if err != nil {
+		log.Println("Failed to fetch index changes for autocomplete: ", err)
+		return
+	}
+	modified := make(map[string]bool)
+	var applied []primitive.ObjectID
+	for _, change := range changes {
+		if _, ok := progress.seen[change.ID]; ok || change.Index != progress.index {
+			continue
+		}
+		applied = append(applied, change.ID)
+		for _, term := range change.Terms {
+			modified[term] = true
+		}
+	}
+
+	if len(modified) > 0 {
+		terms := make([]string, 0, len(modified))
+		for term := range modified {
+			terms = append(terms, term)
+		}
+		dfs, err := svc.DB.FetchTermDFsFor(terms, progress.index)
+		if err != nil {
+			log.Println("Failed to fetch modified terms for autocomplete: ", err)
+			return
+		}
+		// terms no longer indexed are missing, their completions are dropped
+		for _, term := range terms {
+			if _, ok := dfs[term]; !ok {
+				dfs[term] = 0
+			}
+		}
+		svc.Suggester.UpdateTerms(dfs)
+	}
+	for _, id := range applied {
+		progress.seen[id] = id.Timestamp()
+	}
+	// changes older than the overlap are never read again
+	for id, at := range progress.seen {
+		if start.Sub(at) > 2*INDEX_CHANGES_OVERLAP {
+			delete(progress.seen, id)
+		}
+	}
+	progress.since = start
+
+	titles, lastPage, err := svc.DB.FetchPageTitlesAfter("pages", progress.lastPage)
+	if err != nil {
+		log.Println("Failed to fetch new titles for autocomplete: ", err)
+		return
+	}
+	svc.Suggester.AddTitles(titles)
+	progress.lastPage = lastPage
+
+	if len(modified) > 0 || len(titles) > 0 {
+		log.Printf("Updated autocomplete with %d terms and %d titles in %s\n", len(modified), len(titles), time.Since(start))
+	}
+}
+
+// Counts first page searches with results in the query log towards popular query completions
+func (svc *SearchService) SeedAutocomplete() {
+	if svc.QueryLog == nil {
+		return
+	}
+	queries, err := svc.DB.TopQueries(storage.QueryLogFilter{WithResults: true, FirstPage: true}, suggest.MAX_RECORDED_QUERIES)
+	if err != nil {
+		log.Println("Failed to seed autocomplete from query log: ", err)
+		return
+	}
+	for _, q := range queries {
+		svc.Suggester.RecordQueryCount(q.Query, q.Count)
+	}
+	log.Printf("Seeded autocomplete with %d past queries\n", len(queries))
+}
+
+// Counts a search towards popular query completions, only first pages of searches with results count
+func (svc *SearchService) recordQuery(req *SearchRequest, resp *SearchResponse) {
+	if svc.Suggester == nil || req.Offset != 0 || resp.TotalResults == 0 {
+		return
+	}
+	svc.Suggester.RecordQuery(resp.Query)
+}
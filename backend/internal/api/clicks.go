@@ -0,0 +1,134 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/storage"
+	"github.com/Jailior/open-search/backend/internal/suggest"
+	"github.com/gin-gonic/gin"
+)
+
+// Size bounds of the capped clicks collection
+const CLICK_LOG_SIZE_BYTES = 64 << 20
+const CLICK_LOG_MAX_DOCS = 500000
+
+/*
+Links search results through the /click redirect and records the clicks
+
+Click links are signed so clicks can only be recorded for results actually shown for a query, the secret is read from
+CLICK_SECRET. Without it a random secret is generated on start, and links from before a restart redirect unrecorded.
+*/
+type ClickTracker struct {
+	db     *storage.Database
+	secret []byte
+}
+
+// Creates the clicks collection if needed and returns a tracker recording to it
+func StartClickTracker(db *storage.Database) (*ClickTracker, error) {
+	err := db.EnsureCappedCollection(DB_NAME, storage.CLICK_COLLECTION, CLICK_LOG_SIZE_BYTES, CLICK_LOG_MAX_DOCS)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.MakeClickIndexes(); err != nil {
+		return nil, err
+	}
+	db.AddCollection(DB_NAME, storage.CLICK_STATS_COLLECTION)
+
+	secret := []byte(os.Getenv("CLICK_SECRET"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &ClickTracker{db: db, secret: secret}, nil
+}
+
+// Returns the /click link of a result shown at position, counted from 1 across pages, for a normalized query
+func (ct *ClickTracker) ClickURL(query, docID string, position int) string {
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("doc", docID)
+	params.Set("pos", strconv.Itoa(position))
+	params.Set("sig", ct.sign(query, docID, position))
+	return "/click?" + params.Encode()
+}
+
+// Returns the signature of a click link
+func (ct *ClickTracker) sign(query, docID string, position int) string {
+	mac := hmac.New(sha256.New, ct.secret)
+	mac.Write([]byte(query + "\x00" + docID + "\x00" + strconv.Itoa(position)))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Returns a hash identifying the client without storing its address
+func (ct *ClickTracker) anonymize(clientIP string) string {
+	mac := hmac.New(sha256.New, ct.secret)
+	mac.Write([]byte("client\x00" + clientIP))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Records a click in the background if its link is correctly signed
+func (ct *ClickTracker) record(query, docID string, position int, sig, clientIP string) {
+	if position < 1 || !hmac.Equal([]byte(sig), []byte(ct.sign(query, docID, position))) {
+		return
+	}
+	click := &models.ClickEvent{
+		Query:    query,
+		DocID:    docID,
+		Position: position,
+		Client:   ct.anonymize(clientIP),
+		Time:     time.Now().UTC(),
+	}
+	go func() {
+		if err := ct.db.InsertClick(click); err != nil {
+			log.Println("Failed to record click: ", err)
+		}
+	}()
+}
+
+// Redirects to a result's page, recording the click, e.g. /click?q=golang&doc=<id>&pos=3&sig=<signature>
+// Only redirects to the stored URL of the document, never to a URL taken from the request
+func (svc *SearchService) ClickHandler(c *gin.Context) {
+	docID := c.Query("doc")
+	target, err := svc.DB.FetchPageURL(docID, "pages")
+	if err != nil {
+		log.Println("Failed to fetch clicked page: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document not found"})
+		return
+	}
+
+	if svc.Clicks != nil {
+		position, _ := strconv.Atoi(c.Query("pos"))
+		svc.Clicks.record(c.Query("q"), docID, position, c.Query("sig"), c.ClientIP())
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// Returns the click-through score of each document with click stats for a query, empty if click tracking is off
+func (svc *SearchService) fetchClickScores(query string) map[string]float64 {
+	if svc.Clicks == nil {
+		return nil
+	}
+	scores, err := svc.DB.FetchClickScores(suggest.NormalizeKey(query))
+	if err != nil {
+		log.Println("Failed to fetch click scores: ", err)
+		return nil
+	}
+	return scores
+}
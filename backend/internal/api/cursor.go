@@ -0,0 +1,113 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+
+	"github.com/Jailior/open-search/backend/internal/search"
+)
+
+// Most results returned per page, larger limits are capped
+const MAX_PAGE_LIMIT = 50
+
+// Deepest result reachable by paging, with offsets or cursors
+const MAX_RESULT_WINDOW = 1000
+
+// Returned when a cursor does not decode or continues a different search
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Position after the last result of a page, handed to clients as an opaque token to fetch the next page
+type Cursor struct {
+	Generation int64   `json:"g"` // search generation the page was ranked under
+	Index      string  `json:"i"` // index collection the page was ranked from
+	Offset     int     `json:"o"` // results before the next page
+	Score      float64 `json:"s"` // score of the last result
+	DocID      string  `json:"d"` // doc id of the last result
+	Request    string  `json:"r"` // hash of the query and ranking the cursor continues
+}
+
+// Returns the cursor as an opaque URL safe token
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decodes a cursor token, ErrInvalidCursor if it is malformed
+func DecodeCursor(token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Offset <= 0 || c.DocID == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Returns the hash of what a request's pages depend on, cursors only continue searches with the same hash
+// Returns an error if the request cannot be marshaled, such a request gets no cursors
+func (svc *SearchService) requestHash(req *SearchRequest) (string, error) {
+	data, err := json.Marshal(struct {
+		Ranking   resultCacheKey
+		Limit     int
+		HostLimit int
+		Diversity float64
+	}{svc.rankingKey(req), req.Limit, req.HostLimit, req.Diversity})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+// Returns the cursor after the last of a page's results in the arranged documents, nil if no results follow it
+func (svc *SearchService) nextCursor(req *SearchRequest, ranked *rankedResults, docs []rankedDoc, end int) *Cursor {
+	if end == 0 || end >= ranked.Total || end >= MAX_RESULT_WINDOW {
+		return nil
+	}
+	hash, err := svc.requestHash(req)
+	if err != nil {
+		return nil
+	}
+	last := docs[end-1]
+	// a non-finite score does not marshal into a token
+	if math.IsNaN(last.Score) || math.IsInf(last.Score, 0) {
+		return nil
+	}
+	return &Cursor{
+		Generation: ranked.generation,
+		Index:      ranked.index,
+		Offset:     end,
+		Score:      last.Score,
+		DocID:      last.DocID,
+		Request:    hash,
+	}
+}
+
+// Returns where the page after a cursor starts in the arranged documents
+// The cursor's document is looked for where it was, then anywhere, since rankings shift as the index changes
+// If it is no longer ranked, the page starts at the first document ranking after its score
+func cursorStart(docs []rankedDoc, c *Cursor) int {
+	if c.Offset <= len(docs) && docs[c.Offset-1].DocID == c.DocID {
+		return c.Offset
+	}
+	for i := range docs {
+		if docs[i].DocID == c.DocID {
+			return i + 1
+		}
+	}
+
+	// reranked and diversified documents are not ordered by score, so scan instead of searching
+	last := &search.ScoredDoc{DocID: c.DocID, Score: c.Score}
+	for i := range docs {
+		if search.Better(last, &search.ScoredDoc{DocID: docs[i].DocID, Score: docs[i].Score}) {
+			return i
+		}
+	}
+	return len(docs)
+}
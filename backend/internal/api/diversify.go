@@ -0,0 +1,203 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/gin-gonic/gin"
+)
+
+// Default most results from one host per page, host_limit=0 turns the cap off
+const DEFAULT_HOST_LIMIT = 2
+
+// Best documents reordered by MMR diversification, documents past it keep their order
+const MMR_DEPTH = 50
+
+// Returns the host cap and diversity of a search request, host_limit and diversity in [0, 1]
+func getDiversityQuery(c *gin.Context) (int, float64, error) {
+	return parseDiversity(c.Query)
+}
+
+// Returns the host cap and diversity of parameters in query string form, e.g. "host_limit=0&diversity=0.3"
+func ParseDiversityQuery(query string) (int, float64, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid diversity parameters: %w", err)
+	}
+	return parseDiversity(values.Get)
+}
+
+// Returns the host cap and diversity of the parameters returned by get, the defaults if unset
+func parseDiversity(get func(string) string) (int, float64, error) {
+	hostLimit := DEFAULT_HOST_LIMIT
+	if value := get("host_limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return 0, 0, fmt.Errorf("host_limit must be a non-negative integer")
+		}
+		hostLimit = parsed
+	}
+
+	diversity := 0.0
+	if value := get("diversity"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(parsed) || parsed < 0 || parsed > 1 {
+			return 0, 0, fmt.Errorf("diversity must be between 0 and 1")
+		}
+		diversity = parsed
+	}
+	return hostLimit, diversity, nil
+}
+
+// Returns ranked documents in the order they are paged through, diversified and capped per host as requested
+func arrange(docs []rankedDoc, req *SearchRequest) []rankedDoc {
+	docs = diversify(docs, req.Diversity)
+	return capHosts(docs, req.Limit, req.HostLimit)
+}
+
+/*
+Reorders the best MMR_DEPTH documents by maximal marginal relevance
+
+Documents are picked one at a time by (1 - diversity) * relevance - diversity * similarity,
+where similarity is the closest simhash to a document already picked.
+A diversity of 0 keeps the ranking as is.
+*/
+func diversify(docs []rankedDoc, diversity float64) []rankedDoc {
+	if diversity <= 0 || len(docs) < 2 {
+		return docs
+	}
+	candidates := docs[:min(len(docs), MMR_DEPTH)]
+
+	// relevance scaled to [0, 1] over the candidates, reranked scores can be negative
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, doc := range candidates {
+		lo = math.Min(lo, doc.Score)
+		hi = math.Max(hi, doc.Score)
+	}
+	relevance := func(doc *rankedDoc) float64 {
+		if hi == lo {
+			return 1
+		}
+		return (doc.Score - lo) / (hi - lo)
+	}
+
+	arranged := make([]rankedDoc, 0, len(docs))
+	picked := make([]bool, len(candidates))
+	closest := make([]float64, len(candidates)) // largest similarity to a picked document
+	for len(arranged) < len(candidates) {
+		best, bestValue := -1, math.Inf(-1)
+		for i := range candidates {
+			if picked[i] {
+				continue
+			}
+			// ties keep rank order
+			if value := (1-diversity)*relevance(&candidates[i]) - diversity*closest[i]; value > bestValue {
+				best, bestValue = i, value
+			}
+		}
+		// NaN scores compare false against everything, fall back to the first candidate left in rank order
+		if best == -1 {
+			for i := range candidates {
+				if !picked[i] {
+					best = i
+					break
+				}
+			}
+		}
+
+		picked[best] = true
+		arranged = append(arranged, candidates[best])
+		for i := range candidates {
+			if !picked[i] {
+				closest[i] = math.Max(closest[i], contentSimilarity(&candidates[i], &candidates[best]))
+			}
+		}
+	}
+	return append(arranged, docs[len(candidates):]...)
+}
+
+// Returns how alike two documents' contents are, from 0 for unrelated to 1 for duplicates
+// Unrelated simhashes agree on about half their bits, so only agreement past half counts
+func contentSimilarity(a, b *rankedDoc) float64 {
+	if a.Simhash == 0 || b.Simhash == 0 {
+		// indexed before fingerprints were stored
+		return 0
+	}
+	return math.Max(0, 2*parsing.SimhashSimilarity(a.Simhash, b.Simhash)-1)
+}
+
+// Reorders documents so each page of limit results has at most hostLimit from one host
+// Documents over a page's cap move on to the following pages in rank order,
+// and fill the page if too few documents from other hosts are left
+func capHosts(docs []rankedDoc, limit, hostLimit int) []rankedDoc {
+	if hostLimit <= 0 || limit <= 0 || len(docs) <= hostLimit {
+		return docs
+	}
+
+	arranged := make([]rankedDoc, 0, len(docs))
+	pending := docs
+	for len(pending) > 0 {
+		// pick a page in rank order, skipping documents of hosts at the cap
+		counts := make(map[string]int)
+		picked := make([]bool, len(pending))
+		n := 0
+		for i := 0; i < len(pending) && n < limit; i++ {
+			if counts[pending[i].Host] < hostLimit {
+				counts[pending[i].Host]++
+				picked[i] = true
+				n++
+			}
+		}
+		for i := 0; i < len(pending) && n < limit; i++ {
+			if !picked[i] {
+				picked[i] = true
+				n++
+			}
+		}
+
+		var deferred []rankedDoc
+		for i, doc := range pending {
+			if picked[i] {
+				arranged = append(arranged, doc)
+			} else {
+				deferred = append(deferred, doc)
+			}
+		}
+		pending = deferred
+	}
+	return arranged
+}
+
+// Marks the last result of each host at the cap with the number of later results from the host
+// and the query restricted to the host, to show "more results from this site"
+func groupHosts(page []*DocScore, pageDocs, later []rankedDoc, hostLimit int, query string) {
+	if hostLimit <= 0 {
+		return
+	}
+
+	counts := make(map[string]int)
+	last := make(map[string]int)
+	for i, doc := range pageDocs {
+		counts[doc.Host]++
+		last[doc.Host] = i
+	}
+
+	for host, count := range counts {
+		if count < hostLimit || host == "" {
+			continue
+		}
+		more := 0
+		for _, doc := range later {
+			if doc.Host == host {
+				more++
+			}
+		}
+		if more > 0 {
+			page[last[host]].MoreFromSite = more
+			page[last[host]].SiteQuery = query + " site:" + host
+		}
+	}
+}
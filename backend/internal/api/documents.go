@@ -0,0 +1,326 @@
+package api
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"unicode"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/search"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Terms of a document's term vector a more-like-this search is made of
+const MLT_TERMS = 25
+
+// Terms occurring fewer times in the document or in fewer documents of the index do not describe it
+const MLT_MIN_TERM_FREQ = 2
+const MLT_MIN_DF = 2
+
+// Terms in more than this fraction of documents are too common to tell similar documents apart
+const MLT_MAX_DF_RATIO = 0.5
+
+// Default and largest number of similar documents returned
+const DEFAULT_SIMILAR_LIMIT = 10
+const MAX_SIMILAR_LIMIT = 50
+
+// A stored document as crawled
+type DocumentResponse struct {
+	ID          string    `json:"doc_id"`
+	Title       string    `json:"title"`
+	URL         string    `json:"url"`
+	Headings    []string  `json:"headings"`
+	Crawled     time.Time `json:"crawled"`
+	Published   time.Time `json:"published,omitempty"`
+	Language    string    `json:"lang,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	PageRank    float64   `json:"pagerank"`
+	Outlinks    []string  `json:"outlinks"`
+	Content     string    `json:"content"`
+}
+
+// A document similar to another and how similar
+type SimilarDocument struct {
+	DocID string  `json:"doc_id"`
+	Title string  `json:"title"`
+	URL   string  `json:"url"`
+	Score float64 `json:"score"`
+}
+
+// Weight of a term in a document's term vector
+type termWeight struct {
+	term   string
+	weight float64
+	idf    float64
+}
+
+// Cached copy page, content is the escaped text with query terms in <strong>
+var cachedPageTemplate = template.Must(template.New("cache").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} (cached)</title>
+<style>
+body { font-family: sans-serif; max-width: 50rem; margin: 2rem auto; padding: 0 1rem; color: #222; }
+header { border: 1px solid #ccc; background: #f6f6f6; padding: 0.75rem 1rem; font-size: 0.9rem; }
+.content { white-space: pre-wrap; line-height: 1.5; }
+strong { background: #ffe066; font-weight: normal; }
+</style>
+</head>
+<body>
+<header>
+This is the stored copy of <a href="{{.URL}}">{{.URL}}</a> as crawled on {{.Crawled.Format "January 2, 2006 15:04 MST"}}. The current page may have changed.
+{{- if .Terms}} Highlighted terms:{{range .Terms}} <strong>{{.}}</strong>{{end}}.{{end}}
+</header>
+<h1>{{.Title}}</h1>
+{{range .Headings}}<h2>{{.}}</h2>
+{{end}}<div class="content">{{.Content}}</div>
+</body>
+</html>
+`))
+
+// Returns a stored document by id
+func (svc *SearchService) DocumentHandler(c *gin.Context) {
+	page, ok := svc.fetchDocument(c)
+	if !ok {
+		return
+	}
+
+	pageRanks, _ := svc.DB.FetchPageRankBatch([]string{page.URL})
+	c.JSON(http.StatusOK, DocumentResponse{
+		ID:          page.ID.Hex(),
+		Title:       page.Title,
+		URL:         page.URL,
+		Headings:    page.Headings,
+		Crawled:     page.TimeCrawled,
+		Published:   page.Published,
+		Language:    page.Language,
+		ContentType: page.ContentType,
+		PageRank:    pageRanks[page.URL],
+		Outlinks:    page.Outlinks,
+		Content:     page.Content,
+	})
+}
+
+// Renders the stored copy of a document as HTML, with the terms of the q query highlighted
+func (svc *SearchService) CachedPageHandler(c *gin.Context) {
+	var terms []string
+	if query := c.Query("q"); query != "" {
+		node, err := parsing.Parse(query, models.IndexedFields)
+		if err != nil {
+			respondQueryError(c, err)
+			return
+		}
+		terms = snippetTerms(parsing.QueryTerms(node))
+	}
+
+	page, ok := svc.fetchDocument(c)
+	if !ok {
+		return
+	}
+
+	content := search.HighlightAll(page.Content, terms)
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	err := cachedPageTemplate.Execute(c.Writer, struct {
+		Title    string
+		URL      string
+		Crawled  time.Time
+		Headings []string
+		Terms    []string
+		Content  template.HTML
+	}{page.Title, page.URL, page.TimeCrawled, page.Headings, terms, template.HTML(content.HTML())})
+	if err != nil {
+		log.Println("Failed to render cached page: ", err)
+	}
+}
+
+// Returns the documents most similar to a document by their terms, more-like-this
+func (svc *SearchService) SimilarHandler(c *gin.Context) {
+	limit := DEFAULT_SIMILAR_LIMIT
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = min(parsed, MAX_SIMILAR_LIMIT)
+	}
+
+	page, ok := svc.fetchDocument(c)
+	if !ok {
+		return
+	}
+
+	similar, err := svc.SimilarDocuments(page, limit)
+	if err != nil {
+		log.Println("Similar documents error: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"doc_id": page.ID.Hex(), "results": similar})
+}
+
+// Fetches the document of the :id parameter, responding with 400 or 404 and false if there is none
+func (svc *SearchService) fetchDocument(c *gin.Context) (*models.PageData, bool) {
+	id := c.Param("id")
+	if !primitive.IsValidObjectID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document id"})
+		return nil, false
+	}
+
+	page, err := svc.DB.FetchRawPage(id, "pages")
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return nil, false
+	}
+	if err != nil {
+		log.Println("Document fetch error: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	return page, true
+}
+
+/*
+Returns the limit documents most similar to a page, best first
+
+The page's term vector is its MLT_TERMS most distinctive terms by tf-idf. Every indexed document containing
+one of them is scored by the dot product of the vectors, over the norm of the page's vector.
+Exact duplicates of the page, by content fingerprint, are left out.
+*/
+func (svc *SearchService) SimilarDocuments(page *models.PageData, limit int) ([]SimilarDocument, error) {
+	index := svc.IndexCollection()
+	corpus, err := svc.DB.GetCorpusStats(index)
+	if err != nil {
+		return nil, err
+	}
+
+	// term frequencies of the page's title, headings and body
+	freqs := make(map[string]int)
+	for _, text := range append([]string{page.Title, page.Content}, page.Headings...) {
+		for term, positions := range parsing.TokenizeText(text) {
+			if len([]rune(term)) > 2 && !isNumber(term) {
+				freqs[term] += len(positions)
+			}
+		}
+	}
+	candidates := make([]string, 0, len(freqs))
+	for term, freq := range freqs {
+		if freq >= MLT_MIN_TERM_FREQ {
+			candidates = append(candidates, term)
+		}
+	}
+	if len(candidates) == 0 {
+		return []SimilarDocument{}, nil
+	}
+
+	dfs, err := svc.DB.FetchTermDFsFor(candidates, index)
+	if err != nil {
+		return nil, err
+	}
+	vector := termVector(freqs, dfs, corpus.TotalPages)
+	if len(vector) == 0 {
+		return []SimilarDocument{}, nil
+	}
+
+	terms := make([]string, len(vector))
+	norm := 0.0
+	for i, tw := range vector {
+		terms[i] = tw.term
+		norm += tw.weight * tw.weight
+	}
+	norm = math.Sqrt(norm)
+
+	entries, err := svc.fetchPostings(terms, index)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[string]termWeight, len(vector))
+	for _, tw := range vector {
+		weights[tw.term] = tw
+	}
+
+	// dot product of the page's vector with each document's tf-idf
+	id := page.ID.Hex()
+	fingerprint := int64(parsing.Simhash(page.Content))
+	scores := make(map[string]float64)
+	for _, entry := range entries {
+		tw := weights[entry.Term]
+		for i := range entry.Postings {
+			posting := &entry.Postings[i]
+			if posting.DocID == id || posting.URL == page.URL || (posting.Simhash != 0 && posting.Simhash == fingerprint) {
+				continue
+			}
+			scores[posting.DocID] += tw.weight * posting.TF * tw.idf / norm
+		}
+	}
+
+	ranked := make([]search.ScoredDoc, 0, len(scores))
+	for docID, score := range scores {
+		ranked = append(ranked, search.ScoredDoc{DocID: docID, Score: score})
+	}
+	search.SortScored(ranked)
+	ranked = ranked[:min(len(ranked), limit)]
+
+	ids := make([]string, len(ranked))
+	for i, doc := range ranked {
+		ids[i] = doc.DocID
+	}
+	rawPages, err := svc.DB.FetchRawPageBatch(ids, "pages")
+	if err != nil {
+		return nil, err
+	}
+	rawPageMap := make(map[string]models.PageData, len(rawPages))
+	for _, raw := range rawPages {
+		rawPageMap[raw.ID.Hex()] = raw
+	}
+
+	similar := make([]SimilarDocument, 0, len(ranked))
+	for _, doc := range ranked {
+		raw, ok := rawPageMap[doc.DocID]
+		if !ok {
+			continue
+		}
+		similar = append(similar, SimilarDocument{DocID: doc.DocID, Title: raw.Title, URL: raw.URL, Score: doc.Score})
+	}
+	return similar, nil
+}
+
+// Returns the MLT_TERMS terms of a document with the highest tf-idf, skipping terms too rare or too common in the index
+func termVector(freqs map[string]int, dfs map[string]int, totalPages int) []termWeight {
+	var vector []termWeight
+	for term, df := range dfs {
+		if df < MLT_MIN_DF || float64(df) > MLT_MAX_DF_RATIO*float64(totalPages) {
+			continue
+		}
+		idf := math.Log(float64(totalPages) / float64(df))
+		vector = append(vector, termWeight{term: term, weight: float64(freqs[term]) * idf, idf: idf})
+	}
+	sort.Slice(vector, func(i, j int) bool {
+		if vector[i].weight != vector[j].weight {
+			return vector[i].weight > vector[j].weight
+		}
+		return vector[i].term < vector[j].term
+	})
+	return vector[:min(len(vector), MLT_TERMS)]
+}
+
+// Returns true if a term is all digits
+func isNumber(term string) bool {
+	for _, r := range term {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
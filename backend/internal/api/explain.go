@@ -0,0 +1,137 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/Jailior/open-search/backend/internal/ltr"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/search"
+)
+
+/*
+Explains the score of each result of a page, set as its Explanation
+
+Documents are scored again by the same code ranking them, recording each component: per-term weights
+from the ranking model, proximity and click boosts, the PageRank blend and learned model re-scoring.
+The postings come from the query results are for, the corrected query if auto-corrected.
+*/
+func (svc *SearchService) explainResults(req *SearchRequest, ranked *rankedResults, page []*DocScore, start int) error {
+	explainReq := *req
+	explainReq.Query = ranked.Query
+	sc, err := svc.prepareSearch(&explainReq)
+	if err != nil {
+		return err
+	}
+	filters := explainFilters(sc.node, req.FacetFilters)
+
+	// position of each document by score, before host capping and diversification
+	positions := make(map[string]int, len(ranked.Docs))
+	for i := range ranked.Docs {
+		positions[ranked.Docs[i].DocID] = i
+	}
+
+	// postings of the page's documents
+	wanted := make(map[string]bool, len(page))
+	for _, result := range page {
+		wanted[result.DocID] = true
+	}
+	docs := make(map[string]search.DocPostings, len(page))
+	for e := range sc.entries {
+		entry := &sc.entries[e]
+		for i := range entry.Postings {
+			posting := &entry.Postings[i]
+			if !wanted[posting.DocID] {
+				continue
+			}
+			if docs[posting.DocID] == nil {
+				docs[posting.DocID] = make(search.DocPostings)
+			}
+			docs[posting.DocID][entry.Term] = posting
+		}
+	}
+
+	reranked := strings.HasSuffix(ranked.Ranking, "+ltr") && svc.Reranker != nil
+	for i, result := range page {
+		var exp *search.Explanation
+		position, ok := positions[result.DocID]
+
+		switch {
+		case parsing.IsLinkQuery(sc.node):
+			exp = search.NewExplanation(result.Score, "pagerank, pages linking to the URL of a link: query without search terms are ranked by PageRank")
+		case docs[result.DocID] == nil:
+			exp = search.NewExplanation(0, "no longer matches the query, the index changed since the result was ranked")
+		default:
+			exp = search.NewExplanation(0, "")
+			if _, matched := sc.score(result.DocID, docs[result.DocID], exp); !matched {
+				exp = search.NewExplanation(0, "no longer matches the query, the index changed since the result was ranked")
+				break
+			}
+			// the learned model re-scores the best documents, replacing their blended score
+			if reranked && ok && position < RERANK_DEPTH {
+				exp = explainRerank(sc, result.DocID, docs[result.DocID], svc.Reranker, exp)
+			}
+		}
+
+		if math.Abs(exp.Value-result.Score) > 1e-9 {
+			exp.Add(result.Score, "score when ranked, the index, PageRank or click-through changed since")
+		}
+		if ok && position != start+i {
+			exp.Add(float64(position+1), "position by score, moved by the per host cap or diversification")
+		}
+		if filters != nil {
+			exp.Details = append(exp.Details, filters)
+		}
+		result.Explanation = exp
+	}
+	return nil
+}
+
+// Explains the learned model's score of a document, with the blended score it replaces and the features it was given
+func explainRerank(sc *searchContext, docID string, doc search.DocPostings, model *ltr.Model, blended *search.Explanation) *search.Explanation {
+	features := sc.features(docID, doc)
+	exp := search.NewExplanation(model.Predict(features), fmt.Sprintf("ltr, %s model score replacing the blended score", model.Objective))
+
+	blended.Description = "blended score before re-ranking, " + blended.Description
+	exp.Details = append(exp.Details, blended)
+
+	featuresExp := exp.Add(0, "features")
+	for i, name := range ltr.FEATURE_NAMES {
+		featuresExp.Add(features[i], name)
+	}
+	return exp
+}
+
+// Returns the filters every result passed, nil if the query has none
+// Filters only narrow results and add nothing to their scores
+func explainFilters(node parsing.Node, facetFilters []search.FacetFilter) *search.Explanation {
+	required, excluded := parsing.TopLevelFilters(node)
+	if len(required) == 0 && len(excluded) == 0 && len(facetFilters) == 0 {
+		return nil
+	}
+
+	exp := search.NewExplanation(0, "filters, matched by every result without adding to its score")
+	for _, f := range required {
+		exp.Add(1, f.String())
+	}
+	for _, f := range excluded {
+		exp.Add(0, "-"+f.String())
+	}
+	for _, f := range facetFilters {
+		exp.Add(1, "facet "+f.Facet+":"+f.Value)
+	}
+	return exp
+}
+
+// Returns the label of a query term's weight, e.g. weight(title:golang), noting the words it was expanded from
+func termLabel(qt parsing.QueryTerm) string {
+	term := qt.Term
+	if qt.Field != "" {
+		term = qt.Field + ":" + qt.Term
+	}
+	if qt.Expansion != "" {
+		return "weight(" + term + "), expanded from " + qt.Expansion
+	}
+	return "weight(" + term + ")"
+}
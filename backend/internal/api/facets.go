@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Jailior/open-search/backend/internal/search"
+	"github.com/gin-gonic/gin"
+)
+
+// Default and largest number of values returned per facet
+const DEFAULT_FACET_LIMIT = 10
+const MAX_FACET_LIMIT = 100
+
+// Default bucket size of the crawl date facet
+const DEFAULT_DATE_INTERVAL = "month"
+
+// Returns the facets requested with facets=host,lang,type,date or facets=all, nil if none are requested
+func getFacetRequest(c *gin.Context) (*search.FacetRequest, error) {
+	param := c.Query("facets")
+	if param == "" || param == "false" {
+		return nil, nil
+	}
+
+	req := &search.FacetRequest{Limit: DEFAULT_FACET_LIMIT, DateInterval: DEFAULT_DATE_INTERVAL}
+	if param == "all" || param == "true" {
+		req.Facets = search.FACETS
+	} else {
+		seen := make(map[string]bool)
+		for _, name := range strings.Split(param, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if !search.IsFacet(name) {
+				return nil, fmt.Errorf("unknown facet %q, expected one of %s", name, strings.Join(search.FACETS, ", "))
+			}
+			if !seen[name] {
+				seen[name] = true
+				req.Facets = append(req.Facets, name)
+			}
+		}
+	}
+
+	if value := c.Query("facet_limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, fmt.Errorf("facet_limit must be a positive integer")
+		}
+		req.Limit = min(limit, MAX_FACET_LIMIT)
+	}
+	if value := c.Query("date_interval"); value != "" {
+		if _, ok := search.DATE_INTERVALS[value]; !ok {
+			return nil, fmt.Errorf("date_interval must be day, month or year")
+		}
+		req.DateInterval = value
+	}
+	return req, nil
+}
+
+// Returns the facet filters given with filter=facet:value, repeatable
+func getFacetFilters(c *gin.Context) ([]search.FacetFilter, error) {
+	var filters []search.FacetFilter
+	for _, param := range c.QueryArray("filter") {
+		filter, err := search.ParseFacetFilter(param)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return filters, nil
+}
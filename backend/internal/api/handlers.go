@@ -2,14 +2,21 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
-	"math"
 	"net/http"
-	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/cache"
+	"github.com/Jailior/open-search/backend/internal/ltr"
 	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/rewrite"
+	"github.com/Jailior/open-search/backend/internal/search"
 	"github.com/Jailior/open-search/backend/internal/storage"
+	"github.com/Jailior/open-search/backend/internal/suggest"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 )
@@ -17,16 +24,63 @@ import (
 // Database Wrapper
 type SearchService struct {
 	DB *storage.Database
+
+	// default ranking, requests may override it
+	Ranking search.RankingConfig
+
+	// query autocompletion, /suggest is unavailable if nil
+	Suggester *suggest.Suggester
+
+	// records searches for analytics, searches go unrecorded and /analytics is unavailable if nil
+	QueryLog *QueryLog
+
+	// links results through /click and records clicks, results link directly and rank without clicks if nil
+	Clicks *ClickTracker
+
+	// learned model re-scoring the best documents, ranking is the blend alone if nil
+	Reranker *ltr.Model
+
+	// caches decoded postings of hot terms, postings are always fetched from the index if nil
+	Postings *PostingsCache
+
+	// caches ranked documents per query and ranking, every search is ranked if nil
+	Cache cache.Cache
+
+	// bearer token required by /analytics, which is unavailable if empty
+	AdminToken string
+
+	// generation of the index, PageRank and click-through, part of every result cache key
+	generation atomic.Int64
+
+	indexMu sync.RWMutex
+	index   string // live index collection resolved from the COLL_NAME alias
+
+	spellerMu sync.RWMutex
+	speller   *suggest.Speller
+
+	rewriterMu sync.RWMutex
+	rewriter   *rewrite.Rewriter // synonyms, acronyms and admin rewrite rules, queries are searched as written if nil
 }
 
 // Returned struct by API, representing a page
 type DocScore struct {
-	DocID     string  `json:"doc_id"`
-	Title     string  `json:"title"`
-	URL       string  `json:"url"`
-	Snippet   string  `json:"snippet"`
-	Score     float64 `json:"score"`
-	Positions []int   `json:"-"`
+	DocID       string             `json:"doc_id"`
+	Title       string             `json:"title"`
+	URL         string             `json:"url"`
+	Snippet     string             `json:"snippet"`      // HTML escaped snippet with query terms in <strong>
+	SnippetText string             `json:"snippet_text"` // plain snippet the highlights index into
+	Highlights  []search.Highlight `json:"highlights"`
+	Score       float64            `json:"score"`
+	ClickURL    string             `json:"click_url,omitempty"` // /click redirect to the page, set if tracking
+
+	// later results from this result's host, set on the last result of a host at the per page cap
+	MoreFromSite int    `json:"more_from_site,omitempty"`
+	SiteQuery    string `json:"site_query,omitempty"` // the query restricted to the host
+
+	// how the score was computed, set if explaining
+	Explanation *search.Explanation `json:"explanation,omitempty"`
+
+	positions map[string][]int // body positions of the query terms, used for the snippet
 }
 
 // Health check endpoint
@@ -36,9 +90,7 @@ func HealthCheck(c *gin.Context) {
 
 // Query handler, main service
 func (svc *SearchService) SearchHandler(c *gin.Context) {
-
-	const alpha = 0.2               // tunable weight: 0.8 favors relevance (tf-IDF) and 0.2 favor authority (Page Rank Score)
-	const pageRankMultiplier = 10.0 // a weight applied to pageRankScore so that both tfIdf and pagerank are ~0.1
+	start := time.Now()
 
 	// Parse query checking if empty
 	query := c.Query("q")
@@ -47,144 +99,90 @@ func (svc *SearchService) SearchHandler(c *gin.Context) {
 		return
 	}
 	// sanitize user input, query
-	query = Sanitize(query)
-
-	// Parse query to return a list of non-stopword tokens
-	terms := parsing.TokenizeQuery(query)
-	if len(terms) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid terms found"})
+	query, err := Sanitize(query)
+	if err != nil {
+		respondQueryError(c, err)
 		return
 	}
 
-	// pagination parameters, either default or received in request
-	// doesn't use Sanitize since controlled by frontend only
-	limit := getLimitQuery(c)
-	offset := getOffsetQuery(c)
-
-	// fetch total doc count
-	docCount, _ := svc.DB.TotalDocCount(COLL_NAME)
-
-	// collected document overall scores
-	scores := map[string]*DocScore{}
-
-	// benchmark timing starts here
-	// timeStart := time.Now()
-
-	// batch fetch postings from inverted indexer collection
-	entries, err := svc.DB.FetchPostingsBatch(terms, COLL_NAME)
+	// scoring model and weights, the configured defaults unless overridden by the request
+	ranking, err := getRankingConfig(c, svc.Ranking)
 	if err != nil {
-		log.Println("Batch fetch error: ", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	// record time taken to batch fetch postings
-	// timeAfterPostingFetch := time.Now()
 
-	// extract all urls from postings
-	allURLs := make(map[string]struct{})
-	for _, entry := range entries {
-		for _, posting := range entry.Postings {
-			allURLs[posting.URL] = struct{}{}
-		}
+	// facets to count and facet values to filter by
+	facets, err := getFacetRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	// get all unique urls in postings
-	uniqueURLs := make([]string, 0, len(allURLs))
-	for url := range allURLs {
-		uniqueURLs = append(uniqueURLs, url)
-	}
-
-	// batch fetch all PageRank scores
-	pageRankCache, _ := svc.DB.FetchPageRankBatch(uniqueURLs)
-
-	// process entries
-	for _, entry := range entries {
-		// get idf score for term
-		idf := math.Log(float64(docCount) / float64(entry.DF))
-
-		for _, posting := range entry.Postings {
-			// calculate TF-IDF and PageRank scores
-			tfIdf := posting.TF * idf
-			rank, _ := pageRankCache[posting.URL]
-
-			// calculate blended score between TF-IDF and PageRank
-			weightedScore := alpha*tfIdf + (1-alpha)*rank
-
-			// Store document ID and posistions
-			if _, ok := scores[posting.DocID]; !ok {
-				scores[posting.DocID] = &DocScore{
-					DocID:     posting.DocID,
-					Positions: posting.Positions,
-				}
-			}
-			// append term score to overall score of page
-			scores[posting.DocID].Score += weightedScore
-		}
+	facetFilters, err := getFacetFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// used to mark time taken after processing all entries
-	// timeAfterEntries := time.Now()
-
-	// convert scores map to list for sorting
-	ranked := make([]*DocScore, 0, len(scores))
-	for _, doc := range scores {
-		ranked = append(ranked, doc)
+	// per host cap and diversification of the best results
+	hostLimit, diversity, err := getDiversityQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	// sort by score
-	sort.Slice(ranked, func(i int, j int) bool {
-		return ranked[i].Score > ranked[j].Score
-	})
-
-	// pagination slicing
-	numPages := len(ranked)
-	start := min(offset, numPages)
-	end := min(start+limit, numPages)
-	paged := ranked[start:end]
-
-	// create docIDs list from pages to use in batch fetching all raw pages
-	var docIDs []string = make([]string, 0)
-	for _, page := range paged {
-		docIDs = append(docIDs, page.DocID)
+	// pagination parameters, either default or received in request
+	// doesn't use Sanitize since controlled by frontend only
+	req := &SearchRequest{
+		Query:       query,
+		Limit:       getLimitQuery(c),
+		Offset:      getOffsetQuery(c),
+		Ranking:     ranking,
+		Suggest:     true,
+		AutoCorrect: c.DefaultQuery("autocorrect", "true") != "false",
+		Track:       c.Query("track") == "true",
+		Rerank:      c.DefaultQuery("rerank", "true") != "false",
+		Explain:     c.Query("explain") == "true",
+		Rewrite:     c.DefaultQuery("rewrite", "true") != "false",
+
+		Facets:       facets,
+		FacetFilters: facetFilters,
+		HostLimit:    hostLimit,
+		Diversity:    diversity,
 	}
 
-	// used to mark time after the several operations completed after processing entries
-	// timeAfterMisc := time.Now()
-
-	// batch fetch raw pages needed
-	rawPages, err := svc.DB.FetchRawPageBatch(docIDs, "pages")
-
-	// used to mark time after batch fetching raw pages
-	// timeAfterRawFetch := time.Now()
-
-	// map raw page by id
-	rawPageMap := make(map[string]models.PageData)
-	for _, page := range rawPages {
-		idHex := page.ID.Hex()
-		rawPageMap[idHex] = page
+	// continue after an earlier page, the cursor replaces offset
+	if token := c.Query("cursor"); token != "" {
+		req.Cursor, err = DecodeCursor(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Offset = req.Cursor.Offset
+	}
+	if req.Offset >= MAX_RESULT_WINDOW {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("results past the first %d are not available", MAX_RESULT_WINDOW)})
+		return
 	}
+	req.Limit = min(req.Limit, MAX_RESULT_WINDOW-req.Offset)
 
-	// get snippets from pages
-	for _, page := range paged {
-		rawPage, ok := rawPageMap[page.DocID]
-		if !ok {
-			continue
+	resp, err := svc.Search(req)
+	if err != nil {
+		var parseErr *parsing.ParseError
+		if errors.As(err, &parseErr) || errors.Is(err, ErrInvalidCursor) {
+			respondQueryError(c, err)
+			return
 		}
-
-		page.Snippet = getSnippet(page.Positions, rawPage.Content, terms[0])
-		page.Title = rawPage.Title
-		page.URL = rawPage.URL
+		log.Println("Search error: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
 	}
 
-	// used to mark time after getting all snippets
-	// timeAfterSnippets := time.Now()
-
-	// logging time taken for each step in api response
-	// log.Println("Time taken for fetch postings batch: ", timeAfterPostingFetch.Sub(timeStart))
-	// log.Println("Time taken for entries processing: ", timeAfterEntries.Sub(timeAfterPostingFetch))
-	// log.Println("Time taken for misc ops: ", timeAfterMisc.Sub(timeAfterEntries))
-	// log.Println("Time taken for fetching raw pages: ", timeAfterRawFetch.Sub(timeAfterMisc))
-	// log.Println("Time taken for processing snippts: ", timeAfterSnippets.Sub(timeAfterRawFetch))
-	// log.Println("Total Time: ", timeAfterSnippets.Sub(timeStart))
+	// count the query towards popular completions and log it for analytics
+	svc.recordQuery(req, resp)
+	if svc.QueryLog != nil {
+		svc.QueryLog.Record(req, resp, c.ClientIP(), time.Since(start))
+	}
 
 	// update metrics
 	err = svc.IncrementSearchNum()
@@ -193,12 +191,7 @@ func (svc *SearchService) SearchHandler(c *gin.Context) {
 	}
 
 	// return results and query
-	c.JSON(200, gin.H{
-		"query":        query,
-		"totalResults": numPages,
-		"results":      paged,
-	})
-
+	c.JSON(http.StatusOK, resp)
 }
 
 // Returns up to date metrics from stats stored in database
@@ -223,3 +216,20 @@ func (svc *SearchService) MetricsHandler(c *gin.Context) {
 	// return metrics
 	c.JSON(http.StatusOK, gin.H{"metrics": crawlerStats})
 }
+
+// Returns hits, misses and contents of the postings and result caches, null for a disabled cache
+func (svc *SearchService) CacheMetricsHandler(c *gin.Context) {
+	metrics := gin.H{"postings": nil, "results": nil}
+	if svc.Postings != nil {
+		metrics["postings"] = svc.Postings.Stats()
+	}
+	// a shared result cache is counted by its local tier
+	results := svc.Cache
+	if tiered, ok := results.(*cache.Tiered); ok {
+		results = tiered.Local
+	}
+	if lru, ok := results.(*cache.LRU[[]byte]); ok {
+		metrics["results"] = lru.Stats()
+	}
+	c.JSON(http.StatusOK, gin.H{"caches": metrics})
+}
@@ -2,7 +2,9 @@ package api
 
 import (
 	"context"
-	"regexp"
+	"errors"
+	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 
@@ -14,57 +16,12 @@ import (
 
 /* Helper Functions */
 
-// Gets a snippet from a page relevant to the term,
-// assumes positions points to the positions in the text where the term is present
-func getSnippet(positions []int, text string, term string) string {
-	if len(text) == 0 || len(positions) == 0 {
-		return ""
-	}
-
-	// split words into list
-	words := parsing.SplitWords(text)
-	// get first position of term
-	pos := positions[0]
-
-	// start and end positions of snippet
-	start := max(0, pos-10)
-	end := min(len(words), pos+10)
-
-	// limit to start and end
-	snippetWords := words[start:end]
-
-	// highlight/bold term in snippet
-	for i, word := range snippetWords {
-		if strings.EqualFold(word, term) {
-			snippetWords[i] = "<strong>" + word + "</strong>"
-		}
-	}
-
-	// join snippet back into string
-	snippet := strings.Join(snippetWords, " ")
-
-	// check if valid snippet
-	if len(snippet) > 0 {
-		snippet = strings.ToUpper(snippet[:1]) + snippet[1:]
-	}
-
-	// add elipsis
-	if start > 0 {
-		snippet = "..." + snippet
-	}
-	if end < len(words) {
-		snippet = snippet + "..."
-	}
-
-	return snippet
-}
-
 // Extracts the limit query from a search request
-// Returns DEFAULT_PAGE_LIMIT if not specified
+// Returns DEFAULT_PAGE_LIMIT if not specified, limits past MAX_PAGE_LIMIT are capped
 func getLimitQuery(c *gin.Context) int {
 	if l := c.Query("limit"); l != "" {
 		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 {
-			return parsed
+			return min(parsed, MAX_PAGE_LIMIT)
 		}
 	}
 	return DEFAULT_PAGE_LIMIT
@@ -97,16 +54,27 @@ func (svc *SearchService) IncrementSearchNum() error {
 	return err
 }
 
-// Sanitizes user input, removes leading and trailing whitespace
-// Must match allowable characters regex
-var validInput = regexp.MustCompile(`^[a-zA-Z0-9\s\-_.]+$`)
+// Longest query accepted, in bytes
+const MAX_QUERY_LENGTH = 512
 
-func Sanitize(input string) string {
+// Sanitizes user input, removes leading and trailing whitespace
+// Rejects overly long queries, the query parser validates the rest
+func Sanitize(input string) (string, error) {
 	safe := strings.TrimSpace(input)
-	if !validInput.MatchString(safe) {
-		return ""
+	if len(safe) > MAX_QUERY_LENGTH {
+		return "", &parsing.ParseError{Pos: MAX_QUERY_LENGTH, Msg: fmt.Sprintf("query longer than %d characters", MAX_QUERY_LENGTH)}
+	}
+	return safe, nil
+}
+
+// Responds with 400 and the error, including its position in the query for parse errors
+func respondQueryError(c *gin.Context, err error) {
+	var parseErr *parsing.ParseError
+	if errors.As(err, &parseErr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": parseErr.Error(), "position": parseErr.Pos})
+		return
 	}
-	return safe
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 }
 
 // Returns the min of a and b
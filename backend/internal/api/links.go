@@ -0,0 +1,283 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/search"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Ranking reported for link: queries without search terms
+const LINK_RANKING = "pagerank"
+
+// Term the pages linking to a URL are listed under when a link: query has no search terms
+const LINK_TERM = parsing.OP_LINK + ":"
+
+// A page of the link graph, its PageRank and number of links
+type LinkResponse struct {
+	URL             string  `json:"url"`
+	DocID           string  `json:"doc_id"`
+	Host            string  `json:"host"`
+	PageRank        float64 `json:"pagerank"`
+	Inlinks         int     `json:"inlinks"`
+	ExternalInlinks int     `json:"external_inlinks"` // inlinks from pages of other hosts
+	Outlinks        int     `json:"outlinks"`
+}
+
+// A page linking to or linked from another, with its PageRank
+type LinkedPage struct {
+	URL      string  `json:"url"`
+	PageRank float64 `json:"pagerank"`
+}
+
+// Returns a page's PageRank and link counts
+func (svc *SearchService) LinksHandler(c *gin.Context) {
+	node, ok := svc.fetchLinkNode(c)
+	if !ok {
+		return
+	}
+
+	pageRanks, _ := svc.DB.FetchPageRankBatch([]string{node.URL})
+	c.JSON(http.StatusOK, LinkResponse{
+		URL:             node.URL,
+		DocID:           node.DocID,
+		Host:            node.Host,
+		PageRank:        pageRanks[node.URL],
+		Inlinks:         node.Inlinks,
+		ExternalInlinks: node.ExternalInlinks,
+		Outlinks:        node.Outlinks,
+	})
+}
+
+// Returns the pages linking to a page, highest PageRank first
+func (svc *SearchService) InlinksHandler(c *gin.Context) {
+	node, ok := svc.fetchLinkNode(c)
+	if !ok {
+		return
+	}
+	svc.respondLinkedPages(c, node, true)
+}
+
+// Returns the crawled pages a page links to, highest PageRank first
+func (svc *SearchService) OutlinksHandler(c *gin.Context) {
+	node, ok := svc.fetchLinkNode(c)
+	if !ok {
+		return
+	}
+	svc.respondLinkedPages(c, node, false)
+}
+
+// Returns a host's pages, links within it, links from and to other hosts and the hosts linking to and from it most
+func (svc *SearchService) HostLinksHandler(c *gin.Context) {
+	host := strings.ToLower(strings.TrimSpace(c.Param("host")))
+	stats, err := svc.DB.FetchHostLinks(host)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Host not found in link graph"})
+		return
+	}
+	if err != nil {
+		log.Println("Host links fetch error: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// Fetches the link graph node of the url query, responding with 400 or 404 and false if there is none
+func (svc *SearchService) fetchLinkNode(c *gin.Context) (*models.LinkNode, bool) {
+	raw := strings.TrimSpace(c.Query("url"))
+	if raw == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url is required"})
+		return nil, false
+	}
+
+	candidates := linkURLCandidates(raw)
+	nodes, err := svc.DB.FetchLinkNodes(candidates)
+	if err != nil {
+		log.Println("Link graph fetch error: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	// candidates are in order of preference
+	for _, url := range candidates {
+		if node, ok := nodes[url]; ok {
+			return &node, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "URL not found in link graph"})
+	return nil, false
+}
+
+// Responds with a page of the pages linking to a page if inbound, else linked from it, sorted by PageRank
+func (svc *SearchService) respondLinkedPages(c *gin.Context, node *models.LinkNode, inbound bool) {
+	limit := getLimitQuery(c)
+	offset := getOffsetQuery(c)
+
+	edges, err := svc.DB.FetchLinkEdges(node.URL, inbound, offset, limit)
+	if err != nil {
+		log.Println("Link graph fetch error: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	pages := make([]LinkedPage, len(edges))
+	for i, edge := range edges {
+		pages[i] = LinkedPage{URL: edge.To, PageRank: edge.ToRank}
+		if inbound {
+			pages[i] = LinkedPage{URL: edge.From, PageRank: edge.FromRank}
+		}
+	}
+
+	total := node.Outlinks
+	if inbound {
+		total = node.Inlinks
+	}
+	c.JSON(http.StatusOK, gin.H{"url": node.URL, "total": total, "offset": offset, "links": pages})
+}
+
+// Returns the normalized URLs a link: value or url parameter may refer to, most likely first
+// A value without a scheme may be either https or http, and a path with or without a trailing slash
+func linkURLCandidates(raw string) []string {
+	var candidates []string
+	seen := make(map[string]bool)
+	add := func(rawURL string) {
+		url, err := parsing.NormalizeAndStripURL(rawURL)
+		if err != nil || seen[url] {
+			return
+		}
+		seen[url] = true
+		candidates = append(candidates, url)
+	}
+
+	bases := []string{raw}
+	if !strings.Contains(raw, "://") {
+		bases = []string{"https://" + raw, "http://" + raw}
+	}
+	for _, base := range bases {
+		add(base)
+		if strings.HasSuffix(base, "/") {
+			add(strings.TrimSuffix(base, "/"))
+		} else {
+			add(base + "/")
+		}
+	}
+	return candidates
+}
+
+// Resolves the pages linking to the URL of each link: filter in the query from the link graph
+func (svc *SearchService) resolveLinkFilters(node parsing.Node) error {
+	for _, filter := range parsing.FiltersOf(node, parsing.OP_LINK) {
+		inlinks, err := svc.DB.FetchInlinkURLs(linkURLCandidates(filter.Value))
+		if err != nil {
+			return err
+		}
+		filter.Linking = make(map[string]bool, len(inlinks))
+		for _, inlink := range inlinks {
+			filter.Linking[inlink] = true
+		}
+	}
+	return nil
+}
+
+/*
+Ranks the pages linking to a URL for a link: query without search terms, by PageRank
+
+The linking pages are listed as postings of one term from the attributes kept in the link graph,
+so the query's other filters and facets apply to them as to indexed documents.
+Pages containing an excluded term are left out.
+*/
+func (svc *SearchService) rankLinking(req *SearchRequest, sc *searchContext, depth int) (*rankedResults, error) {
+	retrievalStart := time.Now()
+
+	// every term fetched for a query without search terms is excluded
+	excluded := make(map[string]bool)
+	for _, entry := range sc.entries {
+		for i := range entry.Postings {
+			excluded[entry.Postings[i].DocID] = true
+		}
+	}
+
+	var urls []string
+	required, _ := parsing.TopLevelFilters(sc.node)
+	for _, filter := range required {
+		if filter.Op == parsing.OP_LINK {
+			for url := range filter.Linking {
+				urls = append(urls, url)
+			}
+			break
+		}
+	}
+	nodes, err := svc.DB.FetchLinkNodes(urls)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := models.TermEntry{Term: LINK_TERM, Postings: make([]models.IndexerPosting, 0, len(nodes))}
+	for _, node := range nodes {
+		if excluded[node.DocID] {
+			continue
+		}
+		entry.Postings = append(entry.Postings, models.IndexerPosting{
+			DocID:       node.DocID,
+			URL:         node.URL,
+			Host:        node.Host,
+			Date:        node.Date,
+			FileType:    parsing.URLFileType(node.URL),
+			Language:    node.Language,
+			ContentType: node.ContentType,
+			Crawled:     node.Crawled,
+			Simhash:     node.Simhash,
+		})
+	}
+	entries := []models.TermEntry{entry}
+	search.ApplyFilters(entries, sc.node)
+	facets := entries
+	if req.Facets != nil && len(req.FacetFilters) > 0 {
+		facets = search.CloneEntries(entries)
+	}
+	search.ApplyFacetFilters(entries, req.FacetFilters)
+	postings := entries[0].Postings
+
+	linkingURLs := make([]string, len(postings))
+	for i := range postings {
+		linkingURLs[i] = postings[i].URL
+	}
+	pageRanks, err := svc.DB.FetchPageRankBatch(linkingURLs)
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]rankedDoc, len(postings))
+	for i := range postings {
+		docs[i] = rankedDoc{
+			DocID:   postings[i].DocID,
+			Score:   pageRanks[postings[i].URL],
+			Host:    search.FacetValue(search.FACET_HOST, &postings[i], ""),
+			Simhash: uint64(postings[i].Simhash),
+		}
+	}
+	// ordered as ranked documents are, so cursors continue after the right page
+	sort.Slice(docs, func(i, j int) bool {
+		return search.Better(&search.ScoredDoc{DocID: docs[i].DocID, Score: docs[i].Score}, &search.ScoredDoc{DocID: docs[j].DocID, Score: docs[j].Score})
+	})
+
+	ranked := &rankedResults{
+		Query:   req.Query,
+		Ranking: LINK_RANKING,
+		Total:   len(docs),
+		Exact:   true,
+		Docs:    docs[:min(depth, len(docs))],
+		stats:   SearchStats{Retrieval: time.Since(retrievalStart), Evaluated: len(docs)},
+	}
+	if req.Facets != nil {
+		ranked.Facets = search.CountFacets(facets, &parsing.TermNode{QueryTerm: parsing.QueryTerm{Term: LINK_TERM}}, req.Facets, req.FacetFilters)
+	}
+	return ranked, nil
+}
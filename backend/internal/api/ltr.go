@@ -0,0 +1,98 @@
+package api
+
+import (
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/ltr"
+	"github.com/Jailior/open-search/backend/internal/search"
+)
+
+// Number of best documents re-scored by the learned ranking model, documents past it keep their order
+const RERANK_DEPTH = 100
+
+// A document's features for a query
+type FeatureRow struct {
+	DocID    string
+	URL      string
+	Features ltr.Vector
+}
+
+// Returns the learning to rank features of a candidate document
+func (sc *searchContext) features(docID string, doc search.DocPostings) ltr.Vector {
+	return ltr.Extract(&ltr.FeatureInput{
+		QueryTerms: sc.queryTerms,
+		TermStats:  sc.termStats,
+		Corpus:     sc.corpus,
+		Ranking:    sc.req.Ranking,
+		Doc:        doc,
+		PageRank:   sc.pageRanks[docURL(doc)],
+		Proximity:  proximityBoost(doc, sc.queryTerms),
+		ClickScore: sc.clickScores[docID],
+		Now:        time.Now(),
+	})
+}
+
+// Re-scores the first RERANK_DEPTH documents with a learned model and sorts them by its scores
+func (sc *searchContext) rerank(docs []search.ScoredDoc, model *ltr.Model) {
+	top := docs[:min(len(docs), RERANK_DEPTH)]
+	for i := range top {
+		top[i].Score = model.Predict(sc.features(top[i].DocID, top[i].Doc))
+	}
+	search.SortScored(top)
+}
+
+/*
+Returns the features of documents for a search request, used to export training data
+
+Rows are the best req.Offset+req.Limit documents as currently ranked, followed by the documents at urls not among them.
+Documents at urls not matching the query have no postings for it and are left out.
+*/
+func (svc *SearchService) ExtractFeatures(req *SearchRequest, urls []string) ([]FeatureRow, error) {
+	sc, err := svc.prepareSearch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []FeatureRow
+	seen := make(map[string]bool)
+	if k := req.Offset + req.Limit; k > 0 {
+		for _, scored := range sc.retrieve(k).Docs {
+			seen[scored.DocID] = true
+			rows = append(rows, FeatureRow{DocID: scored.DocID, URL: docURL(scored.Doc), Features: sc.features(scored.DocID, scored.Doc)})
+		}
+	}
+
+	// doc id of every document containing a query term, by URL
+	wanted := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		wanted[url] = true
+	}
+	docIDs := make(map[string]string)
+	for _, list := range sc.lists {
+		for i := range list.Postings {
+			if posting := &list.Postings[i]; wanted[posting.URL] {
+				docIDs[posting.URL] = posting.DocID
+			}
+		}
+	}
+
+	for _, url := range urls {
+		docID, ok := docIDs[url]
+		if !ok || seen[docID] {
+			continue
+		}
+		seen[docID] = true
+
+		doc := make(search.DocPostings)
+		for term, list := range sc.lists {
+			if posting := list.Find(docID); posting != nil {
+				doc[term] = posting
+			}
+		}
+		if !search.Matches(sc.node, doc) {
+			continue
+		}
+		rows = append(rows, FeatureRow{DocID: docID, URL: url, Features: sc.features(docID, doc)})
+	}
+	return rows, nil
+}
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/cache"
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Default size of the postings cache, overridden by POSTINGS_CACHE_BYTES
+const POSTINGS_CACHE_BYTES = 256 << 20
+
+// How often the index change log is read for modified terms
+const INDEX_CHANGES_POLL_INTERVAL = 2 * time.Second
+
+// Changes are read again this far back, so changes recorded late by another indexer are not missed
+const INDEX_CHANGES_OVERLAP = 10 * time.Second
+
+// Most searched queries whose terms are fetched into the postings cache on startup, and how far back they are counted
+const PRELOAD_QUERIES = 1000
+const PRELOAD_WINDOW = 7 * 24 * time.Hour
+
+// Terms fetched per query when preloading
+const PRELOAD_BATCH = 200
+
+// Estimated in-memory sizes of decoded postings, beyond their strings and positions
+const TERM_ENTRY_OVERHEAD = 64
+const POSTING_OVERHEAD = 160
+const FIELD_POSTING_OVERHEAD = 80
+
+// Decoded term entries of hot terms by index, terms missing from an index are cached as entries without postings
+// Entries are dropped when the indexer records a change to their term
+type PostingsCache struct {
+	lru *cache.LRU[models.TermEntry]
+
+	mu            sync.Mutex
+	epoch         uint64 // bumped by every invalidation, fetches started before it are not cached
+	invalidations int64  // terms dropped because the indexer modified them
+
+	// changes already applied, kept for INDEX_CHANGES_OVERLAP so re-read changes are skipped
+	seen      map[primitive.ObjectID]time.Time
+	pollSince time.Time
+}
+
+// Lookups, contents and invalidations of the postings cache
+type PostingsCacheStats struct {
+	cache.Stats
+	Invalidations int64 `json:"invalidations"`
+}
+
+// Returns an empty postings cache holding at most maxBytes of decoded postings
+func NewPostingsCache(maxBytes int) *PostingsCache {
+	return &PostingsCache{
+		lru:       cache.NewSizedLRU(maxBytes, termEntrySize),
+		seen:      make(map[primitive.ObjectID]time.Time),
+		pollSince: time.Now(),
+	}
+}
+
+// Makes the postings cache configured by POSTINGS_CACHE_BYTES, nil if it is 0
+func LoadPostingsCache() *PostingsCache {
+	size := POSTINGS_CACHE_BYTES
+	if value := os.Getenv("POSTINGS_CACHE_BYTES"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Printf("Invalid POSTINGS_CACHE_BYTES %q, using %d\n", value, POSTINGS_CACHE_BYTES)
+		} else {
+			size = parsed
+		}
+	}
+	if size == 0 {
+		return nil
+	}
+	return NewPostingsCache(size)
+}
+
+// Returns the cached entries of terms present in the index and the terms that are not cached
+// Entries are copies, their postings can be reordered and filtered without touching the cache
+func (pc *PostingsCache) Get(index string, terms []string) ([]models.TermEntry, []string) {
+	var entries []models.TermEntry
+	var missing []string
+	for _, term := range terms {
+		entry, ok := pc.lru.Get(postingsKey(index, term))
+		if !ok {
+			missing = append(missing, term)
+			continue
+		}
+		if entry.DF > 0 {
+			entry.Postings = append([]models.IndexerPosting(nil), entry.Postings...)
+			entries = append(entries, entry)
+		}
+	}
+	return entries, missing
+}
+
+// Returns the current epoch, taken before fetching entries to add
+func (pc *PostingsCache) Epoch() uint64 {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.epoch
+}
+
+// Caches the entries of fetched terms, terms without an entry are cached as missing from the index
+// Nothing is cached if a term was invalidated since epoch, the fetch may predate the change
+func (pc *PostingsCache) Add(index string, terms []string, entries []models.TermEntry, epoch uint64) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.epoch != epoch {
+		return
+	}
+
+	fetched := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		fetched[entry.Term] = true
+		entry.Postings = append([]models.IndexerPosting(nil), entry.Postings...)
+		pc.lru.Set(postingsKey(index, entry.Term), entry)
+	}
+	for _, term := range terms {
+		if !fetched[term] {
+			pc.lru.Set(postingsKey(index, term), models.TermEntry{Term: term})
+		}
+	}
+}
+
+// Drops the cached entries of terms modified in an index
+func (pc *PostingsCache) Invalidate(index string, terms []string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	pc.epoch++
+	for _, term := range terms {
+		if pc.lru.Remove(postingsKey(index, term)) {
+			pc.invalidations++
+		}
+	}
+}
+
+// Returns the lookups, contents and invalidations so far
+func (pc *PostingsCache) Stats() PostingsCacheStats {
+	pc.mu.Lock()
+	invalidations := pc.invalidations
+	pc.mu.Unlock()
+	return PostingsCacheStats{Stats: pc.lru.Stats(), Invalidations: invalidations}
+}
+
+// Reads changes recorded since the last poll and drops the modified terms
+// Only called by the WatchIndexChanges goroutine, which owns seen and pollSince
+func (pc *PostingsCache) poll(db *storage.Database) error {
+	start := time.Now()
+	changes, err := db.FetchIndexChanges(pc.pollSince.Add(-INDEX_CHANGES_OVERLAP))
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if _, ok := pc.seen[change.ID]; ok {
+			continue
+		}
+		pc.seen[change.ID] = change.ID.Timestamp()
+		pc.Invalidate(change.Index, change.Terms)
+	}
+
+	// changes older than the overlap are never read again
+	for id, at := range pc.seen {
+		if start.Sub(at) > 2*INDEX_CHANGES_OVERLAP {
+			delete(pc.seen, id)
+		}
+	}
+	pc.pollSince = start
+	return nil
+}
+
+// Returns the entries of terms in an index present in it, from the postings cache where cached
+func (svc *SearchService) fetchPostings(terms []string, index string) ([]models.TermEntry, error) {
+	pc := svc.Postings
+	if pc == nil {
+		return svc.DB.FetchPostingsBatch(terms, index)
+	}
+
+	entries, missing := pc.Get(index, terms)
+	if len(missing) == 0 {
+		return entries, nil
+	}
+
+	epoch := pc.Epoch()
+	fetched, err := svc.DB.FetchPostingsBatch(missing, index)
+	if err != nil {
+		return nil, err
+	}
+	pc.Add(index, missing, fetched, epoch)
+	return append(entries, fetched...), nil
+}
+
+// Reads the index change log every interval in the background, so postings modified by the indexer are fetched again
+func (svc *SearchService) WatchIndexChanges(interval time.Duration) {
+	if svc.Postings == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := svc.Postings.poll(svc.DB); err != nil {
+				log.Println("Failed to read index changes: ", err)
+			}
+		}
+	}()
+}
+
+// Fetches the terms of the most searched recent queries into the postings cache in the background
+func (svc *SearchService) PreloadPostings(queries int) {
+	if svc.Postings == nil || svc.QueryLog == nil {
+		return
+	}
+
+	go func() {
+		start := time.Now()
+		top, err := svc.DB.TopQueries(storage.QueryLogFilter{Since: time.Now().Add(-PRELOAD_WINDOW), FirstPage: true}, queries)
+		if err != nil {
+			log.Println("Failed to fetch top queries to preload: ", err)
+			return
+		}
+
+		// every term the queries fetch, skipping queries that no longer parse
+		var terms []string
+		seen := make(map[string]bool)
+		for _, count := range top {
+			node, err := parsing.Parse(count.Query, models.IndexedFields)
+			if err != nil {
+				continue
+			}
+			for _, term := range parsing.AllTerms(node) {
+				if !seen[term] {
+					seen[term] = true
+					terms = append(terms, term)
+				}
+			}
+		}
+
+		index := svc.IndexCollection()
+		for i := 0; i < len(terms); i += PRELOAD_BATCH {
+			batch := terms[i:min(i+PRELOAD_BATCH, len(terms))]
+			if _, err := svc.fetchPostings(batch, index); err != nil {
+				log.Println("Failed to preload postings: ", err)
+				return
+			}
+		}
+
+		stats := svc.Postings.Stats()
+		log.Printf("Preloaded postings of %d terms from %d queries in %s, %d bytes cached\n", len(terms), len(top), time.Since(start), stats.Bytes)
+	}()
+}
+
+// Returns the cache key of a term in an index
+func postingsKey(index, term string) string {
+	return index + "\x00" + term
+}
+
+// Returns the estimated in-memory size of a decoded term entry
+func termEntrySize(entry models.TermEntry) int {
+	size := TERM_ENTRY_OVERHEAD + len(entry.Term)
+	for i := range entry.Postings {
+		posting := &entry.Postings[i]
+		size += POSTING_OVERHEAD + len(posting.DocID) + len(posting.Title) + len(posting.URL) + len(posting.Host) + len(posting.FileType)
+		size += 8 * len(posting.Positions)
+		for field, fp := range posting.Fields {
+			size += FIELD_POSTING_OVERHEAD + len(field) + 8*len(fp.Positions)
+		}
+	}
+	return size
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/search"
+)
+
+// Extra positions between query terms within which a document gets a proximity boost
+const PROXIMITY_WINDOW = 10
+
+// Largest fraction of a document's text score added by the proximity boost
+// Multiplicative so it applies the same way to every ranking model
+const PROXIMITY_WEIGHT = 0.5
+
+// Returns a boost in (0, PROXIMITY_WEIGHT] if at least two query terms occur close together in the body, 0 otherwise
+// The boost is largest when the terms are adjacent, the text score is multiplied by 1 + boost
+// Only terms that can make the document match count, excluded terms are ignored
+func proximityBoost(doc search.DocPostings, terms []parsing.QueryTerm) float64 {
+	var lists [][]int
+	seen := make(map[string]bool)
+	for _, qt := range terms {
+		posting, ok := doc[qt.Term]
+		if !ok || seen[qt.Term] {
+			continue
+		}
+		if body := posting.BodyPositions(); len(body) > 0 {
+			seen[qt.Term] = true
+			lists = append(lists, body)
+		}
+	}
+	if len(lists) < 2 {
+		return 0
+	}
+
+	window := search.MinWindow(lists)
+	if window-len(lists) > PROXIMITY_WINDOW {
+		return 0
+	}
+	return PROXIMITY_WEIGHT * float64(len(lists)) / float64(window)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/storage"
+	"github.com/Jailior/open-search/backend/internal/suggest"
+)
+
+// Size bounds of the capped query log collection, the oldest searches are dropped beyond either
+const QUERY_LOG_SIZE_BYTES = 256 << 20
+const QUERY_LOG_MAX_DOCS = 1000000
+
+// Searches buffered before new ones are dropped, so a slow database never slows down searches
+const QUERY_LOG_BUFFER = 4096
+
+// Searches written per insert, and longest a search waits in the buffer
+const QUERY_LOG_BATCH = 200
+const QUERY_LOG_FLUSH_INTERVAL = 2 * time.Second
+
+/*
+Records searches to the capped query log collection in the background
+
+Client addresses are stored as a salted HMAC, the salt is read from QUERY_LOG_SALT. Without it a random salt
+is generated on start, so clients can only be told apart within one run of the API.
+*/
+type QueryLog struct {
+	db      *storage.Database
+	salt    []byte
+	entries chan models.QueryLogEntry
+	dropped atomic.Int64
+}
+
+// Creates the query log collection if needed and starts writing searches to it
+func StartQueryLog(db *storage.Database) (*QueryLog, error) {
+	err := db.EnsureCappedCollection(DB_NAME, storage.QUERY_LOG_COLLECTION, QUERY_LOG_SIZE_BYTES, QUERY_LOG_MAX_DOCS)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.MakeQueryLogIndexes(); err != nil {
+		return nil, err
+	}
+
+	salt := []byte(os.Getenv("QUERY_LOG_SALT"))
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	}
+
+	ql := &QueryLog{
+		db:      db,
+		salt:    salt,
+		entries: make(chan models.QueryLogEntry, QUERY_LOG_BUFFER),
+	}
+	go ql.run()
+	return ql, nil
+}
+
+// Queues a search to be logged, dropping it if the buffer is full
+func (ql *QueryLog) Record(req *SearchRequest, resp *SearchResponse, clientIP string, latency time.Duration) {
+	entry := models.QueryLogEntry{
+		Query:     suggest.NormalizeKey(req.Query),
+		Results:   resp.TotalResults,
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		Offset:    resp.Offset,
+		Limit:     req.Limit,
+		Client:    ql.anonymize(clientIP),
+		Time:      time.Now().UTC(),
+	}
+	if resp.OriginalQuery != "" {
+		entry.Corrected = suggest.NormalizeKey(resp.Query)
+	}
+	for _, result := range resp.Results {
+		entry.Docs = append(entry.Docs, result.DocID)
+	}
+
+	select {
+	case ql.entries <- entry:
+	default:
+		ql.dropped.Add(1)
+	}
+}
+
+// Writes queued searches in batches, at least every QUERY_LOG_FLUSH_INTERVAL
+func (ql *QueryLog) run() {
+	ticker := time.NewTicker(QUERY_LOG_FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	batch := make([]models.QueryLogEntry, 0, QUERY_LOG_BATCH)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := ql.db.InsertQueryLogs(batch); err != nil {
+			log.Printf("Failed to write %d searches to query log: %v\n", len(batch), err)
+		}
+		if dropped := ql.dropped.Swap(0); dropped > 0 {
+			log.Printf("Query log buffer full, dropped %d searches\n", dropped)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case entry := <-ql.entries:
+			batch = append(batch, entry)
+			if len(batch) == QUERY_LOG_BATCH {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Returns a salted hash identifying the client without storing its address
+func (ql *QueryLog) anonymize(clientIP string) string {
+	mac := hmac.New(sha256.New, ql.salt)
+	mac.Write([]byte(clientIP))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/search"
+	"github.com/gin-gonic/gin"
+)
+
+// Largest boost accepted from a request
+const MAX_FIELD_BOOST = 100.0
+
+/*
+Returns the ranking used by default, read from the environment:
+
+	RANKING_MODEL      tfidf, bm25 or bm25f
+	RANKING_ALPHA      weight of text relevance against PageRank, 0 to 1
+	RANKING_K1         BM25 term frequency saturation
+	RANKING_B          BM25 length normalization, 0 to 1
+	RANKING_BOOSTS     per-field boosts, e.g. title:3,body:1
+	RANKING_CLICKS     largest fraction of text relevance added or removed by click-through, 0 to 1
+	RANKING_EXPANSION  weight of terms added by synonyms and rewrite rules, 0 to 1
+
+Unset or invalid values keep their defaults
+*/
+func LoadRankingConfig() search.RankingConfig {
+	cfg := search.DefaultRankingConfig()
+
+	values := map[string]string{
+		"ranking":   os.Getenv("RANKING_MODEL"),
+		"alpha":     os.Getenv("RANKING_ALPHA"),
+		"k1":        os.Getenv("RANKING_K1"),
+		"b":         os.Getenv("RANKING_B"),
+		"boost":     os.Getenv("RANKING_BOOSTS"),
+		"clicks":    os.Getenv("RANKING_CLICKS"),
+		"expansion": os.Getenv("RANKING_EXPANSION"),
+	}
+	loaded, err := parseRankingConfig(cfg, func(key string) string { return values[key] })
+	if err != nil {
+		log.Printf("Invalid ranking config, using defaults: %v\n", err)
+		return cfg
+	}
+	return loaded
+}
+
+// Extracts ranking overrides from a search request, e.g. ranking=bm25&k1=1.5&b=0.5&alpha=0.6&boost=title:5&clicks=0&expansion=0.3
+// Parameters not given keep the values of base
+func getRankingConfig(c *gin.Context, base search.RankingConfig) (search.RankingConfig, error) {
+	return parseRankingConfig(base, c.Query)
+}
+
+// Applies ranking parameters in query string form, e.g. "ranking=bm25&k1=1.5&boost=title:5", on top of base
+func ParseRankingQuery(base search.RankingConfig, query string) (search.RankingConfig, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return base, fmt.Errorf("invalid ranking parameters: %w", err)
+	}
+	return parseRankingConfig(base, values.Get)
+}
+
+// Applies the ranking parameters returned by get on top of base, returns an error for malformed or out of range values
+func parseRankingConfig(base search.RankingConfig, get func(string) string) (search.RankingConfig, error) {
+	cfg := base.Clone()
+
+	if model := get("ranking"); model != "" {
+		cfg.Model = strings.ToLower(model)
+	}
+
+	params := []struct {
+		name  string
+		value *float64
+	}{
+		{"alpha", &cfg.Alpha},
+		{"k1", &cfg.K1},
+		{"b", &cfg.B},
+		{"clicks", &cfg.Clicks},
+		{"expansion", &cfg.Expansion},
+	}
+	for _, param := range params {
+		raw := get(param.name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(parsed) || math.IsInf(parsed, 0) {
+			return cfg, fmt.Errorf("%s must be a finite number", param.name)
+		}
+		*param.value = parsed
+	}
+
+	if raw := get("boost"); raw != "" {
+		if err := parseFieldBoosts(raw, cfg.Boosts); err != nil {
+			return cfg, err
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Parses per-field boosts such as title:5,body:0.5 into boosts, fields not given keep their boost
+func parseFieldBoosts(raw string, boosts map[string]float64) error {
+	for _, pair := range strings.Split(raw, ",") {
+		field, value, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || !models.IsIndexedField(field) {
+			return fmt.Errorf("invalid boost '%s', expected field:weight with field one of %s", pair, strings.Join(models.IndexedFields, ", "))
+		}
+		boost, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(boost) || boost < 0 || boost > MAX_FIELD_BOOST {
+			return fmt.Errorf("boost for %s must be between 0 and %g", field, MAX_FIELD_BOOST)
+		}
+		boosts[field] = boost
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/cache"
+	"github.com/Jailior/open-search/backend/internal/search"
+	"github.com/Jailior/open-search/backend/internal/storage"
+)
+
+// Default size of the in-process result cache, overridden by RESULT_CACHE_BYTES
+const RESULT_CACHE_BYTES = 64 << 20
+
+// Documents ranked and cached per query, so the following pages are served from the cache
+const RESULT_CACHE_DEPTH = 100
+
+// Longest a result stays in Redis, a safety net since the generation already invalidates stale results
+const RESULT_CACHE_TTL = 30 * time.Minute
+
+// How often the search generation is polled, bounds how long stale results are served after a bump
+const GENERATION_POLL_INTERVAL = 5 * time.Second
+
+// Snippet positions kept per query term of each ranked document
+const MAX_SNIPPET_POSITIONS = 64
+
+// Ranked documents of a search, what the result cache stores
+type rankedResults struct {
+	Query         string      `json:"query"` // query the documents were ranked for, the correction if auto-corrected
+	OriginalQuery string      `json:"original_query,omitempty"`
+	Suggestion    string      `json:"suggestion,omitempty"`
+	Ranking       string      `json:"ranking"`
+	Total         int         `json:"total"`
+	Exact         bool        `json:"exact"`
+	Terms         []string    `json:"terms"` // query terms highlighted in snippets
+	Docs          []rankedDoc `json:"docs"`
+
+	Facets map[string][]search.FacetCount `json:"facets,omitempty"`
+
+	generation int64  // search generation the documents were ranked under
+	index      string // index collection the documents were ranked from
+	stats      SearchStats
+}
+
+// A ranked document with what diversification needs and the body positions of the query terms its snippet is made from
+type rankedDoc struct {
+	DocID     string           `json:"id"`
+	Score     float64          `json:"score"`
+	Host      string           `json:"host"`
+	Simhash   uint64           `json:"simhash,omitempty"` // content fingerprint, 0 if not indexed
+	Positions map[string][]int `json:"positions,omitempty"`
+}
+
+// Everything a search's ranking depends on, hashed into its cache key
+type resultCacheKey struct {
+	Generation  int64                `json:"generation"`
+	Index       string               `json:"index"`
+	Query       string               `json:"query"`
+	Ranking     search.RankingConfig `json:"ranking"`
+	Rerank      bool                 `json:"rerank"`
+	Suggest     bool                 `json:"suggest"`
+	AutoCorrect bool                 `json:"autocorrect"`
+	Rewrites    string               `json:"rewrites,omitempty"` // version of the rewrite rules applied
+
+	Facets       *search.FacetRequest `json:"facets,omitempty"`
+	FacetFilters []search.FacetFilter `json:"facet_filters,omitempty"`
+}
+
+// Makes the result cache configured by the environment, nil if RESULT_CACHE_BYTES is 0
+// An in-process LRU of RESULT_CACHE_BYTES, backed by Redis at REDIS_ADDR if RESULT_CACHE_REDIS is true,
+// so replicas share results
+func LoadResultCache() cache.Cache {
+	size := RESULT_CACHE_BYTES
+	if value := os.Getenv("RESULT_CACHE_BYTES"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Printf("Invalid RESULT_CACHE_BYTES %q, using %d\n", value, RESULT_CACHE_BYTES)
+		} else {
+			size = parsed
+		}
+	}
+	if size == 0 {
+		return nil
+	}
+
+	local := cache.NewLRU(size)
+	if os.Getenv("RESULT_CACHE_REDIS") != "true" {
+		return local
+	}
+
+	rdb := storage.MakeRedisClient()
+	if err := rdb.Client.Ping(rdb.Ctx).Err(); err != nil {
+		log.Println("Failed to reach Redis, caching results in process only: ", err)
+		return local
+	}
+	return &cache.Tiered{
+		Local:  local,
+		Shared: cache.NewRedis(rdb.Client, "results:", RESULT_CACHE_TTL),
+	}
+}
+
+// Returns the search generation results are cached under
+func (svc *SearchService) Generation() int64 {
+	return svc.generation.Load()
+}
+
+// Reads the search generation now and then every interval in the background,
+// so results cached before the index, PageRank or click-through changed are no longer served
+func (svc *SearchService) WatchGeneration(interval time.Duration) {
+	svc.RefreshGeneration()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			svc.RefreshGeneration()
+		}
+	}()
+}
+
+// Reads the search generation, keeping the current one on error
+func (svc *SearchService) RefreshGeneration() {
+	generation, err := svc.DB.GetGeneration(storage.SEARCH_GENERATION)
+	if err != nil {
+		log.Println("Failed to read search generation: ", err)
+		return
+	}
+	if svc.generation.Swap(generation) != generation {
+		log.Printf("Search generation %d\n", generation)
+	}
+}
+
+// Returns the ranked documents up to the requested page, from the result cache if they are cached
+// A cursor's page comes from the ranking the cursor was made from while it is still cached
+// Misses are ranked in steps of RESULT_CACHE_DEPTH so the following pages hit
+func (svc *SearchService) cachedRank(req *SearchRequest) (*rankedResults, error) {
+	generation, index := svc.Generation(), svc.IndexCollection()
+	end := req.Offset + req.Limit
+	if req.Cursor != nil {
+		end = req.Cursor.Offset + req.Limit
+	}
+
+	// a request whose key cannot be marshaled is ranked uncached rather than sharing an empty key
+	key, keyErr := svc.resultCacheKey(req, generation, index)
+	if svc.Cache == nil || req.Exhaustive || keyErr != nil {
+		ranked, err := svc.rank(req, end)
+		if err != nil {
+			return nil, err
+		}
+		ranked.generation, ranked.index = generation, index
+		return ranked, nil
+	}
+
+	if req.Cursor != nil {
+		if cursorKey, err := svc.resultCacheKey(req, req.Cursor.Generation, req.Cursor.Index); err == nil {
+			if ranked := svc.cachedResults(cursorKey, req.Cursor.Generation, req.Cursor.Index, end); ranked != nil {
+				return ranked, nil
+			}
+		}
+	}
+	if ranked := svc.cachedResults(key, generation, index, end); ranked != nil {
+		return ranked, nil
+	}
+
+	depth := (end + RESULT_CACHE_DEPTH - 1) / RESULT_CACHE_DEPTH * RESULT_CACHE_DEPTH
+	ranked, err := svc.rank(req, max(depth, RESULT_CACHE_DEPTH))
+	if err != nil {
+		return nil, err
+	}
+	ranked.generation, ranked.index = generation, index
+	if data, err := json.Marshal(ranked); err == nil {
+		svc.Cache.Set(key, data)
+	}
+	return ranked, nil
+}
+
+// Returns the ranking cached under a request's key for a generation and index, nil unless it covers the documents up to end
+func (svc *SearchService) cachedResults(key string, generation int64, index string, end int) *rankedResults {
+	data, ok := svc.Cache.Get(key)
+	if !ok {
+		return nil
+	}
+	var ranked rankedResults
+	if json.Unmarshal(data, &ranked) != nil {
+		return nil
+	}
+	// cached results cover the page if ranked deep enough or if they hold every match
+	if len(ranked.Docs) < end && !(ranked.Exact && len(ranked.Docs) == ranked.Total) {
+		return nil
+	}
+	ranked.generation, ranked.index = generation, index
+	ranked.stats.Cached = true
+	return &ranked
+}
+
+// Returns the cache key of a request's ranking under a generation and index
+// Pagination and tracking are left out since they do not change the ranking
+// Returns an error if the key cannot be marshaled, e.g. for a non-finite ranking parameter
+func (svc *SearchService) resultCacheKey(req *SearchRequest, generation int64, index string) (string, error) {
+	key := svc.rankingKey(req)
+	key.Generation = generation
+	key.Index = index
+	if req.Rewrite {
+		key.Rewrites = svc.Rewriter().Version()
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Returns what a request's ranking depends on besides the generation and index
+func (svc *SearchService) rankingKey(req *SearchRequest) resultCacheKey {
+	return resultCacheKey{
+		Query:       strings.Join(strings.Fields(req.Query), " "),
+		Ranking:     req.Ranking,
+		Rerank:      req.Rerank && svc.Reranker != nil,
+		Suggest:     req.Suggest,
+		AutoCorrect: req.AutoCorrect,
+
+		Facets:       req.Facets,
+		FacetFilters: req.FacetFilters,
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/rewrite"
+)
+
+// How often admin rewrite rules are reloaded, bounds how long a rule change takes to apply
+const REWRITE_RULES_RELOAD_INTERVAL = time.Minute
+
+// Returns the current query rewriter, nil until rules are first loaded
+func (svc *SearchService) Rewriter() *rewrite.Rewriter {
+	svc.rewriterMu.RLock()
+	defer svc.rewriterMu.RUnlock()
+	return svc.rewriter
+}
+
+/*
+Returns the synonym and acronym dictionaries, read from the environment:
+
+	SYNONYMS_FILE  synonym dictionary replacing the built-in English one
+	ACRONYMS_FILE  acronym dictionary replacing the built-in one
+
+A dictionary that cannot be read keeps the built-in one
+*/
+func LoadDictionaryRules() []models.RewriteRule {
+	var rules []models.RewriteRule
+	rules = append(rules, loadDictionary("SYNONYMS_FILE", rewrite.SYNONYMS)...)
+	rules = append(rules, loadDictionary("ACRONYMS_FILE", rewrite.ACRONYMS)...)
+	return rules
+}
+
+// Returns the rules of the dictionary file named by an environment variable, builtin if unset or invalid
+func loadDictionary(env string, builtin []models.RewriteRule) []models.RewriteRule {
+	path := os.Getenv(env)
+	if path == "" {
+		return builtin
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open %s, using built-in rules: %v\n", env, err)
+		return builtin
+	}
+	defer file.Close()
+
+	rules, err := rewrite.ParseRules(file)
+	if err != nil {
+		log.Printf("Invalid %s %s, using built-in rules: %v\n", env, path, err)
+		return builtin
+	}
+	return rules
+}
+
+// Builds the rewriter from the dictionaries and admin rules now and then every interval in the background,
+// so rules added or disabled by admins apply without restarting the API
+func (svc *SearchService) WatchRewriteRules(dictionary []models.RewriteRule, interval time.Duration) {
+	svc.RefreshRewriteRules(dictionary)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			svc.RefreshRewriteRules(dictionary)
+		}
+	}()
+}
+
+// Rebuilds the rewriter from the dictionaries and the enabled admin rules
+// Keeps the current rewriter if the admin rules cannot be read, or uses the dictionaries alone if there is none yet
+func (svc *SearchService) RefreshRewriteRules(dictionary []models.RewriteRule) {
+	adminRules, err := svc.DB.FetchRewriteRules(true)
+	if err != nil {
+		log.Println("Failed to load rewrite rules: ", err)
+		if svc.Rewriter() != nil {
+			return
+		}
+	}
+
+	rewriter := rewrite.NewRewriter(append(append([]models.RewriteRule{}, dictionary...), adminRules...))
+
+	svc.rewriterMu.Lock()
+	defer svc.rewriterMu.Unlock()
+	if svc.rewriter == nil || svc.rewriter.Version() != rewriter.Version() {
+		log.Printf("Loaded %d query rewrite rules, %d managed by admins\n", rewriter.Rules(), len(adminRules))
+		svc.rewriter = rewriter
+	}
+}
@@ -5,6 +5,7 @@ import (
 )
 
 // Database name and collection name used by api
+// COLL_NAME is also the alias resolved to the live versioned index collection
 const DB_NAME = "opensearch"
 const COLL_NAME = "inverted_index"
 
@@ -12,9 +13,28 @@ const COLL_NAME = "inverted_index"
 const DEFAULT_PAGE_LIMIT = 10
 const DEFAULT_PAGE_OFFSET = 0
 
-// Sets up handlers for health, metrics and search
+// Sets up handlers for health, metrics, search, autocomplete, click tracking, documents, the link graph and search analytics
 func SetUpRouter(router *gin.Engine, svc *SearchService) {
 	router.GET("/health", HealthCheck)
 	router.GET("/metrics", svc.MetricsHandler)
+	router.GET("/metrics/cache", svc.CacheMetricsHandler)
 	router.GET("/search", svc.SearchHandler)
+	router.GET("/suggest", svc.SuggestHandler)
+	router.GET("/click", svc.ClickHandler)
+
+	documents := router.Group("/documents")
+	documents.GET("/:id", svc.DocumentHandler)
+	documents.GET("/:id/cache", svc.CachedPageHandler)
+	documents.GET("/:id/similar", svc.SimilarHandler)
+
+	links := router.Group("/links")
+	links.GET("", svc.LinksHandler)
+	links.GET("/inlinks", svc.InlinksHandler)
+	links.GET("/outlinks", svc.OutlinksHandler)
+	links.GET("/hosts/:host", svc.HostLinksHandler)
+
+	analytics := router.Group("/analytics", svc.RequireAdmin)
+	analytics.GET("/top-queries", svc.TopQueriesHandler)
+	analytics.GET("/zero-result-queries", svc.ZeroResultQueriesHandler)
+	analytics.GET("/latency", svc.LatencyHandler)
 }
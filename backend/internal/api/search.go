@@ -0,0 +1,533 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/clicks"
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/search"
+	"github.com/Jailior/open-search/backend/internal/suggest"
+)
+
+// A parsed search request
+type SearchRequest struct {
+	Query   string
+	Limit   int
+	Offset  int
+	Ranking search.RankingConfig
+
+	// score every matching document instead of pruning, the baseline top-k retrieval is checked against
+	Exhaustive bool
+
+	// suggest a spelling correction when few documents match
+	Suggest bool
+	// search the corrected query instead when nothing matches
+	AutoCorrect bool
+	// link results through the /click redirect
+	Track bool
+	// re-score the best documents with the learned ranking model, if one is loaded
+	Rerank bool
+	// explain how the score of each returned result was computed
+	Explain bool
+	// expand synonyms and acronyms and apply admin rewrite rules, if rules are loaded
+	Rewrite bool
+
+	// continue after a page returned earlier instead of at Offset
+	Cursor *Cursor
+
+	// facets counted over every matching document, none if nil
+	Facets *search.FacetRequest
+	// only documents passing these, e.g. a facet value picked from an earlier response
+	FacetFilters []search.FacetFilter
+
+	// most results per page from one host, uncapped if 0
+	HostLimit int
+	// trade-off of relevance for content diversity among the best documents from 0 to 1, diversification is off if 0
+	Diversity float64
+}
+
+// Ranked page of results for a search request
+type SearchResponse struct {
+	Query             string      `json:"query"`
+	TotalResults      int         `json:"totalResults"`
+	TotalResultsExact bool        `json:"totalResultsExact"` // false if TotalResults is a lower bound
+	Ranking           string      `json:"ranking"`
+	Offset            int         `json:"offset"` // results before this page
+	Results           []*DocScore `json:"results"`
+	NextCursor        string      `json:"next_cursor,omitempty"` // passed as cursor to fetch the next page, empty on the last page
+
+	Facets map[string][]search.FacetCount `json:"facets,omitempty"` // value counts of each requested facet
+
+	Suggestion    string `json:"suggestion,omitempty"`     // corrected query, "did you mean"
+	OriginalQuery string `json:"original_query,omitempty"` // query as typed, set if results are for the corrected query
+
+	Stats SearchStats `json:"-"`
+}
+
+// Timings and work done by a search, for benchmarking
+type SearchStats struct {
+	Retrieval time.Duration // time spent ranking documents, excluding fetches
+	Evaluated int           // documents fully scored
+	Cached    bool          // ranked documents came from the result cache
+}
+
+// Everything a request is ranked with, fetched once per search
+type searchContext struct {
+	req        *SearchRequest
+	node       parsing.Node
+	queryTerms []parsing.QueryTerm // terms that can make a document match, used for scoring
+	corpus     *models.CorpusStats
+	scorer     search.Scorer
+	entries    []models.TermEntry
+	facets     []models.TermEntry // entries before facet filters, facet values are counted from
+	termStats  map[string]search.TermStats
+	lists      map[string]*search.PostingList
+
+	pageRanks   map[string]float64 // PageRank of every candidate document by URL
+	maxRank     float64
+	clickScores map[string]float64 // click-through of documents previously shown for the query
+}
+
+// Parses a request and fetches the postings, statistics and signals its candidate documents are ranked with
+// Returns a *parsing.ParseError if the query is invalid
+func (svc *SearchService) prepareSearch(req *SearchRequest) (*searchContext, error) {
+
+	// Parse query into a tree of terms, phrases and boolean operators
+	node, err := parsing.Parse(req.Query, models.IndexedFields)
+	if err != nil {
+		return nil, err
+	}
+
+	// expand synonyms and acronyms and apply rewrite rules, so the added terms' postings are fetched too
+	if req.Rewrite {
+		node = svc.Rewriter().Rewrite(node)
+	}
+
+	sc := &searchContext{
+		req:        req,
+		node:       node,
+		queryTerms: parsing.QueryTerms(node),
+	}
+	// every term to fetch, including excluded ones
+	terms := parsing.AllTerms(node)
+
+	// index collection served for this request, stays fixed even if the alias swaps mid-request
+	index := svc.IndexCollection()
+
+	// fetch corpus stats, total doc count and average lengths
+	sc.corpus, _ = svc.DB.GetCorpusStats(index)
+
+	// scoring model for this request
+	sc.scorer, err = search.NewScorer(req.Ranking, sc.corpus)
+	if err != nil {
+		return nil, err
+	}
+
+	// batch fetch postings from inverted indexer collection, hot terms come from the postings cache
+	sc.entries, err = svc.fetchPostings(terms, index)
+	if err != nil {
+		return nil, fmt.Errorf("batch fetch postings: %w", err)
+	}
+
+	// corpus statistics of each term
+	sc.termStats = make(map[string]search.TermStats, len(sc.entries))
+	for _, entry := range sc.entries {
+		sc.termStats[entry.Term] = search.TermStats{DF: entry.DF, N: sc.corpus.TotalPages}
+	}
+
+	// pages linking to the URL of each link: filter
+	if err := svc.resolveLinkFilters(node); err != nil {
+		return nil, fmt.Errorf("resolve link filters: %w", err)
+	}
+
+	// drop postings of documents failing site:, filetype:, after:, before: or link: filters and facet filters before scoring
+	search.ApplyFilters(sc.entries, node)
+	sc.facets = sc.entries
+	if req.Facets != nil && len(req.FacetFilters) > 0 {
+		sc.facets = search.CloneEntries(sc.entries)
+	}
+	search.ApplyFacetFilters(sc.entries, req.FacetFilters)
+
+	// doc id ordered posting lists with the bounds used for pruning
+	sc.lists = search.NewPostingLists(sc.entries)
+
+	// batch fetch PageRank scores of every candidate document
+	sc.pageRanks, sc.maxRank = svc.fetchPageRanks(sc.lists, sc.queryTerms)
+
+	// click-through of documents previously shown for this query
+	if req.Ranking.Clicks > 0 {
+		sc.clickScores = svc.fetchClickScores(req.Query)
+	}
+	return sc, nil
+}
+
+// Scores a candidate document, false if it does not match the query tree
+func (sc *searchContext) evaluate(docID string, doc search.DocPostings) (float64, bool) {
+	return sc.score(docID, doc, nil)
+}
+
+// Scores a candidate document, recording how the score was computed into exp if explaining
+func (sc *searchContext) score(docID string, doc search.DocPostings, exp *search.Explanation) (float64, bool) {
+	if !search.Matches(sc.node, doc) {
+		return 0, false
+	}
+	text := sc.textScore(docID, doc, exp.Add(0, "text"))
+	rank := sc.pageRanks[docURL(doc)]
+	score := sc.req.Ranking.Blend(text, rank)
+
+	if exp.Enabled() {
+		exp.Set(score, "score, alpha * text + (1 - alpha) * pagerank")
+		exp.Add(sc.req.Ranking.Alpha, "alpha, weight of text relevance")
+		exp.Add(rank, "pagerank, normalized")
+		exp.Add((1-sc.req.Ranking.Alpha)*rank, "pagerank contribution, (1 - alpha) * pagerank")
+	}
+	return score, true
+}
+
+// Returns the text relevance of a document, boosted by proximity and click-through, recorded into exp if explaining
+func (sc *searchContext) textScore(docID string, doc search.DocPostings, exp *search.Explanation) float64 {
+	text := 0.0
+	for _, qt := range sc.queryTerms {
+		posting, ok := doc[qt.Term]
+		var termExp *search.Explanation
+		if exp.Enabled() {
+			termExp = exp.Add(0, termLabel(qt))
+			if !ok {
+				termExp.Description += ", not in the document"
+				continue
+			}
+		}
+		if !ok {
+			continue
+		}
+		// text relevance of the term, skipped if the term is not in the queried field
+		termScore, ok := sc.scorer.Explain(qt, posting, sc.termStats[qt.Term], termExp.Add(0, ""))
+		if !ok {
+			termExp.Set(0, termLabel(qt)+", not in the queried field")
+			continue
+		}
+		// terms added by synonyms and rewrite rules count less than the query as written
+		weight := sc.req.Ranking.TermWeight(qt)
+		if qt.Expansion != "" {
+			termExp.Add(weight, "expansion weight")
+		}
+		termExp.Set(weight*termScore, termLabel(qt))
+		text += weight * termScore
+	}
+	exp.Add(text, "sum of term weights")
+
+	// boost documents where query terms appear close together
+	proximity := proximityBoost(doc, sc.queryTerms)
+	text *= 1 + proximity
+	exp.Add(proximity, "proximity boost, query terms close together in the body")
+
+	// boost documents clicked more than expected at their positions, demote those clicked less
+	click := clicks.Boost(sc.clickScores[docID], sc.req.Ranking.Clicks)
+	text *= 1 + click
+	if exp.Enabled() {
+		clickExp := exp.Add(click, "click boost, weight * log2(click-through) / 2 capped at -weight and weight")
+		clickExp.Add(sc.clickScores[docID], "click-through, clicks over expected clicks, 0 without click data")
+		clickExp.Add(sc.req.Ranking.Clicks, "weight")
+	}
+
+	exp.Set(text, "text, sum of term weights * (1 + proximity boost) * (1 + click boost)")
+	return text
+}
+
+// Returns the k best documents, pruning those that cannot make it unless the request is exhaustive
+func (sc *searchContext) retrieve(k int) search.TopKResult {
+	if sc.req.Exhaustive {
+		return search.Exhaustive(sc.entries, k, sc.evaluate)
+	}
+
+	// largest factor text relevance is multiplied by
+	alpha := sc.req.Ranking.Alpha
+	textWeight := alpha * (1 + PROXIMITY_WEIGHT)
+	if len(sc.clickScores) > 0 {
+		textWeight *= 1 + sc.req.Ranking.Clicks
+	}
+
+	return search.TopK(&search.TopKQuery{
+		K:          k,
+		Scoring:    scoringLists(sc.scorer, sc.lists, sc.queryTerms, sc.termStats, &sc.req.Ranking),
+		Lists:      sc.lists,
+		TextWeight: textWeight,
+		Prior: func(posting *models.IndexerPosting) float64 {
+			return (1 - alpha) * sc.pageRanks[posting.URL]
+		},
+		MaxPrior: (1 - alpha) * sc.maxRank,
+		Evaluate: sc.evaluate,
+	})
+}
+
+// Runs a search request against the live index and returns the requested page of ranked results
+// Returns a *parsing.ParseError if the query is invalid and ErrInvalidCursor if the cursor continues another search
+func (svc *SearchService) Search(req *SearchRequest) (*SearchResponse, error) {
+	if req.Cursor != nil {
+		if hash, err := svc.requestHash(req); err != nil || req.Cursor.Request != hash {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	ranked, err := svc.cachedRank(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// diversified and capped per host, in the order pages are taken from
+	docs := arrange(ranked.Docs, req)
+
+	// pagination slicing, after the cursor's document if continuing a page
+	start := req.Offset
+	if req.Cursor != nil {
+		start = cursorStart(docs, req.Cursor)
+	}
+	end := min(start+req.Limit, len(docs))
+	start = min(start, end)
+	paged := make([]*DocScore, 0, end-start)
+	for i, scored := range docs[start:end] {
+		page := &DocScore{
+			DocID:     scored.DocID,
+			Score:     scored.Score,
+			positions: scored.Positions,
+		}
+		if req.Track && svc.Clicks != nil {
+			page.ClickURL = svc.Clicks.ClickURL(suggest.NormalizeKey(ranked.Query), scored.DocID, start+i+1)
+		}
+		paged = append(paged, page)
+	}
+	groupHosts(paged, docs[start:end], docs[end:], req.HostLimit, ranked.Query)
+
+	// score breakdown of each result, from scoring the page's documents again
+	if req.Explain {
+		if err := svc.explainResults(req, ranked, paged, start); err != nil {
+			return nil, err
+		}
+	}
+
+	// create docIDs list from pages to use in batch fetching all raw pages
+	var docIDs []string = make([]string, 0)
+	for _, page := range paged {
+		docIDs = append(docIDs, page.DocID)
+	}
+
+	// batch fetch raw pages needed
+	rawPages, _ := svc.DB.FetchRawPageBatch(docIDs, "pages")
+
+	// map raw page by id
+	rawPageMap := make(map[string]models.PageData)
+	for _, page := range rawPages {
+		idHex := page.ID.Hex()
+		rawPageMap[idHex] = page
+	}
+
+	// get snippets from pages
+	for _, page := range paged {
+		rawPage, ok := rawPageMap[page.DocID]
+		if !ok {
+			continue
+		}
+
+		snippet := search.MakeSnippet(rawPage.Content, page.positions, ranked.Terms)
+		page.Snippet = snippet.HTML()
+		page.SnippetText = snippet.Text
+		page.Highlights = snippet.Highlights
+		page.Title = rawPage.Title
+		page.URL = rawPage.URL
+	}
+
+	resp := &SearchResponse{
+		Query:             ranked.Query,
+		TotalResults:      ranked.Total,
+		TotalResultsExact: ranked.Exact,
+		Ranking:           ranked.Ranking,
+		Offset:            start,
+		Results:           paged,
+		Suggestion:        ranked.Suggestion,
+		OriginalQuery:     ranked.OriginalQuery,
+		Facets:            ranked.Facets,
+		Stats:             ranked.stats,
+	}
+	if cursor := svc.nextCursor(req, ranked, docs, end); cursor != nil {
+		resp.NextCursor = cursor.Encode()
+	}
+	return resp, nil
+}
+
+// Ranks the best depth documents of a request, searching the corrected query instead if nothing matches
+// Returns a *parsing.ParseError if the query is invalid
+func (svc *SearchService) rank(req *SearchRequest, depth int) (*rankedResults, error) {
+	sc, err := svc.prepareSearch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// a link: query without search terms lists the pages linking to its URL
+	if parsing.IsLinkQuery(sc.node) {
+		return svc.rankLinking(req, sc, depth)
+	}
+
+	// only the documents up to depth are ranked, or every reranked document
+	k := depth
+	rerank := req.Rerank && svc.Reranker != nil
+	if rerank {
+		k = max(k, RERANK_DEPTH)
+	}
+
+	retrievalStart := time.Now()
+	result := sc.retrieve(k)
+	if rerank {
+		sc.rerank(result.Docs, svc.Reranker)
+	}
+	stats := SearchStats{Retrieval: time.Since(retrievalStart), Evaluated: result.Evaluated}
+
+	// suggest a correction when few documents match, searching it instead if nothing does
+	suggestion := ""
+	if req.Suggest && result.Total < POOR_RESULTS_THRESHOLD {
+		if corrected, ok := svc.correctQuery(req.Query); ok {
+			suggestion = corrected
+		}
+	}
+	if suggestion != "" && result.Total == 0 && req.AutoCorrect {
+		correctedReq := *req
+		correctedReq.Query = suggestion
+		correctedReq.Suggest = false
+		correctedReq.AutoCorrect = false
+
+		ranked, err := svc.rank(&correctedReq, depth)
+		if err == nil && ranked.Total > 0 {
+			ranked.OriginalQuery = req.Query
+			return ranked, nil
+		}
+	}
+
+	// keep the snippet positions of each ranked document, the postings are not needed past ranking
+	docs := result.Docs[:min(depth, len(result.Docs))]
+	ranked := &rankedResults{
+		Query:      req.Query,
+		Suggestion: suggestion,
+		Ranking:    sc.scorer.Name(),
+		Total:      result.Total,
+		Exact:      result.Exact,
+		Docs:       make([]rankedDoc, 0, len(docs)),
+		Terms:      snippetTerms(sc.queryTerms),
+		stats:      stats,
+	}
+	if rerank {
+		ranked.Ranking += "+ltr"
+	}
+	if req.Facets != nil {
+		ranked.Facets = search.CountFacets(sc.facets, sc.node, req.Facets, req.FacetFilters)
+	}
+	for _, scored := range docs {
+		posting := anyPosting(scored.Doc)
+		ranked.Docs = append(ranked.Docs, rankedDoc{
+			DocID:     scored.DocID,
+			Score:     scored.Score,
+			Host:      search.FacetValue(search.FACET_HOST, posting, ""),
+			Simhash:   uint64(posting.Simhash),
+			Positions: snippetPositions(scored.Doc, sc.queryTerms),
+		})
+	}
+	return ranked, nil
+}
+
+// Returns the upper bound of each query term's text score with the posting list it is traversed with
+// A term queried more than once, e.g. in different fields, is bounded by the sum of its weighted occurrences
+func scoringLists(scorer search.Scorer, lists map[string]*search.PostingList, queryTerms []parsing.QueryTerm, termStats map[string]search.TermStats, ranking *search.RankingConfig) []search.ScoringList {
+	bounds := make(map[string]float64)
+	order := make([]string, 0, len(queryTerms))
+	for _, qt := range queryTerms {
+		list, ok := lists[qt.Term]
+		if !ok {
+			continue
+		}
+		if _, seen := bounds[qt.Term]; !seen {
+			order = append(order, qt.Term)
+		}
+		bounds[qt.Term] += ranking.TermWeight(qt) * scorer.MaxScore(qt, &list.Bounds, termStats[qt.Term])
+	}
+
+	scoring := make([]search.ScoringList, 0, len(order))
+	for _, term := range order {
+		scoring = append(scoring, search.ScoringList{List: lists[term], MaxScore: bounds[term]})
+	}
+	return scoring
+}
+
+// Batch fetches the PageRank of every document containing a query term, and the largest of them
+func (svc *SearchService) fetchPageRanks(lists map[string]*search.PostingList, queryTerms []parsing.QueryTerm) (map[string]float64, float64) {
+	allURLs := make(map[string]struct{})
+	for _, qt := range queryTerms {
+		list, ok := lists[qt.Term]
+		if !ok {
+			continue
+		}
+		for i := range list.Postings {
+			allURLs[list.Postings[i].URL] = struct{}{}
+		}
+	}
+	// get all unique urls in postings
+	uniqueURLs := make([]string, 0, len(allURLs))
+	for url := range allURLs {
+		uniqueURLs = append(uniqueURLs, url)
+	}
+
+	pageRanks, _ := svc.DB.FetchPageRankBatch(uniqueURLs)
+
+	maxRank := 0.0
+	for _, rank := range pageRanks {
+		maxRank = math.Max(maxRank, rank)
+	}
+	return pageRanks, maxRank
+}
+
+// Returns the URL of a document, the same on each of its postings
+func docURL(doc search.DocPostings) string {
+	for _, posting := range doc {
+		return posting.URL
+	}
+	return ""
+}
+
+// Returns one of a document's postings, the document attributes are the same on each
+func anyPosting(doc search.DocPostings) *models.IndexerPosting {
+	for _, posting := range doc {
+		return posting
+	}
+	return &models.IndexerPosting{}
+}
+
+// Returns the body positions of each query term in the document, used to pick snippet windows
+// Only the first MAX_SNIPPET_POSITIONS of each term are kept, bounding the size of cached results
+// Terms scoped to another field are left out since their positions are not in the body
+func snippetPositions(doc search.DocPostings, queryTerms []parsing.QueryTerm) map[string][]int {
+	positions := make(map[string][]int)
+	for _, qt := range queryTerms {
+		if qt.Field != "" && qt.Field != models.FIELD_BODY {
+			continue
+		}
+		if posting, ok := doc[qt.Term]; ok {
+			if body := posting.BodyPositions(); len(body) > 0 {
+				positions[qt.Term] = body[:min(len(body), MAX_SNIPPET_POSITIONS)]
+			}
+		}
+	}
+	return positions
+}
+
+// Returns the distinct query terms highlighted in snippets
+func snippetTerms(queryTerms []parsing.QueryTerm) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, qt := range queryTerms {
+		if !seen[qt.Term] {
+			seen[qt.Term] = true
+			terms = append(terms, qt.Term)
+		}
+	}
+	return terms
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"log"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/suggest"
+)
+
+// How often the spelling dictionary is rebuilt from the live index
+const SPELLER_REBUILD_INTERVAL = 15 * time.Minute
+
+// Searches matching fewer documents than this get a "did you mean" suggestion
+const POOR_RESULTS_THRESHOLD = 5
+
+// Returns the current spelling corrector, nil until the first build finishes
+func (svc *SearchService) Speller() *suggest.Speller {
+	svc.spellerMu.RLock()
+	defer svc.spellerMu.RUnlock()
+	return svc.speller
+}
+
+// Builds the spelling dictionary in the background and rebuilds it every interval,
+// so terms from newly indexed pages and reindexed versions become suggestions
+func (svc *SearchService) WatchSpeller(interval time.Duration) {
+	go func() {
+		svc.RebuildSpeller()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			svc.RebuildSpeller()
+		}
+	}()
+}
+
+// Rebuilds the spelling dictionary from the terms of the live index, keeping the current one on error
+func (svc *SearchService) RebuildSpeller() {
+	start := time.Now()
+	dfs, err := svc.DB.FetchTermDFs(svc.IndexCollection(), 1)
+	if err != nil {
+		log.Println("Failed to fetch terms for spelling dictionary: ", err)
+		return
+	}
+
+	speller := suggest.NewSpeller(dfs)
+
+	svc.spellerMu.Lock()
+	svc.speller = speller
+	svc.spellerMu.Unlock()
+
+	log.Printf("Built spelling dictionary of %d words in %s\n", speller.Size(), time.Since(start))
+}
+
+// Returns the query with misspelled words corrected and true, or false if no word was corrected
+// Operators, fields and filters are kept as written
+func (svc *SearchService) correctQuery(query string) (string, bool) {
+	speller := svc.Speller()
+	if speller == nil {
+		return query, false
+	}
+	return parsing.RewriteWords(query, models.IndexedFields, speller.Correct)
+}
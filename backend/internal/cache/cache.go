@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Longest a Redis lookup may take before it counts as a miss, a slow cache must not slow down searches
+const REDIS_TIMEOUT = 50 * time.Millisecond
+
+// Stores encoded values by key, values may be evicted at any time
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+}
+
+// Lookups and contents of a cache
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"` // values dropped to stay within the size bound
+	Entries   int   `json:"entries"`
+	Bytes     int   `json:"bytes"`
+}
+
+/* LRU */
+
+// In-process cache evicting the least recently used values once their total size exceeds a bound, safe for concurrent use
+type LRU[V any] struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	size     func(V) int // estimated size of a value in bytes
+	order    *list.List  // most recently used first
+	items    map[string]*list.Element
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// An entry of the LRU order
+type lruEntry[V any] struct {
+	key   string
+	value V
+	size  int // size of key and value
+}
+
+// Returns an empty LRU of encoded values holding at most maxBytes of keys and values
+func NewLRU(maxBytes int) *LRU[[]byte] {
+	return NewSizedLRU(maxBytes, func(value []byte) int { return len(value) })
+}
+
+// Returns an empty LRU holding at most maxBytes of keys and values, values are measured with size
+func NewSizedLRU[V any](maxBytes int, size func(V) int) *LRU[V] {
+	return &LRU[V]{
+		maxBytes: maxBytes,
+		size:     size,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *LRU[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.misses.Add(1)
+		var zero V
+		return zero, false
+	}
+	c.hits.Add(1)
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruEntry[V]).value, true
+}
+
+// Stores a value, values larger than the whole cache are not stored
+func (c *LRU[V]) Set(key string, value V) {
+	size := len(key) + c.size(value)
+	if size > c.maxBytes {
+		c.Remove(key)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*lruEntry[V])
+		c.bytes += size - entry.size
+		entry.value = value
+		entry.size = size
+		c.order.MoveToFront(elem)
+	} else {
+		c.items[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value, size: size})
+		c.bytes += size
+	}
+
+	for c.bytes > c.maxBytes {
+		c.removeElement(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// Drops a value, false if it was not cached
+func (c *LRU[V]) Remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if ok {
+		c.removeElement(elem)
+	}
+	return ok
+}
+
+// Drops every value
+func (c *LRU[V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// Unlinks an entry, the caller holds the lock
+func (c *LRU[V]) removeElement(elem *list.Element) {
+	entry := elem.Value.(*lruEntry[V])
+	c.order.Remove(elem)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+// Returns the lookups so far and the current contents
+func (c *LRU[V]) Stats() Stats {
+	c.mu.Lock()
+	entries, bytes := len(c.items), c.bytes
+	c.mu.Unlock()
+
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   entries,
+		Bytes:     bytes,
+	}
+}
+
+/* Redis */
+
+// Cache shared by processes through Redis, values expire after a TTL
+// Redis errors and timeouts are treated as misses
+type Redis struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+}
+
+// Returns a cache storing values under prefix for ttl
+func NewRedis(client *redis.Client, prefix string, ttl time.Duration) *Redis {
+	return &Redis{client: client, prefix: prefix, ttl: ttl}
+}
+
+func (c *Redis) Get(key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), REDIS_TIMEOUT)
+	defer cancel()
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (c *Redis) Set(key string, value []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), REDIS_TIMEOUT)
+	defer cancel()
+	c.client.Set(ctx, c.prefix+key, value, c.ttl)
+}
+
+/* Tiered */
+
+// Cache looking values up in a local cache before a shared one, shared hits are copied to the local cache
+type Tiered struct {
+	Local  Cache
+	Shared Cache
+}
+
+func (c *Tiered) Get(key string) ([]byte, bool) {
+	if value, ok := c.Local.Get(key); ok {
+		return value, true
+	}
+	value, ok := c.Shared.Get(key)
+	if ok {
+		c.Local.Set(key, value)
+	}
+	return value, ok
+}
+
+func (c *Tiered) Set(key string, value []byte) {
+	c.Local.Set(key, value)
+	c.Shared.Set(key, value)
+}
@@ -0,0 +1,115 @@
+package clicks
+
+import (
+	"math"
+	"sort"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+)
+
+// Positions past this share its click rate, few results are ever seen that deep
+const MAX_POSITION = 50
+
+// Fewest impressions a (query, document) needs for its click-through to be stored
+const MIN_IMPRESSIONS = 5
+
+// Expected clicks added to both sides of a score, pulling rarely shown results towards the neutral 1
+const CLICK_PRIOR = 2.0
+
+// A query and a document shown for it
+type pair struct {
+	query string
+	docID string
+}
+
+/*
+Returns the click rate of each position from 1 to MAX_POSITION, at index position-1
+
+Users click higher results more no matter how relevant they are, the rate of a position over every query
+is the click-through an average result gets there. Positions never shown take the rate of the one above.
+*/
+func PositionCTR(impressions, clicks []models.PositionCount) []float64 {
+	shown := make([]float64, MAX_POSITION)
+	clicked := make([]float64, MAX_POSITION)
+	for _, c := range impressions {
+		shown[positionIndex(c.Position)] += float64(c.Count)
+	}
+	for _, c := range clicks {
+		clicked[positionIndex(c.Position)] += float64(c.Count)
+	}
+
+	ctr := make([]float64, MAX_POSITION)
+	for i := range ctr {
+		switch {
+		case shown[i] > 0:
+			ctr[i] = min(clicked[i]/shown[i], 1)
+		case i > 0:
+			ctr[i] = ctr[i-1]
+		}
+	}
+	return ctr
+}
+
+/*
+Aggregates impressions and clicks into the click-through of each (query, document) shown at least MIN_IMPRESSIONS times
+
+Position bias is corrected with clicks over expected clicks: each impression expects the click rate of its position,
+so a result clicked often at position 8 scores higher than one clicked as often at position 1.
+The score is (clicks + CLICK_PRIOR) / (expected + CLICK_PRIOR), 1 for an average result or one without clicks data.
+*/
+func Aggregate(impressions, clicks []models.PositionCount) []models.ClickStats {
+	ctr := PositionCTR(impressions, clicks)
+
+	stats := make(map[pair]*models.ClickStats)
+	for _, c := range impressions {
+		key := pair{c.Query, c.DocID}
+		s, ok := stats[key]
+		if !ok {
+			s = &models.ClickStats{Query: c.Query, DocID: c.DocID}
+			stats[key] = s
+		}
+		s.Impressions += c.Count
+		s.ExpectedClicks += float64(c.Count) * ctr[positionIndex(c.Position)]
+	}
+	for _, c := range clicks {
+		// clicks on results whose impressions were dropped from the capped log are ignored
+		if s, ok := stats[pair{c.Query, c.DocID}]; ok {
+			s.Clicks += c.Count
+		}
+	}
+
+	result := make([]models.ClickStats, 0, len(stats))
+	for _, s := range stats {
+		if s.Impressions < MIN_IMPRESSIONS {
+			continue
+		}
+		s.Score = (float64(s.Clicks) + CLICK_PRIOR) / (s.ExpectedClicks + CLICK_PRIOR)
+		result = append(result, *s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Query != result[j].Query {
+			return result[i].Query < result[j].Query
+		}
+		return result[i].DocID < result[j].DocID
+	})
+	return result
+}
+
+/*
+Returns the boost of a click-through score in [-weight, weight], a document's text relevance is multiplied by 1 + boost
+
+Scores are compared on a log scale, so twice the expected clicks and half of them move a document by the same amount,
+capped at weight from four times or a quarter of the expected clicks.
+*/
+func Boost(score, weight float64) float64 {
+	if score <= 0 || weight == 0 {
+		return 0
+	}
+	return weight * max(-1, min(1, math.Log2(score)/2))
+}
+
+// Returns the index of a position in the click rates, clamped to MAX_POSITION
+func positionIndex(position int) int {
+	return max(1, min(position, MAX_POSITION)) - 1
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"mime"
 	"strings"
 	"sync"
 	"time"
@@ -173,6 +174,10 @@ func MakeHTMLHandler(ctx *CrawlContext) func(e *colly.HTMLElement) {
 		// find the page title
 		title := e.DOM.Find("title").Text()
 
+		// collect headings and publication date before cleaning removes page structure
+		headings := parsing.ExtractHeadings(e.DOM)
+		published := parsing.ExtractPublished(e.DOM)
+
 		// extract and clean
 		doc := e.DOM
 		content := parsing.CleanText(doc)
@@ -246,9 +251,13 @@ func MakeHTMLHandler(ctx *CrawlContext) func(e *colly.HTMLElement) {
 		page := models.PageData{
 			Title:       title,
 			URL:         url,
+			Headings:    headings,
 			Content:     content,
 			Outlinks:    outlinks,
 			TimeCrawled: time.Now(),
+			Published:   published,
+			Language:    info.Lang.Iso6391(),
+			ContentType: responseContentType(e.Response),
 		}
 
 		// insert raw page into database
@@ -284,3 +293,15 @@ func MakeHTMLHandler(ctx *CrawlContext) func(e *colly.HTMLElement) {
 		models.PrintPage(page)
 	}
 }
+
+// Returns the media type of a response without parameters such as charset, empty if missing or malformed
+func responseContentType(resp *colly.Response) string {
+	if resp == nil || resp.Headers == nil {
+		return ""
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Headers.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
@@ -20,7 +20,7 @@ func main() {
 		DB:       0,
 	})
 
-	keys := []string{"url_queue", "visited_set", "pages_to_index"}
+	keys := []string{"url_queue", "visited_set", "pages_to_index", "pages_to_index_dlq"}
 	for _, key := range keys {
 		err := rdb.Del(ctx, key).Err()
 		if err != nil {
@@ -2,7 +2,9 @@ package indexer
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,15 +21,38 @@ const DB_NAME = "opensearch"
 // Raw page collection
 const PAGE_INSERT_COLLECTION = "pages"
 
-// Inverted index collection
+// Inverted index collection, also the alias name resolving to the live versioned index
 const PAGE_INDEX_COLLECTION = "inverted_index"
 
+// Name of the tokenization used to build postings, recorded with each index version
+// Bump when tokenization or posting fields change so a full reindex can be tracked
+const ANALYZER_VERSION = "fields-v3"
+
+// Default number of deliveries before a message is moved to the dead-letter stream
+const DEFAULT_MAX_DELIVERIES = 5
+
+// Default time a message must sit unacknowledged before it is claimed from its consumer
+const DEFAULT_CLAIM_IDLE = 5 * time.Minute
+
+// How often a worker checks for messages owned by dead consumers
+const CLAIM_INTERVAL = 1 * time.Minute
+
+// Least time between bumps of the search generation by a worker, each bump invalidates every cached search result
+const GENERATION_BUMP_INTERVAL = 1 * time.Minute
+
 // Indexer context
 type Indexer struct {
-	GroupName   string
-	StreamName  string
-	Database    *storage.Database
-	RedisClient *storage.RedisClient
+	GroupName        string
+	StreamName       string
+	DeadLetterStream string        // stream failed messages are moved to, dead-lettering disabled if empty
+	MaxDeliveries    int64         // deliveries before a failing message is dead-lettered
+	ClaimIdle        time.Duration // idle time before a pending message is claimed from another consumer
+	IndexCollection  string        // collection postings are written to, resolved from the index alias if empty
+	Database         *storage.Database
+	RedisClient      *storage.RedisClient
+
+	lastBump    time.Time // last time this worker bumped the search generation
+	pendingBump bool      // pages were indexed since the last bump, bumped once GENERATION_BUMP_INTERVAL passes
 }
 
 // Initializes Indexer worker, runs until shutdown received on cancel context
@@ -35,16 +60,24 @@ func (idx *Indexer) RunWorker(cancelContext context.Context, consumerName string
 
 	log.Printf("[%s] started\n", consumerName)
 
+	// last time messages from dead consumers were claimed
+	lastClaim := time.Now()
+
 	for {
 		select {
 		// selects between shutdown and default behaviour
 		// shutting down
 		case <-cancelContext.Done():
 			log.Printf("[%s] Shutdown signal received", consumerName)
+			// pages indexed since the last bump show up in search
+			idx.flushGeneration(true)
 			return
 		default:
 			// default behaviour
 
+			// trailing bump for pages indexed since the last bump, so they show up once indexing goes quiet
+			idx.flushGeneration(false)
+
 			// reads set of messages from Redis stream
 			messages, err := idx.RedisClient.ReadStream(idx.StreamName, idx.GroupName, consumerName)
 
@@ -54,35 +87,140 @@ func (idx *Indexer) RunWorker(cancelContext context.Context, consumerName string
 				continue
 			}
 
+			// claim messages left pending by dead consumers
+			if time.Since(lastClaim) > CLAIM_INTERVAL {
+				lastClaim = time.Now()
+				claimed, err := idx.RedisClient.AutoClaim(idx.StreamName, idx.GroupName, consumerName, idx.claimIdle(), 10)
+				if err != nil {
+					log.Printf("[%s] Failed to claim idle messages: %v\n", consumerName, err)
+				} else if len(claimed) > 0 {
+					log.Printf("[%s] Claimed %d idle messages\n", consumerName, len(claimed))
+					messages = append(claimed, messages...)
+				}
+			}
+
 			// process messages if valid
-			if messages != nil && len(messages) > 0 {
-				idx.ProcessMessages(messages)
+			if len(messages) > 0 {
+				retrying := idx.ProcessMessages(messages, consumerName)
+
+				// back off before failed messages are redelivered
+				if retrying > 0 {
+					time.Sleep(2 * time.Second)
+				}
 			}
 		}
 	}
 }
 
+// Returns the delivery budget, or the default if not set
+func (idx *Indexer) maxDeliveries() int64 {
+	if idx.MaxDeliveries > 0 {
+		return idx.MaxDeliveries
+	}
+	return DEFAULT_MAX_DELIVERIES
+}
+
+// Returns the claim idle time, or the default if not set
+func (idx *Indexer) claimIdle() time.Duration {
+	if idx.ClaimIdle > 0 {
+		return idx.ClaimIdle
+	}
+	return DEFAULT_CLAIM_IDLE
+}
+
+// Returns the collection to write postings to
+// Uses IndexCollection if set, otherwise follows the index alias so writes land in the live version
+func (idx *Indexer) indexCollection() string {
+	if idx.IndexCollection != "" {
+		return idx.IndexCollection
+	}
+	target, err := idx.Database.ResolveAlias(PAGE_INDEX_COLLECTION)
+	if err != nil {
+		log.Println("Failed to resolve index alias, using default collection: ", err)
+		return PAGE_INDEX_COLLECTION
+	}
+	idx.Database.EnsureCollection(DB_NAME, target)
+	return target
+}
+
 // Constructs an inverted index based on a page
 func (idx *Indexer) IndexPage(docId string, page *models.PageData) error {
-	// get terms in page and their positions in the text
-	terms := parsing.TokenizeText(page.Title + " " + page.Content)
-	termsLength := float64(len(terms))
+	return idx.indexPageInto(idx.indexCollection(), docId, page)
+}
+
+// Constructs an inverted index based on a page in the given index collection
+func (idx *Indexer) indexPageInto(collectionname string, docId string, page *models.PageData) error {
+	// get terms in each field of the page and their positions in the field
+	fields := tokenizeFields(page)
+
+	// merge field postings per term
+	termFields := make(map[string]models.FieldPostings)
+	for field, tokens := range fields {
+		for term, positions := range tokens.terms {
+			if termFields[term] == nil {
+				termFields[term] = make(models.FieldPostings)
+			}
+			termFields[term][field] = models.FieldPosting{
+				Freq:      len(positions),
+				Length:    tokens.length,
+				Positions: positions,
+			}
+		}
+	}
+
+	// per-field and total token lengths of the page
+	doc := &models.DocStats{
+		DocID:        docId,
+		URL:          page.URL,
+		Host:         parsing.URLHost(page.URL),
+		Date:         page.Date(),
+		FileType:     parsing.URLFileType(page.URL),
+		Language:     page.Language,
+		ContentType:  page.ContentType,
+		Crawled:      page.TimeCrawled,
+		Simhash:      int64(parsing.Simhash(page.Content)),
+		FieldLengths: make(map[string]int, len(fields)),
+		IndexedAt:    time.Now(),
+	}
+	for field, tokens := range fields {
+		doc.FieldLengths[field] = tokens.length
+		doc.Length += tokens.length
+	}
+
+	// number of terms that failed to update
+	failed := 0
+	// terms whose postings changed, cached copies of them are stale
+	var changed []string
 
 	// for each term get TF and add page as a posting
-	for term, positions := range terms {
-		// get term frequency
-		termFreq := float64(len(positions)) / termsLength
+	for term, termField := range termFields {
+		// get raw count and term frequency across all fields
+		count := 0
+		for _, fp := range termField {
+			count += fp.Freq
+		}
+		termFreq := float64(count) / float64(max(doc.Length, 1))
 
 		// filter based on term
 		filter := bson.M{"term": term}
 
 		// posting stored in database
 		posting := models.IndexerPosting{
-			DocID:     docId,
-			Title:     page.Title,
-			URL:       page.URL,
-			TF:        termFreq,
-			Positions: positions,
+			DocID:    docId,
+			Title:    page.Title,
+			URL:      page.URL,
+			TF:       termFreq,
+			Count:    count,
+			Length:   doc.Length,
+			Fields:   termField,
+			Host:     doc.Host,
+			Date:     doc.Date,
+			FileType: doc.FileType,
+
+			Language:    doc.Language,
+			ContentType: doc.ContentType,
+			Crawled:     doc.Crawled,
+			Simhash:     doc.Simhash,
 		}
 
 		// Update Option: update term document with posting or add it if it doesn't exit
@@ -96,40 +234,101 @@ func (idx *Indexer) IndexPage(docId string, page *models.PageData) error {
 		}
 
 		// update term in database
-		result, err := idx.Database.UpdateTerm(PAGE_INDEX_COLLECTION, filter, update)
+		result, err := idx.Database.UpdateTerm(collectionname, filter, update)
 		if err != nil {
 			log.Println("Failed to update index for term", term, ": ", err)
+			failed++
 			continue
 		}
 
 		// Increment document frequency if posting sucessfully added
 		if result.ModifiedCount > 0 || result.UpsertedCount > 0 {
-			idx.Database.IncrementDF(PAGE_INDEX_COLLECTION, filter)
+			idx.Database.IncrementDF(collectionname, filter)
+			changed = append(changed, term)
+		}
+	}
+	if len(changed) > 0 {
+		if err := idx.Database.RecordIndexChange(collectionname, changed); err != nil {
+			log.Println("Failed to record index change: ", err)
 		}
 	}
-	// Increment the number of total pages referred to by the inverted index
-	idx.Database.IncrementDocCount(PAGE_INDEX_COLLECTION)
+	// page is retried as a whole, postings already added are deduplicated by $addToSet
+	if failed > 0 {
+		return fmt.Errorf("Failed to update %d of %d terms", failed, len(termFields))
+	}
+
+	// store document lengths, skipped when re-indexing an already indexed page
+	docsCollection := storage.DocStatsCollection(collectionname)
+	idx.Database.EnsureCollection(DB_NAME, docsCollection)
+	inserted, err := idx.Database.InsertDocStats(docsCollection, doc)
+	if err != nil {
+		return fmt.Errorf("Failed to store document stats: %w", err)
+	}
+
+	// Increment the number of total pages referred to by the inverted index and corpus lengths
+	if inserted {
+		return idx.Database.AddDocToCorpus(collectionname, doc)
+	}
 	return nil
 }
 
+// Tokens of a single field of a page
+type fieldTokens struct {
+	terms  map[string][]int // term positions within the field
+	length int              // number of tokens in the field
+}
+
+// Tokenizes each indexed field of a page separately
+func tokenizeFields(page *models.PageData) map[string]fieldTokens {
+	fields := make(map[string]fieldTokens)
+
+	terms, length := parsing.TokenizeTextWithLength(page.Title)
+	fields[models.FIELD_TITLE] = fieldTokens{terms, length}
+
+	terms, length = parsing.TokenizeURL(page.URL)
+	fields[models.FIELD_URL] = fieldTokens{terms, length}
+
+	terms, length = parsing.TokenizeTextWithLength(strings.Join(page.Headings, " "))
+	fields[models.FIELD_HEADINGS] = fieldTokens{terms, length}
+
+	terms, length = parsing.TokenizeTextWithLength(page.Content)
+	fields[models.FIELD_BODY] = fieldTokens{terms, length}
+
+	return fields
+}
+
 // Processes the entries from a Redis stream for indexing
-func (idx *Indexer) ProcessMessages(messages []redis.XMessage) {
-	rd := idx.RedisClient
+// Successfully indexed messages are acknowledged, failed messages are left pending for redelivery
+// until they exceed the delivery budget and are moved to the dead-letter stream
+// Returns the number of failed messages left pending for retry
+func (idx *Indexer) ProcessMessages(messages []redis.XMessage, consumerName string) int {
 	db := idx.Database
 
-	// id list
-	var ids []string
+	// failure reasons by message id
+	failures := make(map[string]string)
+	// doc _id of each valid message
+	docIDs := make(map[string]string)
+	var valid []redis.XMessage
 
 	// for each message get its id
+	var ids []string
 	for _, message := range messages {
 		// get doc _id
 		idVal := message.Values["id"]
 		idStr, ok := idVal.(string)
 		if !ok {
 			log.Println("Invalid id value in stream message")
+			// malformed messages will never succeed, dead-letter straight away
+			// or drop them if that fails, a retry would fail the same way
+			if !idx.deadLetter(message, "invalid id value in stream message", 0) {
+				log.Printf("Message %s dropped, malformed and not dead-lettered\n", message.ID)
+				idx.ack(message.ID)
+			}
 			continue
 		}
 		ids = append(ids, idStr)
+		docIDs[message.ID] = idStr
+		valid = append(valid, message)
 	}
 
 	// batch fetch raw pages by id
@@ -137,31 +336,157 @@ func (idx *Indexer) ProcessMessages(messages []redis.XMessage) {
 	if err != nil {
 		// retry once
 		pages, err = db.FetchRawPageBatch(ids, PAGE_INSERT_COLLECTION)
-		// if still error
+		// if still error, every message in the batch failed
 		if err != nil {
 			log.Println("ERROR: error batch reading raw pages.")
-			return
+			for _, message := range valid {
+				failures[message.ID] = fmt.Sprintf("batch fetch failed: %v", err)
+			}
+			return idx.settle(valid, failures, consumerName)
 		}
 	}
 
-	if len(pages) == 0 {
-		log.Println("ERROR: no pages retrieved from batch read.")
-		return
-	}
+	// resolve the live index once per batch
+	collectionname := idx.indexCollection()
 
-	// index each page
+	// index each page, recording the error if any
+	indexErrs := make(map[string]error)
 	for _, page := range pages {
 		// Index Page
 		log.Println("Title: ", strings.TrimSpace(page.Title))
 		log.Println("URL: ", page.URL)
-		err = idx.IndexPage(page.ID.String(), &page)
+		docID := page.ID.Hex()
+		indexErrs[docID] = idx.indexPageInto(collectionname, docID, &page)
 	}
 
-	for _, message := range messages {
-		// Acknowledge reading page on shared Redis stream
-		_, err := rd.Client.XAck(rd.Ctx, idx.StreamName, idx.GroupName, message.ID).Result()
+	// map failures back to their messages
+	for _, message := range valid {
+		err, found := indexErrs[docIDs[message.ID]]
+		if !found {
+			// pages that could not be found will never be indexed
+			failures[message.ID] = "page not found"
+		} else if err != nil {
+			failures[message.ID] = fmt.Sprintf("indexing failed: %v", err)
+		}
+	}
+
+	// cached search results no longer reflect the live index
+	if len(failures) < len(valid) && idx.IndexCollection == "" {
+		idx.bumpGeneration()
+	}
+
+	return idx.settle(valid, failures, consumerName)
+}
+
+// Acknowledges successful messages and dead-letters failures that have used up their delivery budget
+// Returns the number of failures left pending for retry
+func (idx *Indexer) settle(messages []redis.XMessage, failures map[string]string, consumerName string) int {
+	rd := idx.RedisClient
+
+	// delivery counts only matter if something failed
+	var deliveries map[string]int64
+	if len(failures) > 0 {
+		var failed []redis.XMessage
+		for _, message := range messages {
+			if _, ok := failures[message.ID]; ok {
+				failed = append(failed, message)
+			}
+		}
+		sort.Slice(failed, func(i, j int) bool {
+			return compareStreamIDs(failed[i].ID, failed[j].ID) < 0
+		})
+
+		var err error
+		deliveries, err = rd.DeliveryCounts(idx.StreamName, idx.GroupName, consumerName, failed)
 		if err != nil {
-			log.Println("FAILED to ACK message: ", err)
+			log.Println("Failed to read delivery counts: ", err)
+		}
+	}
+
+	retrying := 0
+	for _, message := range messages {
+		reason, failed := failures[message.ID]
+		if !failed {
+			// Acknowledge reading page on shared Redis stream
+			idx.ack(message.ID)
+			continue
+		}
+
+		count := deliveries[message.ID]
+		if idx.DeadLetterStream != "" && count >= idx.maxDeliveries() {
+			idx.deadLetter(message, reason, count)
+			continue
+		}
+
+		// leave unacknowledged, message is redelivered on the next read
+		log.Printf("Message %s failed (delivery %d of %d): %s\n", message.ID, count, idx.maxDeliveries(), reason)
+		retrying++
+	}
+	return retrying
+}
+
+// Bumps the search generation unless this worker did within GENERATION_BUMP_INTERVAL
+// Pages indexed in between are bumped for by the worker loop once the interval passes
+func (idx *Indexer) bumpGeneration() {
+	idx.pendingBump = true
+	idx.flushGeneration(false)
+}
+
+// Bumps the search generation if pages were indexed since the last bump,
+// once GENERATION_BUMP_INTERVAL has passed unless forced, keeping the bump pending on failure
+func (idx *Indexer) flushGeneration(force bool) {
+	if !idx.pendingBump || (!force && time.Since(idx.lastBump) < GENERATION_BUMP_INTERVAL) {
+		return
+	}
+	if _, err := idx.Database.BumpGeneration(storage.SEARCH_GENERATION); err != nil {
+		log.Println("Failed to bump search generation: ", err)
+		return
+	}
+	idx.lastBump = time.Now()
+	idx.pendingBump = false
+}
+
+// Moves a message to the dead-letter stream, logging on failure
+// Without a dead-letter stream the message is dropped, acknowledged so it is not claimed again
+// Returns false if the message could not be dead-lettered and is still pending
+func (idx *Indexer) deadLetter(message redis.XMessage, reason string, deliveries int64) bool {
+	if idx.DeadLetterStream == "" {
+		log.Printf("Message %s dropped, no dead-letter stream: %s\n", message.ID, reason)
+		idx.ack(message.ID)
+		return true
+	}
+	err := idx.RedisClient.DeadLetter(idx.StreamName, idx.GroupName, idx.DeadLetterStream, message, reason, deliveries)
+	if err != nil {
+		log.Println("Failed to dead-letter message: ", err)
+		return false
+	}
+	log.Printf("Message %s moved to %s: %s\n", message.ID, idx.DeadLetterStream, reason)
+	return true
+}
+
+// Acknowledges a message on the index stream, logging on failure
+func (idx *Indexer) ack(id string) {
+	rd := idx.RedisClient
+	if _, err := rd.Client.XAck(rd.Ctx, idx.StreamName, idx.GroupName, id).Result(); err != nil {
+		log.Println("FAILED to ACK message: ", err)
+	}
+}
+
+// Compares two Redis stream ids of the form <ms>-<seq>
+func compareStreamIDs(a, b string) int {
+	var aMs, aSeq, bMs, bSeq uint64
+	fmt.Sscanf(a, "%d-%d", &aMs, &aSeq)
+	fmt.Sscanf(b, "%d-%d", &bMs, &bSeq)
+	switch {
+	case aMs != bMs:
+		if aMs < bMs {
+			return -1
 		}
+		return 1
+	case aSeq < bSeq:
+		return -1
+	case aSeq > bSeq:
+		return 1
 	}
+	return 0
 }
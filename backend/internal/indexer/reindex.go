@@ -0,0 +1,179 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Returns the collection name of an index version
+func VersionCollection(version int) string {
+	return fmt.Sprintf("%s_v%d", PAGE_INDEX_COLLECTION, version)
+}
+
+// Builds a new versioned index from every page in the raw page collection
+// Pages crawled during the build are caught up before the alias is swapped, and once more after,
+// so nothing indexed by live indexers into the old version is missed
+// If swap is false the new version is built but the alias is left untouched
+// If more than maxFailures pages fail to index, the version is marked failed and the alias is not swapped
+func Reindex(db *storage.Database, workers int, swap bool, maxFailures int) (*models.IndexVersion, error) {
+	// pick the next version number
+	versions, err := db.ListIndexVersions()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list index versions: %w", err)
+	}
+	number := 1
+	if len(versions) > 0 {
+		number = versions[0].Version + 1
+	}
+
+	// create the new collection with corpus stats and a unique term index
+	collectionname := VersionCollection(number)
+	db.AddCollection(DB_NAME, collectionname)
+	if err := db.InitializeIndexCorpus(collectionname); err != nil {
+		return nil, fmt.Errorf("Failed to initialize corpus stats in %s: %w", collectionname, err)
+	}
+	if err := db.MakeIndex(collectionname, "term"); err != nil {
+		return nil, fmt.Errorf("Failed to create term index in %s: %w", collectionname, err)
+	}
+
+	// record the build as in progress
+	version := &models.IndexVersion{
+		Collection: collectionname,
+		Version:    number,
+		Analyzer:   ANALYZER_VERSION,
+		Status:     models.INDEX_STATUS_BUILDING,
+		StartedAt:  time.Now(),
+	}
+	if err := db.SaveIndexVersion(version); err != nil {
+		return nil, fmt.Errorf("Failed to save index version: %w", err)
+	}
+	log.Printf("Building index version %d in %s\n", number, collectionname)
+
+	// records the build as failed before returning err
+	fail := func(err error) (*models.IndexVersion, error) {
+		version.Status = models.INDEX_STATUS_FAILED
+		version.FinishedAt = time.Now()
+		db.SaveIndexVersion(version)
+		return version, err
+	}
+
+	idx := &Indexer{Database: db, IndexCollection: collectionname}
+
+	// full build, then catch up on pages crawled meanwhile until there are none left
+	last := primitive.NilObjectID
+	for {
+		next, indexed, failed, err := idx.IndexPagesAfter(last, workers)
+		version.Failed += failed
+		if err != nil {
+			return fail(err)
+		}
+		log.Printf("Indexed %d pages into %s, %d failed\n", indexed, collectionname, failed)
+		// no pages newer than the last pass
+		if next == last {
+			break
+		}
+		last = next
+	}
+
+	// a version missing pages is never served
+	if version.Failed > maxFailures {
+		return fail(fmt.Errorf("%d pages failed to index into %s, at most %d allowed", version.Failed, collectionname, maxFailures))
+	}
+
+	if swap {
+		alias, err := db.SwapAlias(PAGE_INDEX_COLLECTION, collectionname)
+		if err != nil {
+			return fail(fmt.Errorf("Failed to swap alias: %w", err))
+		}
+		log.Printf("Alias %s now points to %s (previous %s)\n", alias.Name, alias.Target, alias.Previous)
+
+		// pages indexed into the old version between the last catch up and the swap
+		_, indexed, failed, err := idx.IndexPagesAfter(last, workers)
+		version.Failed += failed
+		if err != nil {
+			log.Println("Final catch up failed, run recovery for missing pages: ", err)
+		}
+		if failed > 0 {
+			log.Printf("WARNING: %d pages failed to index after swap, run recovery for missing pages\n", failed)
+		}
+		log.Printf("Caught up %d pages after swap\n", indexed)
+	}
+
+	// record the finished build
+	version.DocCount, _ = db.TotalDocCount(collectionname)
+	version.Status = models.INDEX_STATUS_READY
+	version.FinishedAt = time.Now()
+	if err := db.SaveIndexVersion(version); err != nil {
+		return version, fmt.Errorf("Failed to save index version: %w", err)
+	}
+	return version, nil
+}
+
+// Indexes all raw pages with an _id greater than after using concurrent workers
+// Returns the greatest _id seen, or after if there were no pages, and the numbers of pages indexed and failed
+func (idx *Indexer) IndexPagesAfter(after primitive.ObjectID, workers int) (primitive.ObjectID, int, int, error) {
+	db := idx.Database
+	ctx := *db.GetContext()
+
+	// object ids increase with insertion time, so sorting by _id visits pages in crawl order
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	cursor, err := db.GetCollection(PAGE_INSERT_COLLECTION).Find(ctx, bson.M{"_id": bson.M{"$gt": after}}, opts)
+	if err != nil {
+		return after, 0, 0, fmt.Errorf("Failed to read collection pages: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	// feed pages to workers
+	pages := make(chan models.PageData, workers*2)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	indexed, failed := 0, 0
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pages {
+				err := idx.IndexPage(page.ID.Hex(), &page)
+				mu.Lock()
+				if err != nil {
+					log.Printf("Failed to index page %s: %v\n", page.ID.Hex(), err)
+					failed++
+					mu.Unlock()
+					continue
+				}
+				indexed++
+				if indexed%1000 == 0 {
+					log.Printf("Indexed %d pages\n", indexed)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	last := after
+	for cursor.Next(ctx) {
+		var page models.PageData
+		if err := cursor.Decode(&page); err != nil {
+			log.Println("Error decoding page: ", err)
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			continue
+		}
+		last = page.ID
+		pages <- page
+	}
+	close(pages)
+	wg.Wait()
+
+	return last, indexed, failed, cursor.Err()
+}
@@ -0,0 +1,207 @@
+package ltr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+	"github.com/Jailior/open-search/backend/internal/search"
+)
+
+// Index of each feature in a vector
+const (
+	FEATURE_BM25          = iota // BM25 over the whole document
+	FEATURE_BM25_TITLE           // BM25 of the title alone
+	FEATURE_BM25_HEADINGS        // BM25 of the headings alone
+	FEATURE_BM25_URL             // BM25 of the URL alone
+	FEATURE_BM25_BODY            // BM25 of the body alone
+	FEATURE_TFIDF                // field boosted TF-IDF
+	FEATURE_PAGERANK             // normalized PageRank
+	FEATURE_URL_DEPTH            // number of URL path segments
+	FEATURE_TITLE_MATCH          // fraction of query terms in the title
+	FEATURE_PROXIMITY            // proximity boost of query terms in the body
+	FEATURE_FRESHNESS            // 1 / (1 + age in years), 0 if the date is unknown
+	FEATURE_CLICKS               // click-through over expected clicks, 1 without click data
+	FEATURE_QUERY_TERMS          // number of distinct query terms
+	FEATURE_MATCHED_TERMS        // fraction of distinct query terms in the document
+	FEATURE_DOC_LENGTH           // log(1 + tokens in the document)
+)
+
+// Names of the features, in vector order
+// Models are matched to features by name, so features may be added but never renamed
+var FEATURE_NAMES = []string{
+	"bm25", "bm25_title", "bm25_headings", "bm25_url", "bm25_body", "tfidf", "pagerank", "url_depth",
+	"title_match", "proximity", "freshness", "clicks", "query_terms", "matched_terms", "doc_length",
+}
+
+// Fields with their own BM25 feature
+var fieldFeatures = map[string]int{
+	models.FIELD_TITLE:    FEATURE_BM25_TITLE,
+	models.FIELD_HEADINGS: FEATURE_BM25_HEADINGS,
+	models.FIELD_URL:      FEATURE_BM25_URL,
+	models.FIELD_BODY:     FEATURE_BM25_BODY,
+}
+
+// Features of a (query, document), in the order of FEATURE_NAMES
+type Vector []float64
+
+// What features of a document are extracted from, mostly already fetched to rank it
+type FeatureInput struct {
+	QueryTerms []parsing.QueryTerm
+	TermStats  map[string]search.TermStats
+	Corpus     *models.CorpusStats
+	Ranking    search.RankingConfig
+
+	Doc        search.DocPostings
+	PageRank   float64
+	Proximity  float64
+	ClickScore float64 // 0 if the document has no click data
+	Now        time.Time
+}
+
+// Extracts the features of a document for a query
+func Extract(in *FeatureInput) Vector {
+	v := make(Vector, len(FEATURE_NAMES))
+
+	bm25 := &search.BM25{K1: in.Ranking.K1, B: in.Ranking.B, AvgLength: in.Corpus.AvgLength(), Corpus: in.Corpus}
+	tfidf := &search.TFIDF{Boosts: in.Ranking.Boosts}
+
+	distinct := make(map[string]bool)
+	matched := make(map[string]bool)
+	inTitle := make(map[string]bool)
+	for _, qt := range in.QueryTerms {
+		distinct[qt.Term] = true
+		posting, ok := in.Doc[qt.Term]
+		if !ok {
+			continue
+		}
+		stats := in.TermStats[qt.Term]
+
+		if score, ok := bm25.Score(qt, posting, stats); ok {
+			v[FEATURE_BM25] += score
+			matched[qt.Term] = true
+		}
+		// per-field scores only count unscoped terms and terms scoped to that field
+		for field, feature := range fieldFeatures {
+			if qt.Field != "" && qt.Field != field {
+				continue
+			}
+			if score, ok := bm25.Score(parsing.QueryTerm{Term: qt.Term, Field: field}, posting, stats); ok {
+				v[feature] += score
+			}
+		}
+		if score, ok := tfidf.Score(qt, posting, stats); ok {
+			v[FEATURE_TFIDF] += score
+		}
+		if fp, ok := posting.Fields[models.FIELD_TITLE]; ok && fp.Freq > 0 {
+			inTitle[qt.Term] = true
+		}
+	}
+
+	v[FEATURE_PAGERANK] = in.PageRank
+	v[FEATURE_PROXIMITY] = in.Proximity
+	v[FEATURE_CLICKS] = 1
+	if in.ClickScore > 0 {
+		v[FEATURE_CLICKS] = in.ClickScore
+	}
+	v[FEATURE_QUERY_TERMS] = float64(len(distinct))
+	if len(distinct) > 0 {
+		v[FEATURE_TITLE_MATCH] = float64(len(inTitle)) / float64(len(distinct))
+		v[FEATURE_MATCHED_TERMS] = float64(len(matched)) / float64(len(distinct))
+	}
+
+	// document attributes are the same on every posting
+	for _, posting := range in.Doc {
+		v[FEATURE_URL_DEPTH] = float64(urlDepth(posting.URL))
+		if !posting.Date.IsZero() {
+			age := max(in.Now.Sub(posting.Date).Hours()/(24*365), 0)
+			v[FEATURE_FRESHNESS] = 1 / (1 + age)
+		}
+		v[FEATURE_DOC_LENGTH] = math.Log1p(float64(posting.Length))
+		break
+	}
+	return v
+}
+
+// Returns the number of non-empty path segments of a URL
+func urlDepth(raw string) int {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0
+	}
+	depth := 0
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			depth++
+		}
+	}
+	return depth
+}
+
+// Writes a feature vector as an SVMlight/LETOR line, "grade qid:id 1:v1 2:v2 ... # comment"
+func WriteSVMLight(w io.Writer, grade int, qid int, v Vector, comment string) error {
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(grade))
+	sb.WriteString(" qid:")
+	sb.WriteString(strconv.Itoa(qid))
+	for i, value := range v {
+		sb.WriteString(" ")
+		sb.WriteString(strconv.Itoa(i + 1))
+		sb.WriteString(":")
+		sb.WriteString(strconv.FormatFloat(value, 'g', 8, 64))
+	}
+	if comment != "" {
+		sb.WriteString(" # ")
+		sb.WriteString(comment)
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Graded relevance of a document for a query, 0 is irrelevant
+type Judgment struct {
+	Query string
+	URL   string
+	Grade int
+}
+
+/*
+Reads a judgment list, one tab separated "query<TAB>url<TAB>grade" per line
+
+Grades are integers from 0 (irrelevant) up. Blank lines and lines starting with # are ignored.
+Judgments are returned in file order.
+*/
+func ReadJudgments(r io.Reader) ([]Judgment, error) {
+	var judgments []Judgment
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.Split(text, "\t")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("line %d: expected query, url and grade separated by tabs", line)
+		}
+		grade, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+		if err != nil || grade < 0 {
+			return nil, fmt.Errorf("line %d: grade must be a non-negative integer", line)
+		}
+		judgments = append(judgments, Judgment{
+			Query: strings.TrimSpace(parts[0]),
+			URL:   strings.TrimSpace(parts[1]),
+			Grade: grade,
+		})
+	}
+	return judgments, scanner.Err()
+}
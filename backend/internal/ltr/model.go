@@ -0,0 +1,267 @@
+package ltr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Bits of a LightGBM decision type
+const (
+	DECISION_CATEGORICAL  = 1 // categorical split, unsupported
+	DECISION_DEFAULT_LEFT = 2 // missing values go left
+)
+
+// How a LightGBM split treats missing values, bits 2 and 3 of the decision type
+const (
+	MISSING_NONE = 0
+	MISSING_ZERO = 1
+	MISSING_NAN  = 2
+)
+
+// Values at most this far from 0 count as zero for MISSING_ZERO splits, as in LightGBM
+const ZERO_THRESHOLD = 1e-35
+
+/*
+Gradient boosted tree ensemble loaded from a LightGBM text model, e.g. saved by model.save_model("model.txt")
+
+Only single output models with numerical splits are supported, such as lambdarank, regression or binary models.
+Predictions are raw scores, i.e. the sum of the trees' leaf values, which is what rankers order by.
+*/
+type Model struct {
+	Objective string
+	trees     []tree
+	features  []int // vector index of each model feature, -1 for a column no feature was written to
+	average   bool  // average instead of sum the trees, random forest models
+}
+
+// A regression tree, internal nodes are indexed from 0, leaves are referenced as ^leaf index
+type tree struct {
+	splitFeature []int
+	threshold    []float64
+	decisionType []int
+	leftChild    []int
+	rightChild   []int
+	leafValue    []float64
+}
+
+// Loads a LightGBM text model from a file
+func LoadModel(path string) (*Model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseModel(f)
+}
+
+/*
+Parses a LightGBM text model whose feature names are all in FEATURE_NAMES
+
+A model trained on an ltrexport file without the .features names has columns named Column_i,
+for the i-th feature of the export, which numbers features from 1, so Column_0 is never set
+*/
+func ParseModel(r io.Reader) (*Model, error) {
+	header := make(map[string]string)
+	var blocks []map[string]string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	current := header
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "end of trees" {
+			break
+		}
+		if strings.HasPrefix(line, "Tree=") {
+			current = make(map[string]string)
+			blocks = append(blocks, current)
+			continue
+		}
+		// flags such as average_output have no value
+		key, value, _ := strings.Cut(line, "=")
+		current[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if n := header["num_class"]; n != "" && n != "1" {
+		return nil, fmt.Errorf("multiclass models are not supported, num_class=%s", n)
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("model has no trees")
+	}
+
+	model := &Model{Objective: header["objective"]}
+	_, model.average = header["average_output"]
+
+	// map model features to vector indices by name
+	index := make(map[string]int, len(FEATURE_NAMES))
+	for i, name := range FEATURE_NAMES {
+		index[name] = i
+	}
+	for _, name := range strings.Fields(header["feature_names"]) {
+		i, ok := index[name]
+		// unnamed columns are matched to features by position
+		if column, unnamed := strings.CutPrefix(name, "Column_"); !ok && unnamed {
+			n, err := strconv.Atoi(column)
+			i, ok = n-1, err == nil && n >= 0 && n <= len(FEATURE_NAMES)
+		}
+		if !ok {
+			return nil, fmt.Errorf("model uses unknown feature %q", name)
+		}
+		model.features = append(model.features, i)
+	}
+
+	for i, block := range blocks {
+		t, err := parseTree(block, len(model.features))
+		if err != nil {
+			return nil, fmt.Errorf("tree %d: %w", i, err)
+		}
+		model.trees = append(model.trees, t)
+	}
+	return model, nil
+}
+
+// Parses a tree block of a LightGBM text model
+func parseTree(block map[string]string, numFeatures int) (tree, error) {
+	var t tree
+	if block["is_linear"] == "1" {
+		return t, fmt.Errorf("linear trees are not supported")
+	}
+	numLeaves, err := strconv.Atoi(block["num_leaves"])
+	if err != nil || numLeaves < 1 {
+		return t, fmt.Errorf("invalid num_leaves %q", block["num_leaves"])
+	}
+
+	if t.leafValue, err = parseFloats(block["leaf_value"]); err != nil {
+		return t, fmt.Errorf("leaf_value: %w", err)
+	}
+	if len(t.leafValue) != numLeaves {
+		return t, fmt.Errorf("expected %d leaf values, got %d", numLeaves, len(t.leafValue))
+	}
+	if numLeaves == 1 {
+		return t, nil
+	}
+
+	fields := []struct {
+		name   string
+		values *[]int
+	}{
+		{"split_feature", &t.splitFeature},
+		{"decision_type", &t.decisionType},
+		{"left_child", &t.leftChild},
+		{"right_child", &t.rightChild},
+	}
+	for _, field := range fields {
+		if *field.values, err = parseInts(block[field.name]); err != nil {
+			return t, fmt.Errorf("%s: %w", field.name, err)
+		}
+		if len(*field.values) != numLeaves-1 {
+			return t, fmt.Errorf("expected %d values of %s, got %d", numLeaves-1, field.name, len(*field.values))
+		}
+	}
+	if t.threshold, err = parseFloats(block["threshold"]); err != nil {
+		return t, fmt.Errorf("threshold: %w", err)
+	}
+	if len(t.threshold) != numLeaves-1 {
+		return t, fmt.Errorf("expected %d thresholds, got %d", numLeaves-1, len(t.threshold))
+	}
+
+	for node := range t.splitFeature {
+		if t.decisionType[node]&DECISION_CATEGORICAL != 0 {
+			return t, fmt.Errorf("categorical splits are not supported")
+		}
+		if t.splitFeature[node] < 0 || t.splitFeature[node] >= numFeatures {
+			return t, fmt.Errorf("split on feature %d of %d", t.splitFeature[node], numFeatures)
+		}
+		for _, child := range []int{t.leftChild[node], t.rightChild[node]} {
+			// children are created after their parent, which also rules out cycles
+			if child >= numLeaves-1 || ^child >= numLeaves || (child >= 0 && child <= node) {
+				return t, fmt.Errorf("invalid child %d of node %d", child, node)
+			}
+		}
+	}
+	return t, nil
+}
+
+// Returns the model's raw score of a feature vector
+func (m *Model) Predict(v Vector) float64 {
+	score := 0.0
+	for i := range m.trees {
+		score += m.trees[i].predict(v, m.features)
+	}
+	if m.average {
+		score /= float64(len(m.trees))
+	}
+	return score
+}
+
+// Returns the leaf value a feature vector falls into, following LightGBM's numerical decisions
+func (t *tree) predict(v Vector, features []int) float64 {
+	if len(t.splitFeature) == 0 {
+		return t.leafValue[0]
+	}
+
+	node := 0
+	for node >= 0 {
+		value := 0.0
+		if feature := features[t.splitFeature[node]]; feature >= 0 {
+			value = v[feature]
+		}
+		decision := t.decisionType[node]
+		missing := (decision >> 2) & 3
+
+		if math.IsNaN(value) && missing != MISSING_NAN {
+			value = 0
+		}
+		if (missing == MISSING_ZERO && math.Abs(value) <= ZERO_THRESHOLD) || (missing == MISSING_NAN && math.IsNaN(value)) {
+			if decision&DECISION_DEFAULT_LEFT != 0 {
+				node = t.leftChild[node]
+			} else {
+				node = t.rightChild[node]
+			}
+			continue
+		}
+
+		if value <= t.threshold[node] {
+			node = t.leftChild[node]
+		} else {
+			node = t.rightChild[node]
+		}
+	}
+	return t.leafValue[^node]
+}
+
+// Parses space separated integers
+func parseInts(s string) ([]int, error) {
+	fields := strings.Fields(s)
+	values := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = n
+	}
+	return values, nil
+}
+
+// Parses space separated floats
+func parseFloats(s string) ([]float64, error) {
+	fields := strings.Fields(s)
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		n, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = n
+	}
+	return values, nil
+}
@@ -2,8 +2,10 @@ package models
 
 import (
 	"log"
+	"sort"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -14,9 +16,21 @@ type PageData struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty"`
 	URL         string             `bson:"url"`
 	Title       string             `bson:"title"`
+	Headings    []string           `bson:"headings,omitempty"`
 	Content     string             `bson:"content"`
 	Outlinks    []string           `bson:"outlinks"`
 	TimeCrawled time.Time          `bson:"timecrawled"`
+	Published   time.Time          `bson:"published,omitempty"`    // from page metadata, zero if unknown
+	Language    string             `bson:"lang,omitempty"`         // ISO 639-1 code of the detected language
+	ContentType string             `bson:"content_type,omitempty"` // media type of the response, without parameters
+}
+
+// Returns the published date of the page if known, otherwise the time it was crawled
+func (pd *PageData) Date() time.Time {
+	if !pd.Published.IsZero() {
+		return pd.Published
+	}
+	return pd.TimeCrawled
 }
 
 // Prints key elements of a page
@@ -33,21 +47,165 @@ type IndexerQueueMessage struct {
 	ID string `json:"id"` // mongodb hex _id string
 }
 
+// Fields of a page indexed separately
+const (
+	FIELD_TITLE    = "title"
+	FIELD_URL      = "url"
+	FIELD_HEADINGS = "headings"
+	FIELD_BODY     = "body"
+)
+
+// All indexed fields
+var IndexedFields = []string{FIELD_TITLE, FIELD_URL, FIELD_HEADINGS, FIELD_BODY}
+
+// Returns true if field is an indexed field
+func IsIndexedField(field string) bool {
+	for _, f := range IndexedFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Occurrences of a term within one field of a page
+type FieldPosting struct {
+	Freq      int   `bson:"freq"`                // occurrences of the term in the field
+	Length    int   `bson:"length"`              // number of tokens in the field
+	Positions []int `bson:"positions,omitempty"` // token positions within the field
+}
+
+// Field postings of a term in a page keyed by field name
+type FieldPostings map[string]FieldPosting
+
+// Encodes fields in sorted order, so identical postings encode identically and $addToSet deduplicates them
+func (fp FieldPostings) MarshalBSON() ([]byte, error) {
+	keys := make([]string, 0, len(fp))
+	for key := range fp {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	doc := make(bson.D, 0, len(keys))
+	for _, key := range keys {
+		doc = append(doc, bson.E{Key: key, Value: fp[key]})
+	}
+	return bson.Marshal(doc)
+}
+
 // Information stored for a posting in a term document
 type IndexerPosting struct {
-	DocID     string  `bson:"doc_id"`
-	Title     string  `bson:"title"`
-	URL       string  `bson:"url"`
-	TF        float64 `bson:"TF"`
-	Positions []int   `bson:"positions"`
+	DocID     string        `bson:"doc_id"`
+	Title     string        `bson:"title"`
+	URL       string        `bson:"url"`
+	TF        float64       `bson:"TF"`
+	Count     int           `bson:"count"`               // raw occurrences of the term across all fields
+	Length    int           `bson:"length"`              // number of tokens in the page across all fields
+	Positions []int         `bson:"positions,omitempty"` // body positions of postings indexed without fields, see BodyPositions
+	Fields    FieldPostings `bson:"fields,omitempty"`
+	Host      string        `bson:"host"`     // lowercased host of URL, for site: filters
+	Date      time.Time     `bson:"date"`     // published or crawl date, for after: and before: filters
+	FileType  string        `bson:"filetype"` // lowercased URL extension, "html" if none
+
+	// attributes counted by facets, empty on postings indexed before they were stored
+	Language    string    `bson:"lang,omitempty"`
+	ContentType string    `bson:"content_type,omitempty"`
+	Crawled     time.Time `bson:"crawled,omitempty"`
+
+	// simhash of the body, a uint64 stored as int64, compared to diversify results
+	Simhash int64 `bson:"simhash,omitempty"`
+}
+
+// Returns the positions of the term in the page body, used for snippets and proximity
+// Postings with per-field postings keep them under the body field only
+func (p *IndexerPosting) BodyPositions() []int {
+	if len(p.Fields) > 0 {
+		return p.Fields[FIELD_BODY].Positions
+	}
+	return p.Positions
 }
 
 // Information representing a term document in database
 type TermEntry struct {
+	Term     string           `bson:"term"`
 	DF       int              `bson:"df"`
 	Postings []IndexerPosting `bson:"postings"`
 }
 
+// Alias document pointing readers and writers at a versioned index collection
+type IndexAlias struct {
+	Name      string    `bson:"_id"`
+	Target    string    `bson:"target"`
+	Previous  string    `bson:"previous"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// Index build statuses recorded in version metadata
+const (
+	INDEX_STATUS_BUILDING = "building"
+	INDEX_STATUS_READY    = "ready"
+	INDEX_STATUS_FAILED   = "failed"
+)
+
+// Build metadata recorded for each versioned index collection
+type IndexVersion struct {
+	Collection string    `bson:"_id"`
+	Version    int       `bson:"version"`
+	Analyzer   string    `bson:"analyzer"`
+	Status     string    `bson:"status"` // building, ready or failed
+	DocCount   int       `bson:"doc_count"`
+	Failed     int       `bson:"failed"` // pages that could not be indexed into the version
+	StartedAt  time.Time `bson:"started_at"`
+	FinishedAt time.Time `bson:"finished_at,omitempty"`
+}
+
+// Per-document token lengths, stored once per indexed page
+type DocStats struct {
+	DocID        string         `bson:"_id"`
+	URL          string         `bson:"url"`
+	Host         string         `bson:"host"`
+	Date         time.Time      `bson:"date"`
+	FileType     string         `bson:"filetype"`
+	Language     string         `bson:"lang,omitempty"`
+	ContentType  string         `bson:"content_type,omitempty"`
+	Crawled      time.Time      `bson:"crawled,omitempty"`
+	Simhash      int64          `bson:"simhash,omitempty"`
+	Length       int            `bson:"length"`
+	FieldLengths map[string]int `bson:"field_lengths"`
+	IndexedAt    time.Time      `bson:"indexed_at"`
+}
+
+// Corpus wide statistics stored in the corpus_stats document of an index
+type CorpusStats struct {
+	TotalPages   int              `bson:"total_pages"`
+	TotalLength  int64            `bson:"total_length"`
+	FieldLengths map[string]int64 `bson:"field_lengths"`
+}
+
+// Returns the average document length in tokens, 0 for an empty corpus
+func (cs *CorpusStats) AvgLength() float64 {
+	if cs.TotalPages == 0 {
+		return 0
+	}
+	return float64(cs.TotalLength) / float64(cs.TotalPages)
+}
+
+// Returns the average length of a field in tokens, 0 for an empty corpus
+func (cs *CorpusStats) AvgFieldLength(field string) float64 {
+	if cs.TotalPages == 0 {
+		return 0
+	}
+	return float64(cs.FieldLengths[field]) / float64(cs.TotalPages)
+}
+
+// Terms of an index modified by the indexer, read by the API to drop cached postings
+type IndexChange struct {
+	ID    primitive.ObjectID `bson:"_id,omitempty"`
+	Index string             `bson:"index"`
+	Terms []string           `bson:"terms"`
+	Time  time.Time          `bson:"time"`
+}
+
 /* PageRank models */
 
 // PageRank document stored in collection
@@ -56,6 +214,124 @@ type PageRankScore struct {
 	Score float64 `bson:"score"`
 }
 
+// A crawled page of the link graph with its links to and from other crawled pages
+// Attributes of the page are kept so pages linking to a URL can be filtered without the index
+type LinkNode struct {
+	URL         string    `bson:"url" json:"url"`
+	DocID       string    `bson:"doc_id" json:"doc_id"`
+	Host        string    `bson:"host" json:"host"`
+	Date        time.Time `bson:"date" json:"-"`
+	Language    string    `bson:"lang,omitempty" json:"-"`
+	ContentType string    `bson:"content_type,omitempty" json:"-"`
+	Crawled     time.Time `bson:"crawled" json:"-"`
+	Simhash     int64     `bson:"simhash,omitempty" json:"-"`
+	Outlinks    int       `bson:"outlinks" json:"outlinks"` // distinct crawled pages linked to
+	Inlinks     int       `bson:"inlinks" json:"inlinks"`   // distinct crawled pages linking here
+	Build       int64     `bson:"build" json:"-"`           // graph build that saved the node, older nodes are removed
+
+	ExternalInlinks int `bson:"external_inlinks" json:"external_inlinks"` // inlinks from pages of other hosts
+}
+
+// A link between two crawled pages, with the PageRank of both so either side can be listed best first
+type LinkEdge struct {
+	From     string  `bson:"from" json:"from"`
+	To       string  `bson:"to" json:"to"`
+	FromRank float64 `bson:"from_rank" json:"from_rank"`
+	ToRank   float64 `bson:"to_rank" json:"to_rank"`
+	Build    int64   `bson:"build" json:"-"`
+}
+
+// Links between a host and other hosts, aggregated from the link graph
+type HostLinks struct {
+	Host          string          `bson:"host" json:"host"`
+	Pages         int             `bson:"pages" json:"pages"`
+	InternalLinks int             `bson:"internal_links" json:"internal_links"` // links between pages of the host
+	InboundLinks  int             `bson:"inbound_links" json:"inbound_links"`   // links from pages of other hosts
+	OutboundLinks int             `bson:"outbound_links" json:"outbound_links"` // links to pages of other hosts
+	LinkingHosts  []HostLinkCount `bson:"linking_hosts" json:"linking_hosts"`   // hosts linking here most
+	LinkedHosts   []HostLinkCount `bson:"linked_hosts" json:"linked_hosts"`     // hosts linked to most
+	Build         int64           `bson:"build" json:"-"`
+}
+
+// Number of links from or to another host
+type HostLinkCount struct {
+	Host  string `bson:"host" json:"host"`
+	Links int    `bson:"links" json:"links"`
+}
+
+/* API models */
+
+// A query rewrite rule, from the synonym dictionaries or managed by admins in the rewrite_rules collection
+type RewriteRule struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Type      string             `bson:"type" json:"type"`                           // equivalent, oneway or replace
+	Match     []string           `bson:"match" json:"match"`                         // phrases the rule applies to, every phrase of an equivalent rule
+	Rewrite   []string           `bson:"rewrite,omitempty" json:"rewrite,omitempty"` // phrases matches expand to or are replaced with
+	Enabled   bool               `bson:"enabled" json:"enabled"`
+	Comment   string             `bson:"comment,omitempty" json:"comment,omitempty"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// A search recorded in the query log
+type QueryLogEntry struct {
+	Query     string    `bson:"query"`               // normalized query as typed
+	Corrected string    `bson:"corrected,omitempty"` // query searched instead if auto-corrected
+	Results   int       `bson:"results"`
+	LatencyMs float64   `bson:"latency_ms"`
+	Offset    int       `bson:"offset"`
+	Limit     int       `bson:"limit"`
+	Client    string    `bson:"client"` // salted hash of the client address, never the address itself
+	Time      time.Time `bson:"time"`
+
+	Docs []string `bson:"docs,omitempty"` // ids of the results shown, in rank order, i.e. impressions
+}
+
+// A click on a search result, recorded by the /click redirect
+type ClickEvent struct {
+	Query    string    `bson:"query"` // normalized query the results were for
+	DocID    string    `bson:"doc_id"`
+	Position int       `bson:"position"` // rank of the result from 1, across pages
+	Client   string    `bson:"client"`
+	Time     time.Time `bson:"time"`
+}
+
+// Number of impressions or clicks of a document for a query at one position
+type PositionCount struct {
+	Query    string `bson:"query"`
+	DocID    string `bson:"doc_id"`
+	Position int    `bson:"position"`
+	Count    int    `bson:"count"`
+}
+
+// Click-through of a document for a query, aggregated from the query log and clicks
+type ClickStats struct {
+	Query          string    `bson:"query"`
+	DocID          string    `bson:"doc_id"`
+	Impressions    int       `bson:"impressions"`
+	Clicks         int       `bson:"clicks"`
+	ExpectedClicks float64   `bson:"expected_clicks"` // clicks an average result would get at the same positions
+	Score          float64   `bson:"score"`           // smoothed clicks over expected clicks, 1 is average
+	UpdatedAt      time.Time `bson:"updated_at"`
+}
+
+// A query and how often it was searched
+type QueryCount struct {
+	Query      string    `bson:"_id" json:"query"`
+	Count      int       `bson:"count" json:"count"`
+	Clients    int       `bson:"clients" json:"clients"`
+	AvgResults float64   `bson:"avg_results" json:"avg_results"`
+	LastSeen   time.Time `bson:"last_seen" json:"last_seen"`
+}
+
+// Search latency over the query log, in milliseconds
+type LatencyStats struct {
+	Searches    int       `bson:"searches"`
+	MeanMs      float64   `bson:"mean_ms"`
+	MaxMs       float64   `bson:"max_ms"`
+	Percentiles []float64 `bson:"percentiles"` // in the order requested
+	Sampled     bool      `bson:"-"`           // percentiles estimated from a sample of the searches
+}
+
 /* Deprecated Models */
 
 // // Thread-safe FIFO Queue
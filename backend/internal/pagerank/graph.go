@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
 
 	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -13,13 +15,15 @@ import (
 
 // Graph structure, uses adjacency list representation
 type Graph struct {
-	vertices map[string][]string // adjacency list used for outlinks
+	vertices map[string][]string        // adjacency list used for outlinks
+	pages    map[string]models.LinkNode // attributes of each crawled page, without links
 }
 
 // Makes empty graph
 func MakeGraph() *Graph {
 	return &Graph{
 		vertices: make(map[string][]string),
+		pages:    make(map[string]models.LinkNode),
 	}
 }
 
@@ -82,6 +86,16 @@ func (g *Graph) BuildFromPages(collection *mongo.Collection, ctx context.Context
 
 		// add page
 		g.AddVertex(page.URL)
+		g.pages[page.URL] = models.LinkNode{
+			URL:         page.URL,
+			DocID:       page.ID.Hex(),
+			Host:        parsing.URLHost(page.URL),
+			Date:        page.Date(),
+			Language:    page.Language,
+			ContentType: page.ContentType,
+			Crawled:     page.TimeCrawled,
+			Simhash:     int64(parsing.Simhash(page.Content)),
+		}
 		crawledPages++
 
 		// add all outlinks
@@ -100,3 +114,118 @@ func (g *Graph) BuildFromPages(collection *mongo.Collection, ctx context.Context
 
 	return nil
 }
+
+// Returns the distinct pages a page links to, in link order
+func (g *Graph) Outlinks(url string) []string {
+	return distinct(g.vertices[url])
+}
+
+// Returns every crawled page with its numbers of outlinks and inlinks, stamped with a build
+func (g *Graph) LinkNodes(build int64) []models.LinkNode {
+	inlinks := make(map[string]int, len(g.pages))
+	external := make(map[string]int, len(g.pages))
+	for url, node := range g.pages {
+		for _, outlink := range g.Outlinks(url) {
+			inlinks[outlink]++
+			if g.pages[outlink].Host != node.Host {
+				external[outlink]++
+			}
+		}
+	}
+
+	nodes := make([]models.LinkNode, 0, len(g.pages))
+	for url, node := range g.pages {
+		node.Outlinks = len(g.Outlinks(url))
+		node.Inlinks = inlinks[url]
+		node.ExternalInlinks = external[url]
+		node.Build = build
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// Returns every link between crawled pages, with the PageRank of both ends, stamped with a build
+func (g *Graph) LinkEdges(ranks map[string]float64, build int64) []models.LinkEdge {
+	var edges []models.LinkEdge
+	for url := range g.pages {
+		for _, outlink := range g.Outlinks(url) {
+			edges = append(edges, models.LinkEdge{
+				From:     url,
+				To:       outlink,
+				FromRank: ranks[url],
+				ToRank:   ranks[outlink],
+				Build:    build,
+			})
+		}
+	}
+	return edges
+}
+
+// Returns the link stats of every host, with the top hosts linking to and from each, stamped with a build
+func (g *Graph) HostLinks(build int64, top int) []models.HostLinks {
+	hosts := make(map[string]*models.HostLinks)
+	linking := make(map[string]map[string]int) // host -> linking host -> links
+	linked := make(map[string]map[string]int)  // host -> linked host -> links
+
+	get := func(host string) *models.HostLinks {
+		if hosts[host] == nil {
+			hosts[host] = &models.HostLinks{Host: host, Build: build}
+			linking[host] = make(map[string]int)
+			linked[host] = make(map[string]int)
+		}
+		return hosts[host]
+	}
+
+	for url, node := range g.pages {
+		from := get(node.Host)
+		from.Pages++
+		for _, outlink := range g.Outlinks(url) {
+			toHost := g.pages[outlink].Host
+			if toHost == node.Host {
+				from.InternalLinks++
+				continue
+			}
+			to := get(toHost)
+			from.OutboundLinks++
+			to.InboundLinks++
+			linked[node.Host][toHost]++
+			linking[toHost][node.Host]++
+		}
+	}
+
+	stats := make([]models.HostLinks, 0, len(hosts))
+	for host, hl := range hosts {
+		hl.LinkingHosts = topHosts(linking[host], top)
+		hl.LinkedHosts = topHosts(linked[host], top)
+		stats = append(stats, *hl)
+	}
+	return stats
+}
+
+// Returns the n hosts with the most links, most first
+func topHosts(counts map[string]int, n int) []models.HostLinkCount {
+	list := make([]models.HostLinkCount, 0, len(counts))
+	for host, links := range counts {
+		list = append(list, models.HostLinkCount{Host: host, Links: links})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Links != list[j].Links {
+			return list[i].Links > list[j].Links
+		}
+		return list[i].Host < list[j].Host
+	})
+	return list[:min(len(list), n)]
+}
+
+// Returns urls without repeats, keeping the first occurrence of each
+func distinct(urls []string) []string {
+	seen := make(map[string]bool, len(urls))
+	unique := make([]string, 0, len(urls))
+	for _, url := range urls {
+		if !seen[url] {
+			seen[url] = true
+			unique = append(unique, url)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,80 @@
+package pagerank
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Link nodes, edges or host stats written per bulk write
+const LINK_BATCH_SIZE = 1000
+
+// Hosts linking to and linked from each host that are kept in its stats
+const TOP_LINKED_HOSTS = 20
+
+/*
+Saves the graph to the links collection, one document per page with its numbers of links,
+each link to the edges collection, one document per link with the PageRank of both pages,
+and link stats of each host to the hosts collection
+
+Documents are replaced in place, then those of earlier builds removed,
+so readers see either the previous or the new graph for each page while it is saved
+*/
+func SaveLinkGraph(g *Graph, ranks map[string]float64, links, edges, hosts *mongo.Collection, ctx context.Context) error {
+	build := time.Now().UnixNano()
+
+	nodes := g.LinkNodes(build)
+	err := bulkReplace(links, len(nodes), func(i int) (bson.M, interface{}) {
+		return bson.M{"url": nodes[i].URL}, nodes[i]
+	}, ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to save link graph: %w", err)
+	}
+
+	linkEdges := g.LinkEdges(ranks, build)
+	err = bulkReplace(edges, len(linkEdges), func(i int) (bson.M, interface{}) {
+		return bson.M{"from": linkEdges[i].From, "to": linkEdges[i].To}, linkEdges[i]
+	}, ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to save links: %w", err)
+	}
+
+	hostLinks := g.HostLinks(build, TOP_LINKED_HOSTS)
+	err = bulkReplace(hosts, len(hostLinks), func(i int) (bson.M, interface{}) {
+		return bson.M{"host": hostLinks[i].Host}, hostLinks[i]
+	}, ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to save host link stats: %w", err)
+	}
+
+	// pages, links and hosts no longer crawled
+	for _, collection := range []*mongo.Collection{links, edges, hosts} {
+		if _, err := collection.DeleteMany(ctx, bson.M{"build": bson.M{"$ne": build}}); err != nil {
+			return fmt.Errorf("Failed to remove stale %s: %w", collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Upserts n documents in batches of LINK_BATCH_SIZE, document returns the filter and replacement of the i-th
+func bulkReplace(collection *mongo.Collection, n int, document func(i int) (bson.M, interface{}), ctx context.Context) error {
+	writes := make([]mongo.WriteModel, 0, LINK_BATCH_SIZE)
+	for i := 0; i < n; i++ {
+		filter, replacement := document(i)
+		writes = append(writes, mongo.NewReplaceOneModel().
+			SetFilter(filter).
+			SetReplacement(replacement).
+			SetUpsert(true))
+		if len(writes) == LINK_BATCH_SIZE || i == n-1 {
+			if _, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+				return err
+			}
+			writes = writes[:0]
+		}
+	}
+	return nil
+}
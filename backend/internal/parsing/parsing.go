@@ -3,7 +3,9 @@ package parsing
 import (
 	"fmt"
 	"net/url"
+	"path"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/PuerkitoBio/goquery"
@@ -115,9 +117,83 @@ func CleanText(doc *goquery.Selection) string {
 	return text
 }
 
+// Returns the lowercased host of a URL without port, empty if the URL cannot be parsed
+func URLHost(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(parsed.Hostname())
+}
+
+// Returns the lowercased extension of a URL's path without the dot, "html" if it has none
+func URLFileType(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "html"
+	}
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(parsed.Path), "."))
+	if ext == "" || ext == "htm" {
+		return "html"
+	}
+	return ext
+}
+
+// Meta tags commonly holding a page's publication date, in order of preference
+var publishedSelectors = []string{
+	"meta[property='article:published_time']",
+	"meta[property='og:published_time']",
+	"meta[itemprop='datePublished']",
+	"meta[name='date']",
+	"meta[name='dc.date']",
+}
+
+// Date layouts accepted in publication date meta tags
+var publishedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// Returns the publication date declared in a page's metadata, zero if absent or unparseable
+func ExtractPublished(doc *goquery.Selection) time.Time {
+	for _, selector := range publishedSelectors {
+		content, ok := doc.Find(selector).First().Attr("content")
+		if !ok {
+			continue
+		}
+		content = strings.TrimSpace(content)
+		for _, layout := range publishedLayouts {
+			if t, err := time.Parse(layout, content); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Returns the text of every h1-h6 heading in the document, in document order
+func ExtractHeadings(doc *goquery.Selection) []string {
+	var headings []string
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(_ int, s *goquery.Selection) {
+		heading := strings.Join(strings.Fields(s.Text()), " ")
+		if heading != "" {
+			headings = append(headings, heading)
+		}
+	})
+	return headings
+}
+
 // Returns words and their positions in the text
 // Tracks only non-stopwords
 func TokenizeText(text string) map[string][]int {
+	words, _ := TokenizeTextWithLength(text)
+	return words
+}
+
+// Returns words and their positions in the text, and the total number of tokens including stopwords
+// Tracks only non-stopwords
+func TokenizeTextWithLength(text string) (map[string][]int, int) {
 	words := make(map[string][]int)
 
 	allTokens := strings.FieldsFunc(text, func(r rune) bool {
@@ -138,7 +214,18 @@ func TokenizeText(text string) map[string][]int {
 		tokenIndex++
 	}
 
-	return words
+	return words, tokenIndex
+}
+
+// Returns words of a URL's host and path and their positions, and the total number of tokens
+// The scheme, "www" and query string are ignored
+func TokenizeURL(rawURL string) (map[string][]int, int) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return TokenizeTextWithLength(rawURL)
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+	return TokenizeTextWithLength(host + " " + parsed.Path)
 }
 
 // Splits query into non-stopword list of strings
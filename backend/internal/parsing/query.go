@@ -0,0 +1,846 @@
+package parsing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Largest slop accepted on a phrase
+const MAX_PHRASE_SLOP = 50
+
+// A query term, optionally restricted to one field
+type QueryTerm struct {
+	Field string // empty if the term may match any field
+	Term  string
+
+	// words of the query the term was added for as a synonym or rewrite, empty for a term of the query as written
+	Expansion string
+}
+
+// A word of a phrase and its offset from the first word, stopwords are skipped but still counted
+type PhraseTerm struct {
+	Term   string
+	Offset int
+}
+
+// A quoted phrase, its terms must appear in order with at most Slop extra positions between them
+type Phrase struct {
+	Field string // empty if the phrase may match in any field
+	Terms []PhraseTerm
+	Slop  int
+
+	// words of the query the phrase was added for as a synonym or rewrite, empty for a phrase of the query as written
+	Expansion string
+}
+
+/* Query AST */
+
+// Node of a parsed query
+type Node interface {
+	String() string
+}
+
+// Single term, optionally scoped to a field
+type TermNode struct {
+	QueryTerm
+}
+
+// Quoted phrase of at least two terms
+type PhraseNode struct {
+	Phrase
+}
+
+// Restriction on a document attribute, e.g. site:example.com
+// Filters never match documents on their own, they only narrow the documents matched by terms
+type FilterNode struct {
+	Op    string    // one of the filter operators
+	Value string    // lowercased operator value
+	Date  time.Time // parsed value of after: and before:
+
+	// URLs of the pages linking to the link: value, resolved from the link graph before filtering
+	Linking map[string]bool
+}
+
+// Boolean combination of clauses
+// A document matches if it matches every Must and Filter clause, no MustNot clause,
+// and, if there are no Must clauses, at least one Should clause
+type BoolNode struct {
+	Must    []Node
+	Should  []Node
+	MustNot []Node
+	Filter  []Node
+}
+
+// Filter operators
+const (
+	OP_SITE     = "site"
+	OP_FILETYPE = "filetype"
+	OP_AFTER    = "after"
+	OP_BEFORE   = "before"
+	OP_LINK     = "link"
+)
+
+// Operators that are shorthands for a field prefix
+var fieldOperators = map[string]string{
+	"intitle": "title",
+	"inurl":   "url",
+}
+
+// Date layouts accepted by after: and before:, from most to least precise
+var filterDateLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// Error in a malformed query, Pos is the byte offset in the query where the error was found
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+func (n *TermNode) String() string {
+	if n.Field != "" {
+		return n.Field + ":" + n.Term
+	}
+	return n.Term
+}
+
+func (n *PhraseNode) String() string {
+	words := make([]string, len(n.Terms))
+	for i, pt := range n.Terms {
+		words[i] = pt.Term
+	}
+	s := `"` + strings.Join(words, " ") + `"`
+	if n.Slop > 0 {
+		s += "~" + strconv.Itoa(n.Slop)
+	}
+	if n.Field != "" {
+		s = n.Field + ":" + s
+	}
+	return s
+}
+
+func (n *FilterNode) String() string {
+	return n.Op + ":" + n.Value
+}
+
+func (n *BoolNode) String() string {
+	var parts []string
+	for _, c := range n.Filter {
+		parts = append(parts, "#"+c.String())
+	}
+	for _, c := range n.Must {
+		parts = append(parts, "+"+c.String())
+	}
+	for _, c := range n.Should {
+		parts = append(parts, c.String())
+	}
+	for _, c := range n.MustNot {
+		parts = append(parts, "-"+c.String())
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+/* Parser */
+
+// Parses a search query into an AST
+//
+// Supported syntax, adjacent clauses are OR'ed together:
+//
+//	golang rust          either term
+//	golang AND rust      both terms, AND binds tighter than OR
+//	golang OR rust       either term
+//	NOT rust, -rust      exclude documents containing rust
+//	+golang rust         golang is required, rust only adds to the score
+//	"distributed systems"~2   phrase with optional slop
+//	(golang OR rust) AND web  grouping
+//	title:golang, title:"go tips", title:(go OR rust)   field prefixes from fields
+//	intitle:golang, inurl:golang   same as title: and url:
+//	site:example.com, site:example.com/blog   host or subdomain, optionally a path prefix
+//	filetype:pdf   URL extension
+//	after:2024-01, before:2024   publication or crawl date, after: is inclusive and before: exclusive
+//	link:example.com/page   pages linking to a URL
+//
+// Filter operators narrow results rather than match on their own, so the query must contain a term,
+// except for link: which also finds every page linking to its URL on its own
+// Stopwords are dropped unless the query consists only of stopwords
+func Parse(query string, fields []string) (Node, error) {
+	tokens, err := lex(query, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := parseTokens(tokens, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// if query only consists of stop word(s), keep them
+	if len(QueryTerms(node)) == 0 {
+		node, err = parseTokens(tokens, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(QueryTerms(node)) == 0 && !IsLinkQuery(node) {
+		return nil, &ParseError{Pos: 0, Msg: "query must contain at least one search term or link:, operators and excluded terms only narrow results"}
+	}
+	return node, nil
+}
+
+// Parses lexed tokens, optionally keeping stopwords
+func parseTokens(tokens []token, keepStopwords bool) (Node, error) {
+	p := &parser{tokens: tokens, keepStopwords: keepStopwords}
+	node, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &ParseError{Pos: t.pos, Msg: "unmatched ')'"}
+	}
+	return node, nil
+}
+
+// Clause occurrence within a boolean node
+type occur int
+
+const (
+	occurShould occur = iota
+	occurMust
+	occurMustNot
+)
+
+// Recursive descent parser over lexed tokens
+type parser struct {
+	tokens        []token
+	i             int
+	keepStopwords bool
+}
+
+// Returns the next token without consuming it
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+// Consumes and returns the next token
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// Returns true if the next token cannot start a clause
+func (p *parser) atClauseEnd() bool {
+	switch p.peek().kind {
+	case tokEOF, tokRParen, tokAnd, tokOr:
+		return true
+	}
+	return false
+}
+
+// Parses a sequence of clauses joined by OR or by nothing
+// Returns nil if every clause was dropped, e.g. only stopwords
+func (p *parser) parseOr(field string) (Node, error) {
+	b := &BoolNode{}
+	clauses := 0
+
+	for {
+		t := p.peek()
+		if t.kind == tokEOF || t.kind == tokRParen {
+			break
+		}
+
+		switch t.kind {
+		case tokOr:
+			if clauses == 0 {
+				return nil, &ParseError{Pos: t.pos, Msg: "OR must follow a term"}
+			}
+			p.next()
+			if p.atClauseEnd() {
+				return nil, &ParseError{Pos: t.pos, Msg: "OR must be followed by a term"}
+			}
+			continue
+		case tokAnd:
+			return nil, &ParseError{Pos: t.pos, Msg: "AND must follow a term"}
+		}
+
+		occ, node, err := p.parseAnd(field)
+		if err != nil {
+			return nil, err
+		}
+		b.add(occ, node)
+		clauses++
+	}
+
+	return b.simplify(), nil
+}
+
+// Parses clauses joined by AND, every clause of an AND group is required
+func (p *parser) parseAnd(field string) (occur, Node, error) {
+	occ, node, err := p.parseUnary(field)
+	if err != nil || p.peek().kind != tokAnd {
+		return occ, node, err
+	}
+
+	and := &BoolNode{}
+	and.addRequired(occ, node)
+	for p.peek().kind == tokAnd {
+		t := p.next()
+		if p.atClauseEnd() {
+			return occurShould, nil, &ParseError{Pos: t.pos, Msg: "AND must be followed by a term"}
+		}
+		occ, node, err := p.parseUnary(field)
+		if err != nil {
+			return occurShould, nil, err
+		}
+		and.addRequired(occ, node)
+	}
+	return occurShould, and.simplify(), nil
+}
+
+// Parses a clause with an optional NOT, '-' or '+' prefix
+func (p *parser) parseUnary(field string) (occur, Node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNot, tokMinus, tokPlus:
+		p.next()
+		if p.atClauseEnd() {
+			return occurShould, nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("%s must be followed by a term", t.text)}
+		}
+		_, node, err := p.parseUnary(field)
+		if t.kind == tokPlus {
+			return occurMust, node, err
+		}
+		return occurMustNot, node, err
+	}
+
+	node, err := p.parsePrimary(field)
+	return occurShould, node, err
+}
+
+// Parses a term, phrase, field prefixed clause or parenthesized group
+func (p *parser) parsePrimary(field string) (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		node, err := p.parseOr(field)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRParen {
+			return nil, &ParseError{Pos: t.pos, Msg: "missing closing ')'"}
+		}
+		p.next()
+		if node == nil && p.tokens[p.i-2].kind == tokLParen {
+			return nil, &ParseError{Pos: t.pos, Msg: "empty group"}
+		}
+		return node, nil
+
+	case tokField:
+		if field != "" {
+			return nil, &ParseError{Pos: t.pos, Msg: "nested field prefix"}
+		}
+		switch p.peek().kind {
+		case tokWord, tokPhrase, tokLParen:
+			return p.parsePrimary(t.text)
+		}
+		return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("expected a term after '%s:'", t.text)}
+
+	case tokWord:
+		// a token may still contain several words, e.g. open-search
+		b := &BoolNode{}
+		for _, word := range queryWords(t.text) {
+			if p.keepStopwords || !Stopwords[word] {
+				b.Should = append(b.Should, &TermNode{QueryTerm{Field: field, Term: word}})
+			}
+		}
+		return b.simplify(), nil
+
+	case tokPhrase:
+		phrase := makePhrase(field, t.text, t.slop, p.keepStopwords)
+		switch len(phrase.Terms) {
+		case 0:
+			return nil, nil
+		case 1:
+			return &TermNode{QueryTerm{Field: field, Term: phrase.Terms[0].Term}}, nil
+		}
+		return &PhraseNode{phrase}, nil
+
+	case tokFilter:
+		return parseFilter(t)
+
+	case tokRParen:
+		return nil, &ParseError{Pos: t.pos, Msg: "unmatched ')'"}
+	case tokEOF:
+		return nil, &ParseError{Pos: t.pos, Msg: "unexpected end of query"}
+	}
+	return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t.text)}
+}
+
+// Parses the value of a filter operator token
+func parseFilter(t token) (Node, error) {
+	filter := &FilterNode{Op: t.text, Value: strings.ToLower(t.value)}
+	switch t.text {
+	case OP_LINK:
+		// URL paths are case sensitive
+		filter.Value = t.value
+	case OP_SITE:
+		filter.Value = strings.TrimPrefix(strings.TrimPrefix(filter.Value, "https://"), "http://")
+	case OP_FILETYPE:
+		filter.Value = strings.TrimPrefix(filter.Value, ".")
+	case OP_AFTER, OP_BEFORE:
+		for _, layout := range filterDateLayouts {
+			if date, err := time.Parse(layout, t.value); err == nil {
+				filter.Date = date
+				return filter, nil
+			}
+		}
+		return nil, &ParseError{Pos: t.pos, Msg: fmt.Sprintf("invalid date '%s', expected YYYY, YYYY-MM or YYYY-MM-DD", t.value)}
+	}
+	return filter, nil
+}
+
+// Adds a clause, dropped clauses are nil
+// Optional filters are treated as required, e.g. golang site:example.com only matches example.com
+func (b *BoolNode) add(occ occur, node Node) {
+	if node == nil {
+		return
+	}
+	if _, ok := node.(*FilterNode); ok && occ != occurMustNot {
+		b.Filter = append(b.Filter, node)
+		return
+	}
+	switch occ {
+	case occurMust:
+		b.Must = append(b.Must, node)
+	case occurMustNot:
+		b.MustNot = append(b.MustNot, node)
+	default:
+		b.Should = append(b.Should, node)
+	}
+}
+
+// Adds a clause of an AND group, optional clauses become required
+func (b *BoolNode) addRequired(occ occur, node Node) {
+	if occ == occurShould {
+		occ = occurMust
+	}
+	b.add(occ, node)
+}
+
+// Returns the node with single child booleans unwrapped, nil if empty
+func (b *BoolNode) simplify() Node {
+	total := len(b.Must) + len(b.Should) + len(b.MustNot) + len(b.Filter)
+	switch {
+	case total == 0:
+		return nil
+	case total == 1 && len(b.Should) == 1:
+		return b.Should[0]
+	case total == 1 && len(b.Must) == 1:
+		return b.Must[0]
+	}
+	return b
+}
+
+/* Lexer */
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokPlus
+	tokMinus
+	tokAnd
+	tokOr
+	tokNot
+	tokField
+	tokWord
+	tokPhrase
+	tokFilter
+)
+
+// Lexed query token
+type token struct {
+	kind  tokenKind
+	pos   int    // byte offset in the query
+	text  string // word, phrase text, field name or operator
+	value string // filter operator value
+	slop  int    // phrase slop
+}
+
+// Splits a query into tokens
+func lex(query string, fields []string) ([]token, error) {
+	var tokens []token
+	i := 0
+
+	for i < len(query) {
+		r, size := utf8.DecodeRuneInString(query[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			continue
+		case unicode.IsControl(r):
+			return nil, &ParseError{Pos: i, Msg: "invalid character"}
+		case r == '(':
+			tokens = append(tokens, token{kind: tokLParen, pos: i, text: "("})
+			i++
+			continue
+		case r == ')':
+			tokens = append(tokens, token{kind: tokRParen, pos: i, text: ")"})
+			i++
+			continue
+		case r == '+' || r == '-':
+			// prefix operator only at the start of a token, e.g. -rust but not open-search
+			kind := tokPlus
+			if r == '-' {
+				kind = tokMinus
+			}
+			tokens = append(tokens, token{kind: kind, pos: i, text: string(r)})
+			i++
+			continue
+		case r == '"':
+			t, end, err := lexPhrase(query, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+			i = end
+			continue
+		}
+
+		// word up to whitespace, a parenthesis or a quote
+		start := i
+		for i < len(query) {
+			r, size := utf8.DecodeRuneInString(query[i:])
+			if unicode.IsSpace(r) || r == '(' || r == ')' || r == '"' {
+				break
+			}
+			// a known field prefix ends the word
+			if r == ':' {
+				prefix := strings.ToLower(query[start:i])
+				if field, ok := fieldOperators[prefix]; ok && isField(field, fields) {
+					prefix = field
+				}
+				if isField(prefix, fields) {
+					tokens = append(tokens, token{kind: tokField, pos: start, text: prefix})
+					start = i + 1
+				} else if isFilterOperator(prefix) {
+					// filter value runs to the end of the word
+					t, end, err := lexFilter(query, start, i+1, prefix)
+					if err != nil {
+						return nil, err
+					}
+					tokens = append(tokens, t)
+					start, i = end, end
+					break
+				}
+			}
+			i += size
+		}
+		if start == i {
+			continue
+		}
+
+		word := query[start:i]
+		switch word {
+		case "AND":
+			tokens = append(tokens, token{kind: tokAnd, pos: start, text: word})
+		case "OR":
+			tokens = append(tokens, token{kind: tokOr, pos: start, text: word})
+		case "NOT":
+			tokens = append(tokens, token{kind: tokNot, pos: start, text: word})
+		default:
+			tokens = append(tokens, token{kind: tokWord, pos: start, text: word})
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokEOF, pos: len(query), text: "end of query"})
+	return tokens, nil
+}
+
+// Lexes a quoted phrase starting at query[start] == '"' with an optional ~N slop
+// Returns the token and the offset after it
+func lexPhrase(query string, start int) (token, int, error) {
+	end := strings.Index(query[start+1:], `"`)
+	if end < 0 {
+		return token{}, 0, &ParseError{Pos: start, Msg: "unclosed quote"}
+	}
+	end += start + 1
+
+	t := token{kind: tokPhrase, pos: start, text: query[start+1 : end]}
+	i := end + 1
+
+	// optional slop
+	if i < len(query) && query[i] == '~' {
+		j := i + 1
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+		slop, err := strconv.Atoi(query[i+1 : j])
+		if err != nil {
+			return token{}, 0, &ParseError{Pos: i, Msg: "expected a number after '~'"}
+		}
+		if slop > MAX_PHRASE_SLOP {
+			return token{}, 0, &ParseError{Pos: i, Msg: fmt.Sprintf("slop must be at most %d", MAX_PHRASE_SLOP)}
+		}
+		t.slop = slop
+		i = j
+	}
+	return t, i, nil
+}
+
+// Lexes the value of a filter operator, from valueStart up to whitespace or a parenthesis
+// Returns the token and the offset after it
+func lexFilter(query string, start, valueStart int, op string) (token, int, error) {
+	end := valueStart
+	for end < len(query) {
+		r, size := utf8.DecodeRuneInString(query[end:])
+		if unicode.IsSpace(r) || r == '(' || r == ')' {
+			break
+		}
+		end += size
+	}
+	if end == valueStart {
+		return token{}, 0, &ParseError{Pos: start, Msg: fmt.Sprintf("expected a value after '%s:'", op)}
+	}
+	return token{kind: tokFilter, pos: start, text: op, value: query[valueStart:end]}, end, nil
+}
+
+// Returns true if name is a filter operator
+func isFilterOperator(name string) bool {
+	switch name {
+	case OP_SITE, OP_FILETYPE, OP_AFTER, OP_BEFORE, OP_LINK:
+		return true
+	}
+	return false
+}
+
+// Returns true if name is one of fields
+func isField(name string, fields []string) bool {
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds a phrase of text's words as a quoted phrase of a query is, stopwords are kept only if every word is one
+func NewPhrase(field, text string) Phrase {
+	phrase := makePhrase(field, text, 0, false)
+	if len(phrase.Terms) == 0 {
+		phrase = makePhrase(field, text, 0, true)
+	}
+	return phrase
+}
+
+// Builds a phrase from quoted text, stopwords are dropped unless kept but always keep their place in the offsets
+func makePhrase(field, text string, slop int, keepStopwords bool) Phrase {
+	phrase := Phrase{Field: field, Slop: slop}
+	offset := 0
+	first := -1
+	for _, word := range queryWords(text) {
+		if keepStopwords || !Stopwords[word] {
+			if first < 0 {
+				first = offset
+			}
+			phrase.Terms = append(phrase.Terms, PhraseTerm{Term: word, Offset: offset - first})
+		}
+		offset++
+	}
+	return phrase
+}
+
+// Splits text into lowercased words as query terms are, dropping punctuation, stopwords are kept
+func QueryWords(text string) []string {
+	return queryWords(text)
+}
+
+// Splits text into lowercased words, dropping punctuation
+func queryWords(text string) []string {
+	var words []string
+	tokens := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, raw := range tokens {
+		if word := strings.ToLower(raw); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+/* AST helpers */
+
+// Rewrites the words of a query's terms and phrases, leaving operators, fields, filters and punctuation untouched
+// fn receives each lowercased word and returns its replacement, or false to keep the word
+// Returns the rewritten query and true if any word changed, the query is returned unchanged if it does not lex
+func RewriteWords(query string, fields []string, fn func(word string) (string, bool)) (string, bool) {
+	tokens, err := lex(query, fields)
+	if err != nil {
+		return query, false
+	}
+
+	var sb strings.Builder
+	last, changed := 0, false
+	for _, t := range tokens {
+		start := t.pos
+		switch t.kind {
+		case tokWord:
+		case tokPhrase:
+			start++ // skip the opening quote
+		default:
+			continue
+		}
+		end := start + len(t.text)
+
+		// letters and digits of the token, as split by queryWords
+		i := start
+		for i < end {
+			r, size := utf8.DecodeRuneInString(query[i:])
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				i += size
+				continue
+			}
+			j := i
+			for j < end {
+				r, size := utf8.DecodeRuneInString(query[j:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				j += size
+			}
+			if replacement, ok := fn(strings.ToLower(query[i:j])); ok {
+				sb.WriteString(query[last:i])
+				sb.WriteString(replacement)
+				last, changed = j, true
+			}
+			i = j
+		}
+	}
+	sb.WriteString(query[last:])
+	return sb.String(), changed
+}
+
+// Returns the distinct terms that can make a document match, i.e. not under a MustNot clause
+// Phrase words are included with the phrase's field
+// A term both written and added as an expansion counts once, as written
+func QueryTerms(node Node) []QueryTerm {
+	var terms []QueryTerm
+	seen := make(map[QueryTerm]int)
+	walk(node, false, func(qt QueryTerm, negated bool) {
+		if negated {
+			return
+		}
+		key := QueryTerm{Field: qt.Field, Term: qt.Term}
+		if i, ok := seen[key]; ok {
+			if qt.Expansion == "" {
+				terms[i].Expansion = ""
+			}
+			return
+		}
+		seen[key] = len(terms)
+		terms = append(terms, qt)
+	})
+	return terms
+}
+
+// Returns every distinct term in the query, including excluded ones, in query order
+func AllTerms(node Node) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	walk(node, false, func(qt QueryTerm, _ bool) {
+		if !seen[qt.Term] {
+			seen[qt.Term] = true
+			terms = append(terms, qt.Term)
+		}
+	})
+	return terms
+}
+
+// Calls fn on every term in the tree, negated is true below a MustNot clause
+func walk(node Node, negated bool, fn func(qt QueryTerm, negated bool)) {
+	switch n := node.(type) {
+	case *TermNode:
+		fn(n.QueryTerm, negated)
+	case *PhraseNode:
+		for _, pt := range n.Terms {
+			fn(QueryTerm{Field: n.Field, Term: pt.Term, Expansion: n.Expansion}, negated)
+		}
+	case *BoolNode:
+		for _, c := range n.Must {
+			walk(c, negated, fn)
+		}
+		for _, c := range n.Should {
+			walk(c, negated, fn)
+		}
+		for _, c := range n.MustNot {
+			walk(c, !negated, fn)
+		}
+		for _, c := range n.Filter {
+			walk(c, negated, fn)
+		}
+	}
+}
+
+// Returns the filters every matching document must pass, and those it must fail, at the top of the tree
+// These can be applied to postings before documents are evaluated and scored
+func TopLevelFilters(node Node) (required []*FilterNode, excluded []*FilterNode) {
+	b, ok := node.(*BoolNode)
+	if !ok {
+		return nil, nil
+	}
+	for _, c := range append(b.Filter, b.Must...) {
+		if f, ok := c.(*FilterNode); ok {
+			required = append(required, f)
+		}
+	}
+	for _, c := range b.MustNot {
+		if f, ok := c.(*FilterNode); ok {
+			excluded = append(excluded, f)
+		}
+	}
+	return required, excluded
+}
+
+// Returns true if the query has no search terms but a required link: filter, i.e. it asks for every page linking to a URL
+func IsLinkQuery(node Node) bool {
+	if len(QueryTerms(node)) > 0 {
+		return false
+	}
+	required, _ := TopLevelFilters(node)
+	for _, f := range required {
+		if f.Op == OP_LINK {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns every filter of an operator in the query tree, including excluded and nested ones
+func FiltersOf(node Node, op string) []*FilterNode {
+	switch n := node.(type) {
+	case *FilterNode:
+		if n.Op == op {
+			return []*FilterNode{n}
+		}
+	case *BoolNode:
+		var filters []*FilterNode
+		for _, clauses := range [][]Node{n.Filter, n.Must, n.Should, n.MustNot} {
+			for _, c := range clauses {
+				filters = append(filters, FiltersOf(c, op)...)
+			}
+		}
+		return filters
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package parsing
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// Consecutive words hashed together as one feature of a simhash
+const SIMHASH_SHINGLE = 3
+
+// Returns the 64-bit simhash of a text, near duplicate texts have fingerprints differing in few bits
+// Features are shingles of consecutive lowercased non-stopwords, 0 if the text has no words
+func Simhash(text string) uint64 {
+	var words []string
+	for _, raw := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		word := strings.ToLower(raw)
+		if !Stopwords[word] {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return 0
+	}
+
+	// sum of +1/-1 per bit over every feature hash
+	var weights [64]int
+	shingles := max(len(words)-SIMHASH_SHINGLE+1, 1)
+	for i := 0; i < shingles; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:min(i+SIMHASH_SHINGLE, len(words))], " ")))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for bit := 0; bit < 64; bit++ {
+		if weights[bit] > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+	return fingerprint
+}
+
+// Returns the fraction of equal bits of two simhashes, 1 for identical fingerprints
+func SimhashSimilarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
@@ -0,0 +1,72 @@
+package parsing
+
+import "strings"
+
+// Shortest stem left after stripping a suffix
+const MIN_STEM_LENGTH = 3
+
+// Suffixes stripped by Stem, longest first, with their replacement
+var stemSuffixes = []struct {
+	suffix, replacement string
+}{
+	{"ies", "y"},
+	{"sses", "ss"},
+	{"eed", "eed"}, // speed, need
+	{"ing", ""},
+	{"ed", ""},
+	{"es", ""},
+	{"s", ""},
+}
+
+/*
+Returns a light stem of a lowercased word, used to match inflected variants such as
+"index", "indexes", "indexed" and "indexing"
+
+Only common English inflections are stripped, words ending in "ss" and words that would
+become shorter than MIN_STEM_LENGTH or lose every vowel are kept as is
+*/
+func Stem(word string) string {
+	stem := stripSuffix(word)
+
+	// create, created and creating share "creat"
+	if len(stem) > MIN_STEM_LENGTH && strings.HasSuffix(stem, "e") && !strings.HasSuffix(stem, "ee") {
+		stem = stem[:len(stem)-1]
+	}
+	return stem
+}
+
+// Strips the first matching inflection suffix of a word
+func stripSuffix(word string) string {
+	if strings.HasSuffix(word, "ss") {
+		return word
+	}
+	for _, s := range stemSuffixes {
+		if !strings.HasSuffix(word, s.suffix) {
+			continue
+		}
+		// "es" is only a suffix after sibilants, pages -> page but indexes -> index
+		if s.suffix == "es" && !hasSibilantEnding(strings.TrimSuffix(word, "es")) {
+			continue
+		}
+		stem := strings.TrimSuffix(word, s.suffix) + s.replacement
+		if len(stem) < MIN_STEM_LENGTH || !strings.ContainsAny(stem, "aeiouy") {
+			continue
+		}
+		// running -> run, stopped -> stop
+		if (s.suffix == "ing" || s.suffix == "ed") && len(stem) > MIN_STEM_LENGTH && stem[len(stem)-1] == stem[len(stem)-2] && !strings.HasSuffix(stem, "ss") && !strings.HasSuffix(stem, "ll") {
+			stem = stem[:len(stem)-1]
+		}
+		return stem
+	}
+	return word
+}
+
+// Returns true if a word ends in a sound "es" is added after to pluralize
+func hasSibilantEnding(word string) bool {
+	for _, end := range []string{"s", "x", "z", "ch", "sh"} {
+		if strings.HasSuffix(word, end) {
+			return true
+		}
+	}
+	return false
+}
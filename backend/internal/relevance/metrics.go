@@ -0,0 +1,114 @@
+package relevance
+
+import (
+	"math"
+	"sort"
+)
+
+// Graded judgments of one query's documents keyed by URL, unjudged documents count as irrelevant
+type Grades map[string]int
+
+// Relevance metrics of one ranking of a query
+type Metrics struct {
+	NDCG      float64 `json:"ndcg"`      // normalized discounted cumulative gain at k
+	Precision float64 `json:"precision"` // fraction of the first k results relevant
+	AP        float64 `json:"ap"`        // average precision over the whole ranking, averaged into MAP
+	RR        float64 `json:"rr"`        // reciprocal rank of the first relevant result, averaged into MRR
+	Judged    float64 `json:"judged"`    // fraction of the first k results judged, low values make the metrics unreliable
+}
+
+/*
+Computes the metrics of a ranking of URLs, best first
+
+nDCG uses the exponential gain 2^grade - 1 normalized by the ideal ordering of every judged document.
+Precision, AP and RR count a document as relevant if its grade is at least threshold.
+*/
+func Evaluate(ranking []string, grades Grades, k, threshold int) Metrics {
+	var m Metrics
+
+	relevant := 0
+	for _, grade := range grades {
+		if grade >= threshold {
+			relevant++
+		}
+	}
+
+	dcg, hits, judged := 0.0, 0, 0
+	for i, url := range ranking {
+		grade, ok := grades[url]
+		if i < k {
+			if ok {
+				judged++
+			}
+			dcg += gain(grade) / math.Log2(float64(i+2))
+		}
+		if grade < threshold {
+			continue
+		}
+		hits++
+		if hits == 1 {
+			m.RR = 1 / float64(i+1)
+		}
+		m.AP += float64(hits) / float64(i+1)
+		if i < k {
+			m.Precision++
+		}
+	}
+
+	if ideal := IdealDCG(grades, k); ideal > 0 {
+		m.NDCG = dcg / ideal
+	}
+	if relevant > 0 {
+		m.AP /= float64(relevant)
+	}
+	if k > 0 {
+		m.Precision /= float64(k)
+		m.Judged = float64(judged) / float64(k)
+	}
+	return m
+}
+
+// Returns the DCG at k of the best possible ordering of the judged documents
+func IdealDCG(grades Grades, k int) float64 {
+	sorted := make([]int, 0, len(grades))
+	for _, grade := range grades {
+		sorted = append(sorted, grade)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+
+	dcg := 0.0
+	for i := 0; i < len(sorted) && i < k; i++ {
+		dcg += gain(sorted[i]) / math.Log2(float64(i+2))
+	}
+	return dcg
+}
+
+// Returns the mean of metrics over queries
+func Mean(all []Metrics) Metrics {
+	var mean Metrics
+	if len(all) == 0 {
+		return mean
+	}
+	for _, m := range all {
+		mean.NDCG += m.NDCG
+		mean.Precision += m.Precision
+		mean.AP += m.AP
+		mean.RR += m.RR
+		mean.Judged += m.Judged
+	}
+	n := float64(len(all))
+	mean.NDCG /= n
+	mean.Precision /= n
+	mean.AP /= n
+	mean.RR /= n
+	mean.Judged /= n
+	return mean
+}
+
+// Returns the exponential gain of a grade, rewarding highly relevant documents more than linearly
+func gain(grade int) float64 {
+	if grade <= 0 {
+		return 0
+	}
+	return math.Exp2(float64(grade)) - 1
+}
@@ -0,0 +1,53 @@
+package rewrite
+
+// Synonyms applied unless SYNONYMS_FILE replaces them, in dictionary syntax
+var SYNONYMS = mustParseRules([]string{
+	"js, javascript, ecmascript",
+	"ts, typescript",
+	"py, python",
+	"k8s, kubernetes",
+	"postgres, postgresql",
+	"mongo, mongodb",
+	"regex, regexp, regular expression",
+	"repo, repository",
+	"docs, documentation",
+	"config, configuration",
+	"auth, authentication",
+	"db, database",
+	"env, environment variable",
+	"cli, command line",
+	"lib, library",
+	"pkg, package",
+	"usa, united states",
+	"uk, united kingdom",
+	"colour, color",
+	"tutorial, guide",
+})
+
+// Acronyms expanded unless ACRONYMS_FILE replaces them, the expansion also searches the acronym
+var ACRONYMS = mustParseRules([]string{
+	"ai, artificial intelligence",
+	"ml, machine learning",
+	"nlp, natural language processing",
+	"llm, large language model",
+	"api, application programming interface",
+	"cpu, central processing unit",
+	"gpu, graphics processing unit",
+	"ram, random access memory",
+	"os, operating system",
+	"html, hypertext markup language",
+	"css, cascading style sheets",
+	"http, hypertext transfer protocol",
+	"url, uniform resource locator",
+	"dns, domain name system",
+	"tcp, transmission control protocol",
+	"sql, structured query language",
+	"orm, object relational mapping",
+	"jwt, json web token",
+	"ci, continuous integration",
+	"oop, object oriented programming",
+	"ide, integrated development environment",
+	"sdk, software development kit",
+	"vm, virtual machine",
+	"pr, pull request",
+})
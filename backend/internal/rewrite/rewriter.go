@@ -0,0 +1,290 @@
+package rewrite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+// A phrase rules match and the phrases it is rewritten to
+type expansion struct {
+	match    []string // words of the matched phrase, as searched
+	source   string   // matched phrase, recorded on the terms it expands to
+	rewrites []string
+	replace  bool // the rewrites are searched instead of the match
+}
+
+/*
+Applies rewrite rules to parsed queries, safe for concurrent use
+
+Rules match runs of consecutive terms of one field spelling their words, or quoted phrases of exactly their words,
+longest match first. Expansions are added next to the match and marked so they can be weighted lower,
+replacements take its place. Excluded clauses and filters are left as written.
+*/
+type Rewriter struct {
+	expansions map[string][]*expansion // by first word of the matched phrase, longest match first
+	version    string
+	rules      int
+}
+
+// Makes a rewriter applying the enabled rules, rules matching the same phrase are merged
+func NewRewriter(rules []models.RewriteRule) *Rewriter {
+	r := &Rewriter{expansions: make(map[string][]*expansion)}
+	byPhrase := make(map[string]*expansion)
+
+	add := func(phrase string, rewrites []string, replace bool) {
+		words := phraseWords(phrase)
+		if len(words) == 0 {
+			return
+		}
+		key := strings.Join(words, " ")
+		exp, ok := byPhrase[key]
+		if !ok {
+			exp = &expansion{match: words, source: key}
+			byPhrase[key] = exp
+			r.expansions[words[0]] = append(r.expansions[words[0]], exp)
+		}
+		// replacements take precedence over synonyms of the same phrase
+		if replace && !exp.replace {
+			exp.replace, exp.rewrites = true, nil
+		} else if exp.replace && !replace {
+			return
+		}
+		for _, rewrite := range rewrites {
+			if strings.Join(phraseWords(rewrite), " ") != key && !contains(exp.rewrites, rewrite) {
+				exp.rewrites = append(exp.rewrites, rewrite)
+			}
+		}
+	}
+
+	var enabled []models.RewriteRule
+	for _, rule := range rules {
+		if !rule.Enabled || Validate(&rule) != nil {
+			continue
+		}
+		enabled = append(enabled, rule)
+		switch rule.Type {
+		case RULE_EQUIVALENT:
+			for _, phrase := range rule.Match {
+				add(phrase, rule.Match, false)
+			}
+		case RULE_ONEWAY, RULE_REPLACE:
+			for _, phrase := range rule.Match {
+				add(phrase, rule.Rewrite, rule.Type == RULE_REPLACE)
+			}
+		}
+	}
+	for _, list := range r.expansions {
+		sort.SliceStable(list, func(i, j int) bool { return len(list[i].match) > len(list[j].match) })
+	}
+
+	// version identifies the rules, so results rewritten under other rules are not reused
+	data, _ := json.Marshal(enabled)
+	sum := sha256.Sum256(data)
+	r.version = hex.EncodeToString(sum[:8])
+	r.rules = len(enabled)
+	return r
+}
+
+// Returns an identifier of the rules, which changes whenever they do
+func (r *Rewriter) Version() string {
+	if r == nil {
+		return ""
+	}
+	return r.version
+}
+
+// Returns the number of rules applied
+func (r *Rewriter) Rules() int {
+	if r == nil {
+		return 0
+	}
+	return r.rules
+}
+
+// Returns the query with the rules applied, the query given is not modified
+// Returns the query as is if there are no rules
+func (r *Rewriter) Rewrite(node parsing.Node) parsing.Node {
+	if r == nil || len(r.expansions) == 0 {
+		return node
+	}
+	switch n := node.(type) {
+	case *parsing.TermNode, *parsing.PhraseNode:
+		b := r.rewriteBool(&parsing.BoolNode{Should: []parsing.Node{node}})
+		if len(b.Should) == 1 {
+			return b.Should[0]
+		}
+		return b
+	case *parsing.BoolNode:
+		return r.rewriteBool(n)
+	}
+	return node
+}
+
+// Rewrites the required and optional clauses of a boolean node
+func (r *Rewriter) rewriteBool(b *parsing.BoolNode) *parsing.BoolNode {
+	return &parsing.BoolNode{
+		Must:    r.rewriteClauses(b.Must, true),
+		Should:  r.rewriteClauses(b.Should, false),
+		MustNot: b.MustNot,
+		Filter:  b.Filter,
+	}
+}
+
+// Rewrites a list of clauses, optional ones are followed by their expansions,
+// while a required match becomes one clause requiring either the match or an expansion
+func (r *Rewriter) rewriteClauses(clauses []parsing.Node, required bool) []parsing.Node {
+	if len(clauses) == 0 {
+		return clauses
+	}
+	// clauses already in the list are not added again
+	present := make(map[string]bool, len(clauses))
+	for _, clause := range clauses {
+		present[clause.String()] = true
+	}
+
+	out := make([]parsing.Node, 0, len(clauses))
+	var added []parsing.Node
+	for i := 0; i < len(clauses); {
+		exp, n, field := r.match(clauses, i)
+		var rewrites []parsing.Node
+		if exp != nil {
+			for _, node := range exp.nodes(field) {
+				if !present[node.String()] {
+					present[node.String()] = true
+					rewrites = append(rewrites, node)
+				}
+			}
+		}
+		if len(rewrites) == 0 {
+			clause := clauses[i]
+			if nested, ok := clause.(*parsing.BoolNode); ok {
+				clause = r.rewriteBool(nested)
+			}
+			out = append(out, clause)
+			i++
+			continue
+		}
+
+		matched := clauses[i : i+n]
+		switch {
+		case exp.replace && required:
+			out = append(out, either(rewrites))
+		case exp.replace:
+			out = append(out, rewrites...)
+		case required:
+			out = append(out, either(append([]parsing.Node{all(matched)}, rewrites...)))
+		default:
+			out = append(out, matched...)
+			added = append(added, rewrites...)
+		}
+		i += n
+	}
+	return append(out, added...)
+}
+
+// Returns the longest rule matching the clauses from i, the number of clauses it spans and their field
+func (r *Rewriter) match(clauses []parsing.Node, i int) (*expansion, int, string) {
+	switch c := clauses[i].(type) {
+	case *parsing.PhraseNode:
+		if c.Expansion != "" {
+			return nil, 0, ""
+		}
+		words := make([]string, len(c.Terms))
+		for j, pt := range c.Terms {
+			words[j] = pt.Term
+		}
+		for _, exp := range r.expansions[words[0]] {
+			if equal(exp.match, words) {
+				return exp, 1, c.Field
+			}
+		}
+
+	case *parsing.TermNode:
+		if c.Expansion != "" {
+			return nil, 0, ""
+		}
+		// consecutive terms of the same field
+		words := []string{c.Term}
+		for j := i + 1; j < len(clauses); j++ {
+			next, ok := clauses[j].(*parsing.TermNode)
+			if !ok || next.Field != c.Field || next.Expansion != "" {
+				break
+			}
+			words = append(words, next.Term)
+		}
+		for _, exp := range r.expansions[c.Term] {
+			if len(exp.match) <= len(words) && equal(exp.match, words[:len(exp.match)]) {
+				return exp, len(exp.match), c.Field
+			}
+		}
+	}
+	return nil, 0, ""
+}
+
+// Returns the query nodes of an expansion's rewrites in a field
+// Expanded terms record the phrase they were added for, replacements count as written
+func (exp *expansion) nodes(field string) []parsing.Node {
+	source := exp.source
+	if exp.replace {
+		source = ""
+	}
+	nodes := make([]parsing.Node, 0, len(exp.rewrites))
+	for _, rewrite := range exp.rewrites {
+		phrase := parsing.NewPhrase(field, rewrite)
+		phrase.Expansion = source
+		switch len(phrase.Terms) {
+		case 0:
+			continue
+		case 1:
+			nodes = append(nodes, &parsing.TermNode{QueryTerm: parsing.QueryTerm{Field: field, Term: phrase.Terms[0].Term, Expansion: source}})
+		default:
+			nodes = append(nodes, &parsing.PhraseNode{Phrase: phrase})
+		}
+	}
+	return nodes
+}
+
+// Returns a node matching if any of nodes does
+func either(nodes []parsing.Node) parsing.Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &parsing.BoolNode{Should: nodes}
+}
+
+// Returns a node matching if all of nodes do
+func all(nodes []parsing.Node) parsing.Node {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	return &parsing.BoolNode{Must: nodes}
+}
+
+// Returns true if a and b hold the same words in order
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
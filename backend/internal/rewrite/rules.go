@@ -0,0 +1,105 @@
+package rewrite
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+// Kinds of rewrite rules
+const (
+	RULE_EQUIVALENT = "equivalent" // each phrase also searches the others, e.g. js, javascript
+	RULE_ONEWAY     = "oneway"     // matched phrases also search the rewrites but not the reverse, e.g. usa => united states
+	RULE_REPLACE    = "replace"    // matched phrases are searched as the rewrites instead
+)
+
+/*
+Parses a rule written in dictionary syntax
+
+	js, javascript, ecmascript    equivalent phrases
+	usa, u s => united states     one-way, matched phrases on the left also search those on the right
+*/
+func ParseRule(line string) (models.RewriteRule, error) {
+	rule := models.RewriteRule{Type: RULE_EQUIVALENT, Enabled: true}
+	match, rewrite, oneway := strings.Cut(line, "=>")
+	rule.Match = splitPhrases(match)
+	if oneway {
+		rule.Type = RULE_ONEWAY
+		rule.Rewrite = splitPhrases(rewrite)
+	}
+	return rule, Validate(&rule)
+}
+
+// Reads a dictionary of rules, one per line, skipping blank lines and # comments
+func ParseRules(r io.Reader) ([]models.RewriteRule, error) {
+	var rules []models.RewriteRule
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		rule, err := ParseRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// Parses the built-in rules, which are known to be valid
+func mustParseRules(lines []string) []models.RewriteRule {
+	rules, err := ParseRules(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// Returns an error if a rule cannot be applied
+func Validate(rule *models.RewriteRule) error {
+	switch rule.Type {
+	case RULE_EQUIVALENT:
+		if len(rule.Match) < 2 {
+			return fmt.Errorf("an equivalent rule needs at least two phrases")
+		}
+	case RULE_ONEWAY, RULE_REPLACE:
+		if len(rule.Match) == 0 || len(rule.Rewrite) == 0 {
+			return fmt.Errorf("a %s rule needs phrases on both sides of =>", rule.Type)
+		}
+	default:
+		return fmt.Errorf("unknown rule type '%s', expected %s, %s or %s", rule.Type, RULE_EQUIVALENT, RULE_ONEWAY, RULE_REPLACE)
+	}
+	for _, phrase := range append(append([]string{}, rule.Match...), rule.Rewrite...) {
+		if len(phraseWords(phrase)) == 0 {
+			return fmt.Errorf("phrase '%s' has no words", phrase)
+		}
+	}
+	return nil
+}
+
+// Splits a comma separated list of phrases, dropping empty ones
+func splitPhrases(list string) []string {
+	var phrases []string
+	for _, phrase := range strings.Split(list, ",") {
+		if phrase = strings.Join(strings.Fields(phrase), " "); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+	return phrases
+}
+
+// Returns the words a phrase is searched as, stopwords are dropped as in queries
+func phraseWords(phrase string) []string {
+	terms := parsing.NewPhrase("", phrase).Terms
+	words := make([]string, len(terms))
+	for i, pt := range terms {
+		words[i] = pt.Term
+	}
+	return words
+}
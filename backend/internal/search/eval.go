@@ -0,0 +1,118 @@
+package search
+
+import (
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+// A document's postings for the query terms it contains, keyed by term
+type DocPostings map[string]*models.IndexerPosting
+
+// Groups postings of the fetched term entries by document
+func GroupByDoc(entries []models.TermEntry) map[string]DocPostings {
+	docs := make(map[string]DocPostings)
+	for e := range entries {
+		entry := &entries[e]
+		for i := range entry.Postings {
+			posting := &entry.Postings[i]
+			if docs[posting.DocID] == nil {
+				docs[posting.DocID] = make(DocPostings)
+			}
+			docs[posting.DocID][entry.Term] = posting
+		}
+	}
+	return docs
+}
+
+// Returns true if the document matches the query tree
+func Matches(node parsing.Node, doc DocPostings) bool {
+	switch n := node.(type) {
+	case *parsing.TermNode:
+		return HasTerm(doc, n.QueryTerm)
+	case *parsing.PhraseNode:
+		return MatchesPhrase(doc, n.Phrase)
+	case *parsing.FilterNode:
+		// document attributes are the same on every posting
+		for _, posting := range doc {
+			return MatchesFilter(n, posting)
+		}
+		return false
+	case *parsing.BoolNode:
+		for _, c := range n.Filter {
+			if !Matches(c, doc) {
+				return false
+			}
+		}
+		for _, c := range n.MustNot {
+			if Matches(c, doc) {
+				return false
+			}
+		}
+		for _, c := range n.Must {
+			if !Matches(c, doc) {
+				return false
+			}
+		}
+		if len(n.Must) > 0 {
+			return true
+		}
+		for _, c := range n.Should {
+			if Matches(c, doc) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns true if the document contains the term, in its field if the term is field scoped
+func HasTerm(doc DocPostings, qt parsing.QueryTerm) bool {
+	posting, ok := doc[qt.Term]
+	if !ok {
+		return false
+	}
+	if qt.Field == "" {
+		return true
+	}
+	return posting.Fields[qt.Field].Freq > 0
+}
+
+// Returns true if the phrase occurs in one of the document's fields, or in its field if field scoped
+func MatchesPhrase(doc DocPostings, phrase parsing.Phrase) bool {
+	offsets := make([]int, len(phrase.Terms))
+	for i, pt := range phrase.Terms {
+		if _, ok := doc[pt.Term]; !ok {
+			return false
+		}
+		offsets[i] = pt.Offset
+	}
+
+	// postings indexed before field-aware indexing only have combined positions
+	first := doc[phrase.Terms[0].Term]
+	if len(first.Fields) == 0 {
+		if phrase.Field != "" {
+			return false
+		}
+		lists := make([][]int, len(phrase.Terms))
+		for i, pt := range phrase.Terms {
+			lists[i] = doc[pt.Term].Positions
+		}
+		return MatchPhrase(lists, offsets, phrase.Slop)
+	}
+
+	// phrase must occur within a single field
+	fields := models.IndexedFields
+	if phrase.Field != "" {
+		fields = []string{phrase.Field}
+	}
+	for _, field := range fields {
+		lists := make([][]int, len(phrase.Terms))
+		for i, pt := range phrase.Terms {
+			lists[i] = doc[pt.Term].Fields[field].Positions
+		}
+		if MatchPhrase(lists, offsets, phrase.Slop) {
+			return true
+		}
+	}
+	return false
+}
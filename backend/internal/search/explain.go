@@ -0,0 +1,49 @@
+package search
+
+/*
+How a score was computed, as a tree of the values it was computed from, similar to Elasticsearch's explain
+
+Scoring records into an explanation as it computes. Methods are no-ops on a nil explanation,
+so normal scoring passes nil and runs the same code without recording anything.
+*/
+type Explanation struct {
+	Value       float64        `json:"value"`
+	Description string         `json:"description"`
+	Details     []*Explanation `json:"details,omitempty"`
+}
+
+// Returns a new explanation tree
+func NewExplanation(value float64, description string) *Explanation {
+	return &Explanation{Value: value, Description: description}
+}
+
+// Adds a value the explained value was computed from and returns it, nil if not explaining
+func (e *Explanation) Add(value float64, description string) *Explanation {
+	if e == nil {
+		return nil
+	}
+	child := &Explanation{Value: value, Description: description}
+	e.Details = append(e.Details, child)
+	return child
+}
+
+// Sets the explained value and description, for values only known once their details are computed
+func (e *Explanation) Set(value float64, description string) {
+	if e == nil {
+		return
+	}
+	e.Value = value
+	e.Description = description
+}
+
+// Returns true if scoring is being explained
+func (e *Explanation) Enabled() bool {
+	return e != nil
+}
+
+// Records an idf and the counts it is computed from
+func explainIDF(exp *Explanation, idf float64, description string, stats TermStats) {
+	idfExp := exp.Add(idf, description)
+	idfExp.Add(float64(stats.N), "N, documents in the index")
+	idfExp.Add(float64(stats.DF), "df, documents containing the term")
+}
@@ -0,0 +1,256 @@
+package search
+
+import (
+	"fmt"
+	"mime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+// Facets results can be counted and filtered by
+const FACET_HOST = "host"
+const FACET_LANG = "lang"
+const FACET_TYPE = "type"
+const FACET_DATE = "date"
+
+var FACETS = []string{FACET_HOST, FACET_LANG, FACET_TYPE, FACET_DATE}
+
+// Value of documents without a value for a facet, e.g. indexed before the attribute was stored
+const FACET_UNKNOWN = "unknown"
+
+// Layouts of crawl date buckets by interval, a date facet value's length tells its interval
+var DATE_INTERVALS = map[string]string{
+	"day":   "2006-01-02",
+	"month": "2006-01",
+	"year":  "2006",
+}
+
+// Number of documents with a facet value
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets to count for a search
+type FacetRequest struct {
+	Facets       []string // facet names, counted in this order
+	Limit        int      // most frequent values returned per facet, date buckets are all returned
+	DateInterval string   // key of DATE_INTERVALS
+}
+
+// Restricts results to documents with a facet value, e.g. host:example.com or date:2024-03
+type FacetFilter struct {
+	Facet string
+	Value string
+}
+
+// Parses a facet filter written facet:value
+func ParseFacetFilter(s string) (FacetFilter, error) {
+	facet, value, ok := strings.Cut(s, ":")
+	facet = strings.ToLower(strings.TrimSpace(facet))
+	value = strings.ToLower(strings.TrimSpace(value))
+	if !ok || value == "" {
+		return FacetFilter{}, fmt.Errorf("facet filter %q is not facet:value", s)
+	}
+	if !IsFacet(facet) {
+		return FacetFilter{}, fmt.Errorf("unknown facet %q, expected one of %s", facet, strings.Join(FACETS, ", "))
+	}
+	if facet == FACET_DATE && value != FACET_UNKNOWN && dateFilterLayout(value) == "" {
+		return FacetFilter{}, fmt.Errorf("date filter %q is not YYYY, YYYY-MM or YYYY-MM-DD", value)
+	}
+	return FacetFilter{Facet: facet, Value: value}, nil
+}
+
+// Returns true if name is a facet
+func IsFacet(name string) bool {
+	for _, facet := range FACETS {
+		if facet == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the value of a facet for a posting's document, date values are bucketed with layout
+func FacetValue(facet string, posting *models.IndexerPosting, layout string) string {
+	value := ""
+	switch facet {
+	case FACET_HOST:
+		value = posting.Host
+		if value == "" {
+			value = parsing.URLHost(posting.URL)
+		}
+	case FACET_LANG:
+		value = posting.Language
+	case FACET_TYPE:
+		value = postingContentType(posting)
+	case FACET_DATE:
+		crawled := posting.Crawled
+		if crawled.IsZero() {
+			// postings indexed before crawl dates were stored
+			crawled = posting.Date
+		}
+		if !crawled.IsZero() {
+			value = crawled.UTC().Format(layout)
+		}
+	}
+	if value == "" {
+		return FACET_UNKNOWN
+	}
+	return value
+}
+
+// Returns the media type of a posting's document, guessed from its URL extension if not stored
+func postingContentType(posting *models.IndexerPosting) string {
+	if posting.ContentType != "" {
+		return posting.ContentType
+	}
+	fileType := posting.FileType
+	if fileType == "" {
+		fileType = parsing.URLFileType(posting.URL)
+	}
+	if fileType == "html" {
+		return "text/html"
+	}
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension("." + fileType))
+	return mediaType
+}
+
+// Returns the date layout of a date filter value, empty if it is not a date
+func dateFilterLayout(value string) string {
+	for _, layout := range DATE_INTERVALS {
+		if len(value) == len(layout) {
+			if _, err := time.Parse(layout, value); err == nil {
+				return layout
+			}
+		}
+	}
+	return ""
+}
+
+// Removes postings of documents failing any facet filter from the fetched entries, in place
+// Filters on the same facet are alternatives, filters on different facets must all pass
+func ApplyFacetFilters(entries []models.TermEntry, filters []FacetFilter) {
+	if len(filters) == 0 {
+		return
+	}
+
+	byFacet := make(map[string][]FacetFilter)
+	for _, f := range filters {
+		byFacet[f.Facet] = append(byFacet[f.Facet], f)
+	}
+
+	for e := range entries {
+		kept := entries[e].Postings[:0]
+		for i := range entries[e].Postings {
+			if passesFacetFilters(&entries[e].Postings[i], byFacet, "") {
+				kept = append(kept, entries[e].Postings[i])
+			}
+		}
+		entries[e].Postings = kept
+	}
+}
+
+// Returns true if the posting passes a filter of every filtered facet, except the skipped facet's filters
+func passesFacetFilters(posting *models.IndexerPosting, byFacet map[string][]FacetFilter, skip string) bool {
+	for facet, filters := range byFacet {
+		if facet == skip {
+			continue
+		}
+		passes := false
+		for _, f := range filters {
+			layout := ""
+			if facet == FACET_DATE {
+				// formatting with no layout gives no value, so undated documents are checked directly
+				if f.Value == FACET_UNKNOWN {
+					if posting.Crawled.IsZero() && posting.Date.IsZero() {
+						passes = true
+						break
+					}
+					continue
+				}
+				layout = dateFilterLayout(f.Value)
+			}
+			if strings.ToLower(FacetValue(facet, posting, layout)) == f.Value {
+				passes = true
+				break
+			}
+		}
+		if !passes {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a copy of entries with postings of their own, so filtering one in place leaves the other as is
+func CloneEntries(entries []models.TermEntry) []models.TermEntry {
+	cloned := make([]models.TermEntry, len(entries))
+	for i, entry := range entries {
+		cloned[i] = entry
+		cloned[i].Postings = append([]models.IndexerPosting(nil), entry.Postings...)
+	}
+	return cloned
+}
+
+/*
+Counts facet values over every document matching the query tree and the facet filters
+
+The entries must not have facet filters applied. Each facet is counted over the documents passing
+the filters of every other facet, so the values of a filtered facet other than the selected ones
+are still counted and can be added to or switched to.
+Host, language and type values are ordered by count, date buckets by date
+*/
+func CountFacets(entries []models.TermEntry, node parsing.Node, req *FacetRequest, filters []FacetFilter) map[string][]FacetCount {
+	layout := DATE_INTERVALS[req.DateInterval]
+	counts := make(map[string]map[string]int, len(req.Facets))
+	for _, facet := range req.Facets {
+		counts[facet] = make(map[string]int)
+	}
+	byFacet := make(map[string][]FacetFilter)
+	for _, f := range filters {
+		byFacet[f.Facet] = append(byFacet[f.Facet], f)
+	}
+
+	for _, doc := range GroupByDoc(entries) {
+		if !Matches(node, doc) {
+			continue
+		}
+		// attributes are the same on each of a document's postings
+		var posting *models.IndexerPosting
+		for _, p := range doc {
+			posting = p
+			break
+		}
+		for _, facet := range req.Facets {
+			if passesFacetFilters(posting, byFacet, facet) {
+				counts[facet][FacetValue(facet, posting, layout)]++
+			}
+		}
+	}
+
+	facets := make(map[string][]FacetCount, len(counts))
+	for facet, values := range counts {
+		list := make([]FacetCount, 0, len(values))
+		for value, count := range values {
+			list = append(list, FacetCount{Value: value, Count: count})
+		}
+		if facet == FACET_DATE {
+			sort.Slice(list, func(i, j int) bool { return list[i].Value < list[j].Value })
+		} else {
+			sort.Slice(list, func(i, j int) bool {
+				if list[i].Count != list[j].Count {
+					return list[i].Count > list[j].Count
+				}
+				return list[i].Value < list[j].Value
+			})
+			list = list[:min(len(list), req.Limit)]
+		}
+		facets[facet] = list
+	}
+	return facets
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+// Returns true if the posting's document passes the filter
+func MatchesFilter(filter *parsing.FilterNode, posting *models.IndexerPosting) bool {
+	switch filter.Op {
+	case parsing.OP_SITE:
+		return matchesSite(filter.Value, posting)
+	case parsing.OP_FILETYPE:
+		fileType := posting.FileType
+		if fileType == "" {
+			// postings indexed before filters were stored
+			fileType = parsing.URLFileType(posting.URL)
+		}
+		return fileType == filter.Value
+	case parsing.OP_AFTER:
+		return !posting.Date.IsZero() && !posting.Date.Before(filter.Date)
+	case parsing.OP_BEFORE:
+		return !posting.Date.IsZero() && posting.Date.Before(filter.Date)
+	case parsing.OP_LINK:
+		return filter.Linking[posting.URL]
+	}
+	return false
+}
+
+// Returns true if the posting's host is site's host or a subdomain of it,
+// and its path starts with site's path if site has one, e.g. example.com/blog
+func matchesSite(site string, posting *models.IndexerPosting) bool {
+	siteHost, sitePath, _ := strings.Cut(site, "/")
+
+	host := posting.Host
+	if host == "" {
+		host = parsing.URLHost(posting.URL)
+	}
+	if host != siteHost && !strings.HasSuffix(host, "."+siteHost) {
+		return false
+	}
+
+	if sitePath == "" {
+		return true
+	}
+	parsed, err := url.Parse(posting.URL)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(strings.TrimPrefix(parsed.Path, "/")), sitePath)
+}
+
+// Removes postings failing the query's top level filters from the fetched entries, in place
+// Documents filtered out this way are never evaluated or scored
+func ApplyFilters(entries []models.TermEntry, node parsing.Node) {
+	required, excluded := parsing.TopLevelFilters(node)
+	if len(required) == 0 && len(excluded) == 0 {
+		return
+	}
+
+	for e := range entries {
+		kept := entries[e].Postings[:0]
+		for i := range entries[e].Postings {
+			if passesFilters(&entries[e].Postings[i], required, excluded) {
+				kept = append(kept, entries[e].Postings[i])
+			}
+		}
+		entries[e].Postings = kept
+	}
+}
+
+// Returns true if the posting passes every required filter and no excluded filter
+func passesFilters(posting *models.IndexerPosting, required, excluded []*parsing.FilterNode) bool {
+	for _, f := range required {
+		if !MatchesFilter(f, posting) {
+			return false
+		}
+	}
+	for _, f := range excluded {
+		if MatchesFilter(f, posting) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"math"
+	"sort"
+)
+
+// Returns true if the phrase occurs in a field
+// positions holds the sorted positions of each phrase term within the field, offsets the expected
+// distance of each term from the first, slop the number of extra positions allowed in total
+func MatchPhrase(positions [][]int, offsets []int, slop int) bool {
+	if len(positions) == 0 || len(positions) != len(offsets) {
+		return false
+	}
+	for _, p := range positions {
+		if len(p) == 0 {
+			return false
+		}
+	}
+
+	// try each occurrence of the first term as the phrase start
+	for _, start := range positions[0] {
+		if matchFrom(positions, offsets, slop, 1, start, start) {
+			return true
+		}
+	}
+	return false
+}
+
+// Places term i after prev, its expected position relative to start, with the slop left
+// Terms must appear in order, every position skipped beyond the expected one uses up slop
+func matchFrom(positions [][]int, offsets []int, slop int, i int, start int, prev int) bool {
+	if i == len(positions) {
+		return true
+	}
+
+	expected := start + offsets[i]
+	// first candidate after the previous term and not before the expected position
+	lo := max(prev+1, expected)
+	j := sort.SearchInts(positions[i], lo)
+
+	for ; j < len(positions[i]); j++ {
+		extra := positions[i][j] - expected
+		if extra > slop {
+			return false
+		}
+		// remaining terms are shifted by the extra positions used
+		if matchFrom(positions, offsets, slop-extra, i+1, start+extra, positions[i][j]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the length of the smallest window of positions containing at least one position from each list
+// The window of adjacent terms has length len(positions), math.MaxInt is returned if any list is empty
+func MinWindow(positions [][]int) int {
+	if len(positions) == 0 {
+		return math.MaxInt
+	}
+	for _, p := range positions {
+		if len(p) == 0 {
+			return math.MaxInt
+		}
+	}
+
+	// index into each list, always advancing the list holding the smallest position
+	idx := make([]int, len(positions))
+	best := math.MaxInt
+	for {
+		lo, hi, loList := math.MaxInt, math.MinInt, 0
+		for i, p := range positions {
+			pos := p[idx[i]]
+			if pos < lo {
+				lo, loList = pos, i
+			}
+			if pos > hi {
+				hi = pos
+			}
+		}
+		best = min(best, hi-lo+1)
+
+		idx[loList]++
+		if idx[loList] == len(positions[loList]) {
+			return best
+		}
+	}
+}
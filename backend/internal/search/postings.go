@@ -0,0 +1,121 @@
+package search
+
+import (
+	"sort"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+)
+
+// Upper bounds over a term's postings, scorers turn them into an upper bound of the term's score
+type TermBounds struct {
+	MaxCount  int                    // largest raw count of postings with raw counts
+	MinLength int                    // smallest document length of postings with raw counts
+	Uncounted bool                   // has postings indexed before raw counts were stored
+	Unfielded bool                   // has postings indexed before per-field postings were stored
+	MaxTF     float64                // largest TF of postings without per-field postings
+	Fields    map[string]FieldBounds // bounds of each field the term occurs in
+}
+
+// Upper bounds over a term's occurrences in one field
+type FieldBounds struct {
+	MaxFreq   int
+	MinLength int
+	MaxTF     float64 // largest freq/length
+}
+
+// Postings of a term ordered by doc id, with the bounds of the postings
+type PostingList struct {
+	Term     string
+	DF       int
+	Postings []models.IndexerPosting
+	Bounds   TermBounds
+}
+
+// Builds posting lists from fetched term entries, keyed by term
+// Sorts each entry's postings by doc id in place, keeping the last posting of a document indexed more than once
+func NewPostingLists(entries []models.TermEntry) map[string]*PostingList {
+	lists := make(map[string]*PostingList, len(entries))
+	for e := range entries {
+		lists[entries[e].Term] = NewPostingList(&entries[e])
+	}
+	return lists
+}
+
+// Builds the posting list of a term entry, see NewPostingLists
+func NewPostingList(entry *models.TermEntry) *PostingList {
+	postings := entry.Postings
+	if !sort.SliceIsSorted(postings, func(i, j int) bool { return postings[i].DocID < postings[j].DocID }) {
+		sort.SliceStable(postings, func(i, j int) bool { return postings[i].DocID < postings[j].DocID })
+	}
+
+	// drop all but the last posting of each document, matching GroupByDoc
+	kept := postings[:0]
+	for i := range postings {
+		if i+1 < len(postings) && postings[i+1].DocID == postings[i].DocID {
+			continue
+		}
+		kept = append(kept, postings[i])
+	}
+	entry.Postings = kept
+
+	return &PostingList{
+		Term:     entry.Term,
+		DF:       entry.DF,
+		Postings: kept,
+		Bounds:   computeBounds(kept),
+	}
+}
+
+// Returns the posting of a document, nil if the document does not contain the term
+func (pl *PostingList) Find(docID string) *models.IndexerPosting {
+	i := pl.seek(0, docID)
+	if i < len(pl.Postings) && pl.Postings[i].DocID == docID {
+		return &pl.Postings[i]
+	}
+	return nil
+}
+
+// Returns the index of the first posting at or after from with a doc id >= docID
+func (pl *PostingList) seek(from int, docID string) int {
+	return from + sort.Search(len(pl.Postings)-from, func(i int) bool {
+		return pl.Postings[from+i].DocID >= docID
+	})
+}
+
+// Computes the bounds of a term's postings
+func computeBounds(postings []models.IndexerPosting) TermBounds {
+	bounds := TermBounds{Fields: make(map[string]FieldBounds)}
+
+	for i := range postings {
+		posting := &postings[i]
+
+		if posting.Count > 0 {
+			bounds.MaxCount = max(bounds.MaxCount, posting.Count)
+			if bounds.MinLength == 0 || posting.Length < bounds.MinLength {
+				bounds.MinLength = posting.Length
+			}
+		} else {
+			bounds.Uncounted = true
+		}
+
+		if len(posting.Fields) == 0 {
+			bounds.Unfielded = true
+			bounds.MaxTF = max(bounds.MaxTF, posting.TF)
+			continue
+		}
+
+		for field, fp := range posting.Fields {
+			if fp.Freq == 0 {
+				continue
+			}
+			fb := bounds.Fields[field]
+			fb.MaxFreq = max(fb.MaxFreq, fp.Freq)
+			if fb.MinLength == 0 || fp.Length < fb.MinLength {
+				fb.MinLength = fp.Length
+			}
+			fb.MaxTF = max(fb.MaxTF, float64(fp.Freq)/float64(max(fp.Length, 1)))
+			bounds.Fields[field] = fb
+		}
+	}
+	return bounds
+}
@@ -0,0 +1,433 @@
+package search
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+// Ranking models selectable per request
+const (
+	MODEL_TFIDF = "tfidf"
+	MODEL_BM25  = "bm25"
+	MODEL_BM25F = "bm25f"
+)
+
+// Corpus statistics of a query term
+type TermStats struct {
+	DF int // number of documents containing the term
+	N  int // number of documents in the corpus
+}
+
+// Scores how relevant a document is to a single query term
+type Scorer interface {
+	// Name of the ranking model
+	Name() string
+	// Returns the text relevance of a posting for a query term, false if the posting does not count for the term,
+	// e.g. a field scoped term the posting's document only contains in another field
+	Score(qt parsing.QueryTerm, posting *models.IndexerPosting, stats TermStats) (float64, bool)
+	// Score, recording how it was computed into exp, Score is Explain with a nil exp
+	Explain(qt parsing.QueryTerm, posting *models.IndexerPosting, stats TermStats, exp *Explanation) (float64, bool)
+	// Returns an upper bound of Score over postings within bounds, used to skip documents that cannot make the top k
+	MaxScore(qt parsing.QueryTerm, bounds *TermBounds, stats TermStats) float64
+}
+
+// Scoring model and weights, chosen per request
+type RankingConfig struct {
+	Model  string             `json:"model"`
+	Alpha  float64            `json:"alpha"` // weight of text relevance, 1-Alpha is the weight of PageRank
+	K1     float64            `json:"k1"`    // BM25 term frequency saturation
+	B      float64            `json:"b"`     // BM25 length normalization
+	Boosts map[string]float64 `json:"boosts"`
+	Clicks float64            `json:"clicks"` // largest fraction of text relevance added or removed by click-through
+
+	// weight of terms added by synonyms and rewrite rules, terms of the query as written weigh 1
+	Expansion float64 `json:"expansion"`
+}
+
+// Returns the ranking used unless configured otherwise
+func DefaultRankingConfig() RankingConfig {
+	return RankingConfig{
+		Model:     MODEL_TFIDF,
+		Alpha:     0.2, // 0.2 favors relevance (TF-IDF) and 0.8 favors authority (PageRank)
+		K1:        1.2,
+		B:         0.75,
+		Clicks:    0.2,
+		Expansion: 0.5,
+		Boosts: map[string]float64{
+			models.FIELD_TITLE:    3.0,
+			models.FIELD_HEADINGS: 2.0,
+			models.FIELD_URL:      1.5,
+			models.FIELD_BODY:     1.0,
+		},
+	}
+}
+
+// Returns an error if a parameter is out of range
+func (cfg *RankingConfig) Validate() error {
+	switch cfg.Model {
+	case MODEL_TFIDF, MODEL_BM25, MODEL_BM25F:
+	default:
+		return fmt.Errorf("unknown ranking model '%s', expected %s, %s or %s", cfg.Model, MODEL_TFIDF, MODEL_BM25, MODEL_BM25F)
+	}
+	if outOfRange(cfg.Alpha, 0, 1) {
+		return fmt.Errorf("alpha must be between 0 and 1")
+	}
+	if outOfRange(cfg.K1, 0, 10) {
+		return fmt.Errorf("k1 must be between 0 and 10")
+	}
+	if outOfRange(cfg.B, 0, 1) {
+		return fmt.Errorf("b must be between 0 and 1")
+	}
+	if outOfRange(cfg.Clicks, 0, 1) {
+		return fmt.Errorf("clicks must be between 0 and 1")
+	}
+	if outOfRange(cfg.Expansion, 0, 1) {
+		return fmt.Errorf("expansion must be between 0 and 1")
+	}
+	for field, boost := range cfg.Boosts {
+		if math.IsNaN(boost) || math.IsInf(boost, 0) || boost < 0 {
+			return fmt.Errorf("boost for %s must be a non-negative number", field)
+		}
+	}
+	return nil
+}
+
+// Returns true if value is NaN or outside [lo, hi], range checks alone let NaN through
+func outOfRange(value, lo, hi float64) bool {
+	return math.IsNaN(value) || value < lo || value > hi
+}
+
+// Returns a copy of the config that can be modified without affecting the original
+func (cfg RankingConfig) Clone() RankingConfig {
+	boosts := make(map[string]float64, len(cfg.Boosts))
+	for field, boost := range cfg.Boosts {
+		boosts[field] = boost
+	}
+	cfg.Boosts = boosts
+	return cfg
+}
+
+// Returns the weight of a query term's score, lower for terms added by synonyms and rewrite rules
+func (cfg *RankingConfig) TermWeight(qt parsing.QueryTerm) float64 {
+	if qt.Expansion != "" {
+		return cfg.Expansion
+	}
+	return 1
+}
+
+// Blends a document's text relevance with its PageRank
+func (cfg *RankingConfig) Blend(text, rank float64) float64 {
+	return cfg.Alpha*text + (1-cfg.Alpha)*rank
+}
+
+// Returns the scorer for the configured model, using corpus for document counts and average lengths
+func NewScorer(cfg RankingConfig, corpus *models.CorpusStats) (Scorer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	switch cfg.Model {
+	case MODEL_BM25:
+		return &BM25{K1: cfg.K1, B: cfg.B, AvgLength: corpus.AvgLength(), Corpus: corpus}, nil
+	case MODEL_BM25F:
+		return &BM25F{K1: cfg.K1, B: cfg.B, Boosts: cfg.Boosts, Corpus: corpus}, nil
+	}
+	return &TFIDF{Boosts: cfg.Boosts}, nil
+}
+
+/* TF-IDF */
+
+// Field boosted TF-IDF with idf = log(N/df)
+type TFIDF struct {
+	Boosts map[string]float64
+}
+
+func (s *TFIDF) Name() string {
+	return MODEL_TFIDF
+}
+
+func (s *TFIDF) Score(qt parsing.QueryTerm, posting *models.IndexerPosting, stats TermStats) (float64, bool) {
+	return s.Explain(qt, posting, stats, nil)
+}
+
+func (s *TFIDF) Explain(qt parsing.QueryTerm, posting *models.IndexerPosting, stats TermStats, exp *Explanation) (float64, bool) {
+	tf, ok := fieldTF(posting, qt.Field, s.Boosts, exp.Add(0, "tf"))
+	if !ok {
+		return 0, false
+	}
+	idf := tfidfIDF(stats)
+	explainIDF(exp, idf, "idf, log(N / df)", stats)
+
+	exp.Set(tf*idf, "tfidf, tf * idf")
+	return tf * idf, true
+}
+
+func (s *TFIDF) MaxScore(qt parsing.QueryTerm, bounds *TermBounds, stats TermStats) float64 {
+	idf := tfidfIDF(stats)
+	if idf <= 0 {
+		return 0
+	}
+
+	// field scoped term, only that field counts
+	if qt.Field != "" {
+		return bounds.Fields[qt.Field].MaxTF * idf
+	}
+
+	// weighted average of each field's largest TF
+	var weighted, totalBoost float64
+	for _, f := range models.IndexedFields {
+		totalBoost += s.Boosts[f]
+		weighted += s.Boosts[f] * bounds.Fields[f].MaxTF
+	}
+	tf := bounds.MaxTF
+	if totalBoost > 0 {
+		tf = math.Max(tf, weighted/totalBoost)
+	}
+	return tf * idf
+}
+
+// Returns the TF-IDF idf, log(N/df)
+func tfidfIDF(stats TermStats) float64 {
+	return math.Log(float64(stats.N) / float64(stats.DF))
+}
+
+// Returns the boost weighted term frequency of a posting, normalized by the total boost so
+// scores stay on the same scale as unboosted TF
+// If field is set only that field counts, returns false if the term does not occur in it
+func FieldTF(posting *models.IndexerPosting, field string, boosts map[string]float64) (float64, bool) {
+	return fieldTF(posting, field, boosts, nil)
+}
+
+// FieldTF, recording the frequency and length of each field into exp
+func fieldTF(posting *models.IndexerPosting, field string, boosts map[string]float64, exp *Explanation) (float64, bool) {
+	// postings indexed before field-aware indexing only have a combined TF
+	if len(posting.Fields) == 0 {
+		exp.Set(posting.TF, "tf, term frequency of a posting indexed without fields")
+		return posting.TF, field == ""
+	}
+
+	// field scoped term, only that field counts
+	if field != "" {
+		fp, ok := posting.Fields[field]
+		if !ok || fp.Freq == 0 {
+			return 0, false
+		}
+		tf := float64(fp.Freq) / float64(max(fp.Length, 1))
+		exp.Set(tf, "tf, freq / length of the queried field")
+		exp.Add(float64(fp.Freq), "freq, occurrences in the field")
+		exp.Add(float64(fp.Length), "length, tokens in the field")
+		return tf, true
+	}
+
+	// weighted sum of per-field frequencies
+	var weighted, totalBoost float64
+	for _, f := range models.IndexedFields {
+		boost := boosts[f]
+		totalBoost += boost
+		if fp, ok := posting.Fields[f]; ok && fp.Freq > 0 {
+			boosted := boost * float64(fp.Freq) / float64(max(fp.Length, 1))
+			weighted += boosted
+			if exp.Enabled() {
+				fieldExp := exp.Add(boosted, f+", boost * freq / length")
+				fieldExp.Add(boost, "boost")
+				fieldExp.Add(float64(fp.Freq), "freq, occurrences in the field")
+				fieldExp.Add(float64(fp.Length), "length, tokens in the field")
+			}
+		}
+	}
+	if totalBoost == 0 {
+		return 0, false
+	}
+	exp.Set(weighted/totalBoost, "tf, sum of boosted field frequencies / total boost")
+	exp.Add(totalBoost, "total boost")
+	return weighted / totalBoost, true
+}
+
+/* BM25 */
+
+// Okapi BM25 over the whole document, or over a single field for field scoped terms
+type BM25 struct {
+	K1        float64
+	B         float64
+	AvgLength float64
+	Corpus    *models.CorpusStats
+}
+
+func (s *BM25) Name() string {
+	return MODEL_BM25
+}
+
+func (s *BM25) Score(qt parsing.QueryTerm, posting *models.IndexerPosting, stats TermStats) (float64, bool) {
+	return s.Explain(qt, posting, stats, nil)
+}
+
+func (s *BM25) Explain(qt parsing.QueryTerm, posting *models.IndexerPosting, stats TermStats, exp *Explanation) (float64, bool) {
+	idf := BM25IDF(stats)
+
+	var tf float64
+	switch {
+	case qt.Field != "":
+		fp, ok := posting.Fields[qt.Field]
+		if !ok || fp.Freq == 0 {
+			return 0, false
+		}
+		tf = s.saturate(float64(fp.Freq), float64(fp.Length), s.Corpus.AvgFieldLength(qt.Field), exp.Add(0, "tf"))
+		exp.Set(idf*tf, "bm25, idf * tf of the queried field")
+	case posting.Count == 0:
+		// postings indexed before raw counts were stored, assume a single occurrence in an average document
+		tf = s.saturate(1, s.AvgLength, s.AvgLength, exp.Add(0, "tf"))
+		exp.Set(idf*tf, "bm25, idf * tf of one occurrence in an average length document, the posting has no counts")
+	default:
+		tf = s.saturate(float64(posting.Count), float64(posting.Length), s.AvgLength, exp.Add(0, "tf"))
+		exp.Set(idf*tf, "bm25, idf * tf")
+	}
+	explainIDF(exp, idf, "idf, log(1 + (N - df + 0.5) / (df + 0.5))", stats)
+	return idf * tf, true
+}
+
+func (s *BM25) MaxScore(qt parsing.QueryTerm, bounds *TermBounds, stats TermStats) float64 {
+	idf := BM25IDF(stats)
+
+	if qt.Field != "" {
+		fb := bounds.Fields[qt.Field]
+		return idf * s.saturate(float64(fb.MaxFreq), float64(fb.MinLength), s.Corpus.AvgFieldLength(qt.Field), nil)
+	}
+
+	// saturation grows with frequency and shrinks with length
+	tf := s.saturate(float64(bounds.MaxCount), float64(bounds.MinLength), s.AvgLength, nil)
+	if bounds.Uncounted {
+		tf = math.Max(tf, s.saturate(1, s.AvgLength, s.AvgLength, nil))
+	}
+	return idf * tf
+}
+
+// Returns the saturated, length normalized term frequency tf * (k1 + 1) / (tf + k1 * norm), recorded into exp
+func (s *BM25) saturate(tf, length, avgLength float64, exp *Explanation) float64 {
+	if tf == 0 {
+		return 0
+	}
+	saturated := tf * (s.K1 + 1) / (tf + s.K1*lengthNorm(s.B, length, avgLength))
+	if exp.Enabled() {
+		exp.Set(saturated, "tf, freq * (k1 + 1) / (freq + k1 * (1 - b + b * length / avgLength))")
+		exp.Add(tf, "freq, occurrences of the term")
+		exp.Add(length, "length, tokens in the document or field")
+		exp.Add(avgLength, "avgLength, average tokens in the index")
+		exp.Add(s.K1, "k1")
+		exp.Add(s.B, "b")
+	}
+	return saturated
+}
+
+// Returns the BM25 length normalization 1 - b + b * length / avgLength, 1 if the average length is unknown
+func lengthNorm(b, length, avgLength float64) float64 {
+	if avgLength <= 0 {
+		return 1
+	}
+	return 1 - b + b*length/avgLength
+}
+
+// Returns the BM25 idf, log(1 + (N - df + 0.5) / (df + 0.5)), which stays positive for common terms
+func BM25IDF(stats TermStats) float64 {
+	n, df := float64(stats.N), float64(stats.DF)
+	return math.Log(1 + (n-df+0.5)/(df+0.5))
+}
+
+/* BM25F */
+
+// BM25F, per-field frequencies are length normalized and boosted into one pseudo-frequency before saturation
+type BM25F struct {
+	K1     float64
+	B      float64
+	Boosts map[string]float64
+	Corpus *models.CorpusStats
+}
+
+func (s *BM25F) Name() string {
+	return MODEL_BM25F
+}
+
+func (s *BM25F) Score(qt parsing.QueryTerm, posting *models.IndexerPosting, stats TermStats) (float64, bool) {
+	return s.Explain(qt, posting, stats, nil)
+}
+
+func (s *BM25F) Explain(qt parsing.QueryTerm, posting *models.IndexerPosting, stats TermStats, exp *Explanation) (float64, bool) {
+	// without per-field postings BM25F reduces to BM25
+	if len(posting.Fields) == 0 {
+		return s.bm25().Explain(qt, posting, stats, exp)
+	}
+
+	// boosted, length normalized pseudo-frequency
+	tfExp := exp.Add(0, "tf")
+	tf := 0.0
+	for _, field := range s.fields(qt) {
+		fp, ok := posting.Fields[field]
+		if !ok || fp.Freq == 0 {
+			continue
+		}
+		boost, avgLength := s.boost(field, qt.Field), s.Corpus.AvgFieldLength(field)
+		fieldTF := boost * float64(fp.Freq) / lengthNorm(s.B, float64(fp.Length), avgLength)
+		tf += fieldTF
+		if tfExp.Enabled() {
+			fieldExp := tfExp.Add(fieldTF, field+", boost * freq / (1 - b + b * length / avgLength)")
+			fieldExp.Add(boost, "boost")
+			fieldExp.Add(float64(fp.Freq), "freq, occurrences in the field")
+			fieldExp.Add(float64(fp.Length), "length, tokens in the field")
+			fieldExp.Add(avgLength, "avgLength, average tokens in the field")
+		}
+	}
+	if tf == 0 {
+		return 0, false
+	}
+	tfExp.Set(tf, "tf, boosted length normalized frequencies summed over fields")
+
+	idf := BM25IDF(stats)
+	explainIDF(exp, idf, "idf, log(1 + (N - df + 0.5) / (df + 0.5))", stats)
+	exp.Add(s.K1, "k1")
+	exp.Add(s.B, "b")
+
+	score := idf * tf * (s.K1 + 1) / (tf + s.K1)
+	exp.Set(score, "bm25f, idf * tf * (k1 + 1) / (tf + k1)")
+	return score, true
+}
+
+func (s *BM25F) MaxScore(qt parsing.QueryTerm, bounds *TermBounds, stats TermStats) float64 {
+	// pseudo-frequency from each field's largest frequency and shortest length
+	tf := 0.0
+	for _, field := range s.fields(qt) {
+		fb := bounds.Fields[field]
+		if fb.MaxFreq == 0 {
+			continue
+		}
+		tf += s.boost(field, qt.Field) * float64(fb.MaxFreq) / lengthNorm(s.B, float64(fb.MinLength), s.Corpus.AvgFieldLength(field))
+	}
+	score := BM25IDF(stats) * tf * (s.K1 + 1) / (tf + s.K1)
+
+	// postings without per-field postings are scored by BM25
+	if bounds.Unfielded {
+		score = math.Max(score, s.bm25().MaxScore(qt, bounds, stats))
+	}
+	return score
+}
+
+// Returns the fields a query term is scored on
+func (s *BM25F) fields(qt parsing.QueryTerm) []string {
+	if qt.Field != "" {
+		return []string{qt.Field}
+	}
+	return models.IndexedFields
+}
+
+// Returns the BM25 scorer used for postings without per-field postings
+func (s *BM25F) bm25() *BM25 {
+	return &BM25{K1: s.K1, B: s.B, AvgLength: s.Corpus.AvgLength(), Corpus: s.Corpus}
+}
+
+// Returns the boost of a field, field scoped terms are not boosted
+func (s *BM25F) boost(field, scope string) float64 {
+	if scope != "" {
+		return 1
+	}
+	return s.Boosts[field]
+}
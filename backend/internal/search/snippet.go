@@ -0,0 +1,338 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+// Tokens shown in each fragment of a snippet
+const SNIPPET_WINDOW = 24
+
+// Most fragments in a snippet, fragments after the first are only added if they show query terms the others do not
+const MAX_SNIPPET_FRAGMENTS = 2
+
+// Joins the fragments of a snippet
+const SNIPPET_SEPARATOR = " ... "
+
+// Highlighted span of a snippet's text, offsets are in UTF-16 code units so they slice JavaScript strings directly
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Plain text of a page's best fragments and the query terms highlighted in it
+type Snippet struct {
+	Text       string
+	Highlights []Highlight
+
+	spans [][2]int // highlights in bytes of Text
+}
+
+// Returns the snippet as HTML, the text escaped and highlights wrapped in <strong>
+func (s *Snippet) HTML() string {
+	var sb strings.Builder
+	last := 0
+	for _, span := range s.spans {
+		sb.WriteString(html.EscapeString(s.Text[last:span[0]]))
+		sb.WriteString("<strong>")
+		sb.WriteString(html.EscapeString(s.Text[span[0]:span[1]]))
+		sb.WriteString("</strong>")
+		last = span[1]
+	}
+	sb.WriteString(html.EscapeString(s.Text[last:]))
+	return sb.String()
+}
+
+// An occurrence of a query term
+type hit struct {
+	pos  int
+	term int
+}
+
+// A range of token positions shown in a snippet, end exclusive
+type window struct {
+	start, end int
+}
+
+/*
+Builds a snippet of content showing as many distinct query terms as possible
+
+positions holds the body token positions of each query term, windows of SNIPPET_WINDOW tokens covering the most distinct
+terms are picked from them without tokenizing the page. Content is only scanned up to the end of the last fragment.
+Every query term and its stemmed variants are highlighted, not only the positions the window was picked from.
+*/
+func MakeSnippet(content string, positions map[string][]int, terms []string) Snippet {
+	windows := bestWindows(positions, SNIPPET_WINDOW, MAX_SNIPPET_FRAGMENTS)
+	if len(windows) == 0 {
+		// no body positions, e.g. the terms only matched the title, show the start of the page
+		windows = []window{{0, SNIPPET_WINDOW}}
+	}
+	return renderSnippet(content, windows, newHighlighter(terms))
+}
+
+// Picks up to n non-overlapping windows of size tokens, each covering the most query terms not already shown,
+// ties broken by the number of distinct terms and then of occurrences
+// Returned windows are ordered by position
+func bestWindows(positions map[string][]int, size, n int) []window {
+	terms := make([]string, 0, len(positions))
+	for term := range positions {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	var hits []hit
+	for i, term := range terms {
+		for _, pos := range positions[term] {
+			hits = append(hits, hit{pos, i})
+		}
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].pos < hits[j].pos })
+
+	covered := make([]bool, len(terms))
+	var windows []window
+
+	for len(windows) < n {
+		bestNew, bestDistinct, bestCount := -1, 0, 0
+		var best window
+
+		for l := range hits {
+			if overlaps(windows, hits[l].pos) {
+				continue
+			}
+
+			// distinct and not yet shown terms starting at hits[l]
+			seen := make(map[int]bool)
+			newTerms, count, last := 0, 0, hits[l].pos
+			for r := l; r < len(hits) && hits[r].pos < hits[l].pos+size; r++ {
+				if overlaps(windows, hits[r].pos) {
+					break
+				}
+				if !seen[hits[r].term] {
+					seen[hits[r].term] = true
+					if !covered[hits[r].term] {
+						newTerms++
+					}
+				}
+				count++
+				last = hits[r].pos
+			}
+
+			if newTerms > bestNew ||
+				(newTerms == bestNew && len(seen) > bestDistinct) ||
+				(newTerms == bestNew && len(seen) == bestDistinct && count > bestCount) {
+				bestNew, bestDistinct, bestCount = newTerms, len(seen), count
+				// center the hits in the window
+				start := max(0, hits[l].pos-(size-(last-hits[l].pos+1))/2)
+				best = window{start, start + size}
+			}
+		}
+
+		// later fragments must show something new
+		if bestNew < 0 || (bestNew == 0 && len(windows) > 0) {
+			break
+		}
+
+		best = clampWindow(best, windows)
+		if best.start >= best.end {
+			break
+		}
+		windows = append(windows, best)
+		for _, h := range hits {
+			if h.pos >= best.start && h.pos < best.end {
+				covered[h.term] = true
+			}
+		}
+	}
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i].start < windows[j].start })
+
+	// adjacent windows read as one fragment
+	merged := windows[:1]
+	for _, w := range windows[1:] {
+		if last := &merged[len(merged)-1]; w.start <= last.end {
+			last.end = max(last.end, w.end)
+		} else {
+			merged = append(merged, w)
+		}
+	}
+	return merged
+}
+
+// Returns true if pos is inside one of the windows
+func overlaps(windows []window, pos int) bool {
+	for _, w := range windows {
+		if pos >= w.start && pos < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+// Shrinks a window so it does not overlap already picked windows
+func clampWindow(w window, windows []window) window {
+	for _, other := range windows {
+		if other.start < w.end && w.start < other.end {
+			if other.start <= w.start {
+				w.start = other.end
+			} else {
+				w.end = other.start
+			}
+		}
+	}
+	return w
+}
+
+// Returns the whole content as a snippet with every query term highlighted, whitespace is kept as is
+func HighlightAll(content string, terms []string) Snippet {
+	hl := newHighlighter(terms)
+	snippet := Snippet{Text: content}
+
+	offset, units := 0, 0
+	for {
+		start, end, ok := nextToken(content, offset)
+		if !ok {
+			break
+		}
+		units += utf16Len(content[offset:start])
+		word := content[start:end]
+		if hl.matches(word) {
+			snippet.spans = append(snippet.spans, [2]int{start, end})
+			snippet.Highlights = append(snippet.Highlights, Highlight{units, units + utf16Len(word)})
+		}
+		units += utf16Len(word)
+		offset = end
+	}
+	return snippet
+}
+
+// Renders the tokens of content inside windows, collapsing whitespace and highlighting query terms
+func renderSnippet(content string, windows []window, hl *highlighter) Snippet {
+	var snippet Snippet
+	var sb strings.Builder
+	units := 0 // UTF-16 length of sb
+
+	write := func(s string) {
+		sb.WriteString(s)
+		units += utf16Len(s)
+	}
+
+	w, token, offset := 0, 0, 0
+	prevEnd := -1 // byte end of the previous token written in the current window
+	for w < len(windows) {
+		start, end, ok := nextToken(content, offset)
+		if !ok {
+			break
+		}
+		offset = end
+
+		// past the current window, the page continues after it
+		if token >= windows[w].end {
+			w++
+			prevEnd = -1
+			if w == len(windows) {
+				write(" ...")
+				break
+			}
+			write(SNIPPET_SEPARATOR)
+		}
+
+		if token >= windows[w].start {
+			if prevEnd != -1 {
+				write(collapseSpace(content[prevEnd:start]))
+			} else if token > 0 && sb.Len() == 0 {
+				write("... ")
+			}
+
+			word := content[start:end]
+			if hl.matches(word) {
+				snippet.spans = append(snippet.spans, [2]int{sb.Len(), sb.Len() + len(word)})
+				snippet.Highlights = append(snippet.Highlights, Highlight{units, units + utf16Len(word)})
+			}
+			write(word)
+			prevEnd = end
+		}
+		token++
+	}
+
+	snippet.Text = sb.String()
+	return snippet
+}
+
+// Returns the byte range of the first token at or after offset, tokens are runs of letters and digits as in parsing
+func nextToken(content string, offset int) (int, int, bool) {
+	start := -1
+	for i, r := range content[offset:] {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if start == -1 && isWord {
+			start = offset + i
+		} else if start != -1 && !isWord {
+			return start, offset + i, true
+		}
+	}
+	if start == -1 {
+		return 0, 0, false
+	}
+	return start, len(content), true
+}
+
+// Replaces runs of whitespace with a single space
+func collapseSpace(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+	if space {
+		sb.WriteByte(' ')
+	}
+	return sb.String()
+}
+
+// Returns the length of s in UTF-16 code units
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// Matches words against query terms and their stems
+type highlighter struct {
+	terms map[string]bool
+	stems map[string]bool
+}
+
+func newHighlighter(terms []string) *highlighter {
+	hl := &highlighter{terms: make(map[string]bool), stems: make(map[string]bool)}
+	for _, term := range terms {
+		hl.terms[term] = true
+		hl.stems[parsing.Stem(term)] = true
+	}
+	return hl
+}
+
+// Returns true if the word is a query term or shares its stem
+func (hl *highlighter) matches(word string) bool {
+	lower := strings.ToLower(word)
+	return hl.terms[lower] || hl.stems[parsing.Stem(lower)]
+}
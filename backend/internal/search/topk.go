@@ -0,0 +1,271 @@
+package search
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+)
+
+// Number of matching documents counted exactly before pruning starts, past it the total is a lower bound
+const TOTAL_HITS_THRESHOLD = 1000
+
+// A posting list traversed for scoring, with an upper bound of the term's text score
+type ScoringList struct {
+	List     *PostingList
+	MaxScore float64
+}
+
+// Top-k retrieval over doc id ordered posting lists
+type TopKQuery struct {
+	K int
+
+	// posting lists of the terms that can make a document match, at least one must contain a document for it to match
+	Scoring []ScoringList
+	// posting lists of every fetched term, looked up to collect a candidate's postings
+	Lists map[string]*PostingList
+
+	// upper bound of a document's score is TextWeight * (sum of MaxScore of its terms) + Prior
+	TextWeight float64
+	// score independent of the terms, e.g. weighted PageRank, from any of the document's postings
+	Prior    func(posting *models.IndexerPosting) float64
+	MaxPrior float64
+
+	// returns the score of a document from its postings, false if it does not match the query
+	Evaluate func(docID string, doc DocPostings) (float64, bool)
+
+	// matching documents counted exactly before pruning starts, TOTAL_HITS_THRESHOLD if 0
+	TotalHitsThreshold int
+}
+
+// A document and its score
+type ScoredDoc struct {
+	DocID string
+	Score float64
+	Doc   DocPostings
+}
+
+// Ranked documents of a top-k retrieval
+type TopKResult struct {
+	Docs  []ScoredDoc // best first
+	Total int         // matching documents, a lower bound unless Exact
+	Exact bool
+
+	Evaluated int // documents fully scored
+}
+
+// Returns true if a ranks before b, higher scores first and ties broken by doc id
+func Better(a, b *ScoredDoc) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.DocID < b.DocID
+}
+
+// Sorts documents best first
+func SortScored(docs []ScoredDoc) {
+	sort.Slice(docs, func(i, j int) bool {
+		return Better(&docs[i], &docs[j])
+	})
+}
+
+/*
+Returns the best K documents using WAND (weak AND) dynamic pruning
+
+Cursors over the scoring lists are kept sorted by their current doc id. The pivot is the first cursor at which
+the summed score bounds of the cursors so far could beat the worst document in the heap, any document before
+the pivot's cannot and is skipped without being scored. Candidates are checked again against the bounds of the
+terms they actually contain and their own prior before being evaluated.
+*/
+func TopK(q *TopKQuery) TopKResult {
+	threshold := q.TotalHitsThreshold
+	if threshold == 0 {
+		threshold = TOTAL_HITS_THRESHOLD
+	}
+
+	top := &scoredHeap{}
+	result := TopKResult{Exact: true}
+
+	// score a document must beat to enter the heap, pruning starts once enough matches are counted
+	minScore := func() float64 {
+		if q.K <= 0 || top.Len() < q.K || result.Total < threshold {
+			return math.Inf(-1)
+		}
+		return (*top)[0].Score
+	}
+
+	cursors := make([]*cursor, 0, len(q.Scoring))
+	for _, sl := range q.Scoring {
+		if len(sl.List.Postings) > 0 {
+			cursors = append(cursors, &cursor{list: sl.List, max: sl.MaxScore})
+		}
+	}
+	cursors = sortCursors(cursors)
+
+	for len(cursors) > 0 {
+		theta := minScore()
+
+		// find the pivot, the first cursor whose accumulated bound could beat theta
+		pivot, acc := -1, 0.0
+		for i, c := range cursors {
+			acc += c.max
+			if q.TextWeight*acc+q.MaxPrior >= theta {
+				pivot = i
+				break
+			}
+		}
+		// no remaining document can enter the heap
+		if pivot == -1 {
+			result.Exact = false
+			break
+		}
+		pivotDoc := cursors[pivot].docID()
+
+		if cursors[0].docID() != pivotDoc {
+			// skip cursors before the pivot to the pivot's document
+			for _, c := range cursors[:pivot] {
+				c.seek(pivotDoc)
+			}
+			result.Exact = false
+			cursors = sortCursors(cursors)
+			continue
+		}
+
+		// every cursor on the pivot's document, its bound with only the terms it contains
+		bound, at := 0.0, 0
+		for at < len(cursors) && cursors[at].docID() == pivotDoc {
+			bound += cursors[at].max
+			at++
+		}
+		candidate := cursors[0].posting()
+
+		if q.TextWeight*bound+q.Prior(candidate) >= theta {
+			doc := q.collect(pivotDoc)
+			result.Evaluated++
+			if score, ok := q.Evaluate(pivotDoc, doc); ok {
+				result.Total++
+				top.offer(ScoredDoc{DocID: pivotDoc, Score: score, Doc: doc}, q.K)
+			}
+		} else {
+			result.Exact = false
+		}
+
+		for _, c := range cursors[:at] {
+			c.next()
+		}
+		cursors = sortCursors(cursors)
+	}
+
+	// pop worst first into a best first list
+	result.Docs = make([]ScoredDoc, top.Len())
+	for i := len(result.Docs) - 1; i >= 0; i-- {
+		result.Docs[i] = heap.Pop(top).(ScoredDoc)
+	}
+	return result
+}
+
+// Returns the postings of every fetched term that contains the document
+func (q *TopKQuery) collect(docID string) DocPostings {
+	doc := make(DocPostings)
+	for term, list := range q.Lists {
+		if posting := list.Find(docID); posting != nil {
+			doc[term] = posting
+		}
+	}
+	return doc
+}
+
+// Position in a posting list
+type cursor struct {
+	list *PostingList
+	pos  int
+	max  float64
+}
+
+func (c *cursor) done() bool {
+	return c.pos >= len(c.list.Postings)
+}
+
+func (c *cursor) docID() string {
+	return c.list.Postings[c.pos].DocID
+}
+
+func (c *cursor) posting() *models.IndexerPosting {
+	return &c.list.Postings[c.pos]
+}
+
+func (c *cursor) next() {
+	c.pos++
+}
+
+// Advances to the first posting with a doc id >= docID
+func (c *cursor) seek(docID string) {
+	c.pos = c.list.seek(c.pos, docID)
+}
+
+// Drops exhausted cursors and sorts the rest by current doc id
+func sortCursors(cursors []*cursor) []*cursor {
+	live := cursors[:0]
+	for _, c := range cursors {
+		if !c.done() {
+			live = append(live, c)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		return live[i].docID() < live[j].docID()
+	})
+	return live
+}
+
+// Min-heap of scored documents, the worst document on top
+type scoredHeap []ScoredDoc
+
+func (h scoredHeap) Len() int           { return len(h) }
+func (h scoredHeap) Less(i, j int) bool { return Better(&h[j], &h[i]) }
+func (h scoredHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *scoredHeap) Push(x any) {
+	*h = append(*h, x.(ScoredDoc))
+}
+
+func (h *scoredHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Adds a document if the heap holds fewer than k documents or it beats the worst one
+func (h *scoredHeap) offer(doc ScoredDoc, k int) {
+	if k <= 0 {
+		return
+	}
+	if h.Len() < k {
+		heap.Push(h, doc)
+		return
+	}
+	if Better(&doc, &(*h)[0]) {
+		(*h)[0] = doc
+		heap.Fix(h, 0)
+	}
+}
+
+// Returns the best k documents by evaluating every document, the baseline TopK is checked against
+func Exhaustive(entries []models.TermEntry, k int, evaluate func(docID string, doc DocPostings) (float64, bool)) TopKResult {
+	docs := GroupByDoc(entries)
+
+	scored := make([]ScoredDoc, 0, len(docs))
+	for docID, doc := range docs {
+		if score, ok := evaluate(docID, doc); ok {
+			scored = append(scored, ScoredDoc{DocID: docID, Score: score, Doc: doc})
+		}
+	}
+	SortScored(scored)
+
+	total := len(scored)
+	if k < total {
+		scored = scored[:k]
+	}
+	return TopKResult{Docs: scored, Total: total, Exact: true, Evaluated: len(docs)}
+}
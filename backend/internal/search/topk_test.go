@@ -0,0 +1,229 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+const EQUIVALENCE_DOCS = 500
+
+// Builds a random index of the terms, each document containing a term with its probability
+// A few postings are indexed without fields or counts, as before field-aware indexing
+func randomIndex(rng *rand.Rand, probabilities map[string]float64) ([]models.TermEntry, *models.CorpusStats) {
+	corpus := &models.CorpusStats{TotalPages: EQUIVALENCE_DOCS, FieldLengths: make(map[string]int64)}
+	lengths := make([]map[string]int, EQUIVALENCE_DOCS)
+	for d := range lengths {
+		lengths[d] = map[string]int{
+			models.FIELD_TITLE:    2 + rng.Intn(10),
+			models.FIELD_URL:      2 + rng.Intn(6),
+			models.FIELD_HEADINGS: rng.Intn(40),
+			models.FIELD_BODY:     20 + rng.Intn(2000),
+		}
+		for field, length := range lengths[d] {
+			corpus.FieldLengths[field] += int64(length)
+			corpus.TotalLength += int64(length)
+		}
+	}
+
+	entries := make([]models.TermEntry, 0, len(probabilities))
+	for term, p := range probabilities {
+		entry := models.TermEntry{Term: term}
+		for d := range lengths {
+			if rng.Float64() >= p {
+				continue
+			}
+			posting := models.IndexerPosting{DocID: fmt.Sprintf("doc%04d", d)}
+			if rng.Float64() < 0.05 {
+				posting.TF = rng.Float64() * 0.1
+			} else {
+				posting.Fields = make(models.FieldPostings)
+				for field, length := range lengths[d] {
+					freq := rng.Intn(length/4 + 2)
+					if freq == 0 || length == 0 {
+						continue
+					}
+					posting.Fields[field] = models.FieldPosting{Freq: freq, Length: length}
+					posting.Count += freq
+				}
+				if posting.Count == 0 {
+					posting.Fields[models.FIELD_BODY] = models.FieldPosting{Freq: 1, Length: lengths[d][models.FIELD_BODY]}
+					posting.Count = 1
+				}
+				for _, length := range lengths[d] {
+					posting.Length += length
+				}
+				posting.TF = float64(posting.Count) / float64(posting.Length)
+			}
+			entry.Postings = append(entry.Postings, posting)
+		}
+		// postings are not stored in doc id order
+		rng.Shuffle(len(entry.Postings), func(i, j int) {
+			entry.Postings[i], entry.Postings[j] = entry.Postings[j], entry.Postings[i]
+		})
+		entry.DF = len(entry.Postings)
+		entries = append(entries, entry)
+	}
+	return entries, corpus
+}
+
+// Returns a copy of the entries, posting lists are sorted and deduplicated in place
+func copyEntries(entries []models.TermEntry) []models.TermEntry {
+	copied := make([]models.TermEntry, len(entries))
+	for i, entry := range entries {
+		copied[i] = entry
+		copied[i].Postings = append([]models.IndexerPosting(nil), entry.Postings...)
+	}
+	return copied
+}
+
+// Checks that WAND returns the same documents and scores as evaluating every document
+func TestTopKMatchesExhaustive(t *testing.T) {
+	queryTerms := []parsing.QueryTerm{
+		{Term: "common"},
+		{Term: "rare"},
+		{Term: "middle", Expansion: "common"},
+		{Field: models.FIELD_TITLE, Term: "titled"},
+	}
+	probabilities := map[string]float64{
+		"common": 0.6,
+		"rare":   0.03,
+		"middle": 0.2,
+		"titled": 0.1,
+		"other":  0.3, // fetched but not queried
+	}
+
+	for _, model := range []string{MODEL_TFIDF, MODEL_BM25, MODEL_BM25F} {
+		for seed := int64(1); seed <= 5; seed++ {
+			rng := rand.New(rand.NewSource(seed))
+			entries, corpus := randomIndex(rng, probabilities)
+
+			cfg := DefaultRankingConfig()
+			cfg.Model = model
+			scorer, err := NewScorer(cfg, corpus)
+			if err != nil {
+				t.Fatalf("NewScorer(%s): %v", model, err)
+			}
+
+			termStats := make(map[string]TermStats, len(entries))
+			for _, entry := range entries {
+				termStats[entry.Term] = TermStats{DF: entry.DF, N: corpus.TotalPages}
+			}
+			ranks := make(map[string]float64, EQUIVALENCE_DOCS)
+			maxRank := 0.0
+			for d := 0; d < EQUIVALENCE_DOCS; d++ {
+				rank := rng.ExpFloat64() * 0.01
+				ranks[fmt.Sprintf("doc%04d", d)] = rank
+				maxRank = math.Max(maxRank, rank)
+			}
+
+			evaluate := func(docID string, doc DocPostings) (float64, bool) {
+				text, matched := 0.0, false
+				for _, qt := range queryTerms {
+					posting, ok := doc[qt.Term]
+					if !ok {
+						continue
+					}
+					if score, ok := scorer.Score(qt, posting, termStats[qt.Term]); ok {
+						text += cfg.TermWeight(qt) * score
+						matched = true
+					}
+				}
+				return cfg.Blend(text, ranks[docID]), matched
+			}
+
+			lists := NewPostingLists(copyEntries(entries))
+			var scoring []ScoringList
+			for _, qt := range queryTerms {
+				list := lists[qt.Term]
+				bound := cfg.TermWeight(qt) * scorer.MaxScore(qt, &list.Bounds, termStats[qt.Term])
+				scoring = append(scoring, ScoringList{List: list, MaxScore: bound})
+			}
+
+			for _, k := range []int{1, 3, 10, 50} {
+				name := fmt.Sprintf("%s/seed=%d/k=%d", model, seed, k)
+				want := Exhaustive(copyEntries(entries), k, evaluate)
+				got := TopK(&TopKQuery{
+					K:          k,
+					Scoring:    scoring,
+					Lists:      lists,
+					TextWeight: cfg.Alpha,
+					Prior: func(posting *models.IndexerPosting) float64 {
+						return (1 - cfg.Alpha) * ranks[posting.DocID]
+					},
+					MaxPrior:           (1 - cfg.Alpha) * maxRank,
+					Evaluate:           evaluate,
+					TotalHitsThreshold: 1,
+				})
+
+				if len(got.Docs) != len(want.Docs) {
+					t.Fatalf("%s: got %d documents, want %d", name, len(got.Docs), len(want.Docs))
+				}
+				for i := range want.Docs {
+					if got.Docs[i].DocID != want.Docs[i].DocID || math.Abs(got.Docs[i].Score-want.Docs[i].Score) > 1e-12 {
+						t.Fatalf("%s: rank %d is %s (%g), want %s (%g)", name, i+1,
+							got.Docs[i].DocID, got.Docs[i].Score, want.Docs[i].DocID, want.Docs[i].Score)
+					}
+				}
+				if got.Total > want.Total || (got.Exact && got.Total != want.Total) {
+					t.Errorf("%s: total %d (exact %v), want %d", name, got.Total, got.Exact, want.Total)
+				}
+				if got.Evaluated > want.Evaluated {
+					t.Errorf("%s: evaluated %d documents, exhaustive evaluated %d", name, got.Evaluated, want.Evaluated)
+				}
+			}
+		}
+	}
+}
+
+// Checks that with pruning disabled by the hit threshold every matching document is counted
+func TestTopKExactTotal(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	entries, corpus := randomIndex(rng, map[string]float64{"common": 0.5, "rare": 0.05})
+
+	scorer, err := NewScorer(DefaultRankingConfig(), corpus)
+	if err != nil {
+		t.Fatalf("NewScorer: %v", err)
+	}
+	queryTerms := []parsing.QueryTerm{{Term: "common"}, {Term: "rare"}}
+	termStats := make(map[string]TermStats, len(entries))
+	for _, entry := range entries {
+		termStats[entry.Term] = TermStats{DF: entry.DF, N: corpus.TotalPages}
+	}
+	evaluate := func(docID string, doc DocPostings) (float64, bool) {
+		text, matched := 0.0, false
+		for _, qt := range queryTerms {
+			if posting, ok := doc[qt.Term]; ok {
+				score, ok := scorer.Score(qt, posting, termStats[qt.Term])
+				text += score
+				matched = matched || ok
+			}
+		}
+		return text, matched
+	}
+
+	lists := NewPostingLists(copyEntries(entries))
+	var scoring []ScoringList
+	for _, qt := range queryTerms {
+		list := lists[qt.Term]
+		scoring = append(scoring, ScoringList{List: list, MaxScore: scorer.MaxScore(qt, &list.Bounds, termStats[qt.Term])})
+	}
+
+	want := Exhaustive(copyEntries(entries), 10, evaluate)
+	got := TopK(&TopKQuery{
+		K:                  10,
+		Scoring:            scoring,
+		Lists:              lists,
+		TextWeight:         1,
+		Prior:              func(*models.IndexerPosting) float64 { return 0 },
+		Evaluate:           evaluate,
+		TotalHitsThreshold: EQUIVALENCE_DOCS + 1,
+	})
+	if !got.Exact || got.Total != want.Total {
+		t.Errorf("total %d (exact %v), want exactly %d", got.Total, got.Exact, want.Total)
+	}
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Capped collection holding recent result clicks
+const CLICK_COLLECTION = "clicks"
+
+// Collection holding the click-through of each (query, document), rebuilt by cmd/clicks
+const CLICK_STATS_COLLECTION = "click_stats"
+
+// Click stats written per bulk write
+const CLICK_STATS_BATCH = 1000
+
+// Inserts a result click
+func (db *Database) InsertClick(click *models.ClickEvent) error {
+	_, err := db.GetCollection(CLICK_COLLECTION).InsertOne(db.ctx, click)
+	return err
+}
+
+// Returns the URL of a crawled page by id, "" if there is no such page
+func (db *Database) FetchPageURL(idHex string, collectionname string) (string, error) {
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return "", nil
+	}
+	var page struct {
+		URL string `bson:"url"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"url": 1})
+	err = db.GetCollection(collectionname).FindOne(db.ctx, bson.M{"_id": id}, opts).Decode(&page)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return page.URL, err
+}
+
+// Counts how often each document was shown at each position for each query since a time
+// Results of auto-corrected searches are counted for the corrected query they were for
+func (db *Database) CountImpressions(since time.Time) ([]models.PositionCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"time": bson.M{"$gte": since}, "docs.0": bson.M{"$exists": true}}}},
+		{{Key: "$unwind", Value: bson.M{"path": "$docs", "includeArrayIndex": "index"}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"query":    bson.M{"$ifNull": bson.A{"$corrected", "$query"}},
+				"doc_id":   "$docs",
+				"position": bson.M{"$add": bson.A{"$offset", "$index", 1}},
+			},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	return db.aggregatePositionCounts(QUERY_LOG_COLLECTION, pipeline)
+}
+
+// Counts clicks on each document at each position for each query since a time
+// Repeated clicks by one client count once, so a single client cannot inflate a result
+func (db *Database) CountClicks(since time.Time) ([]models.PositionCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"time": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"query": "$query", "doc_id": "$doc_id", "position": "$position", "client": "$client"},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"query": "$_id.query", "doc_id": "$_id.doc_id", "position": "$_id.position"},
+			"count": bson.M{"$sum": 1},
+		}}},
+	}
+	return db.aggregatePositionCounts(CLICK_COLLECTION, pipeline)
+}
+
+// Runs a pipeline grouping by query, doc id and position and decodes its counts
+func (db *Database) aggregatePositionCounts(collectionname string, pipeline mongo.Pipeline) ([]models.PositionCount, error) {
+	cursor, err := db.GetCollection(collectionname).Aggregate(db.ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	var counts []models.PositionCount
+	for cursor.Next(db.ctx) {
+		var result struct {
+			ID    models.PositionCount `bson:"_id"`
+			Count int                  `bson:"count"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			continue
+		}
+		result.ID.Count = result.Count
+		counts = append(counts, result.ID)
+	}
+	return counts, cursor.Err()
+}
+
+// Replaces the stored click stats, stats of pairs no longer aggregated are removed
+func (db *Database) SaveClickStats(stats []models.ClickStats) error {
+	collection := db.GetCollection(CLICK_STATS_COLLECTION)
+	_, err := collection.Indexes().CreateOne(db.ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "query", Value: 1}, {Key: "doc_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	runStart := time.Now().UTC()
+	for start := 0; start < len(stats); start += CLICK_STATS_BATCH {
+		end := min(start+CLICK_STATS_BATCH, len(stats))
+		writes := make([]mongo.WriteModel, 0, end-start)
+		for i := start; i < end; i++ {
+			stats[i].UpdatedAt = runStart
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"query": stats[i].Query, "doc_id": stats[i].DocID}).
+				SetReplacement(stats[i]).
+				SetUpsert(true))
+		}
+		if _, err := collection.BulkWrite(db.ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+
+	_, err = collection.DeleteMany(db.ctx, bson.M{"updated_at": bson.M{"$lt": runStart}})
+	return err
+}
+
+// Returns the click-through score of each document with stats for a normalized query
+func (db *Database) FetchClickScores(query string) (map[string]float64, error) {
+	opts := options.Find().SetProjection(bson.M{"doc_id": 1, "score": 1, "_id": 0})
+	cursor, err := db.GetCollection(CLICK_STATS_COLLECTION).Find(db.ctx, bson.M{"query": query}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	scores := make(map[string]float64)
+	for cursor.Next(db.ctx) {
+		var stats models.ClickStats
+		if err := cursor.Decode(&stats); err != nil {
+			continue
+		}
+		scores[stats.DocID] = stats.Score
+	}
+	return scores, cursor.Err()
+}
+
+// Makes the index aggregating recent clicks uses
+func (db *Database) MakeClickIndexes() error {
+	_, err := db.GetCollection(CLICK_COLLECTION).Indexes().CreateOne(db.ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "time", Value: 1}},
+	})
+	return err
+}
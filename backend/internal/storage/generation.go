@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holding generation counters, one document per counter
+const GENERATION_COLLECTION = "generations"
+
+// Generation of everything search results are ranked from, bumped whenever the index, PageRank or click-through change
+// Search results cached under an older generation are stale
+const SEARCH_GENERATION = "search"
+
+// Returns the current value of a generation counter, 0 if never bumped
+func (db *Database) GetGeneration(name string) (int64, error) {
+	var result struct {
+		Value int64 `bson:"value"`
+	}
+	err := db.GetCollection(GENERATION_COLLECTION).FindOne(db.ctx, bson.M{"_id": name}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return result.Value, err
+}
+
+// Increments a generation counter and returns its new value
+func (db *Database) BumpGeneration(name string) (int64, error) {
+	var result struct {
+		Value int64 `bson:"value"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := db.GetCollection(GENERATION_COLLECTION).FindOneAndUpdate(db.ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"value": 1}},
+		opts,
+	).Decode(&result)
+	return result.Value, err
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holding alias documents, one per alias name
+const INDEX_ALIAS_COLLECTION = "index_aliases"
+
+// Collection holding build metadata of versioned index collections
+const INDEX_VERSION_COLLECTION = "index_versions"
+
+// Returns the collection an alias points to
+// An alias that was never swapped resolves to its own name, i.e. the unversioned collection
+func (db *Database) ResolveAlias(alias string) (string, error) {
+	var result models.IndexAlias
+	err := db.GetCollection(INDEX_ALIAS_COLLECTION).FindOne(db.ctx, bson.M{"_id": alias}).Decode(&result)
+	if err == mongo.ErrNoDocuments || (err == nil && result.Target == "") {
+		return alias, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.Target, nil
+}
+
+// Returns the alias document, or a document pointing at the unversioned collection if never swapped
+func (db *Database) GetAlias(alias string) (*models.IndexAlias, error) {
+	var result models.IndexAlias
+	err := db.GetCollection(INDEX_ALIAS_COLLECTION).FindOne(db.ctx, bson.M{"_id": alias}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return &models.IndexAlias{Name: alias, Target: alias}, nil
+	}
+	return &result, err
+}
+
+// Atomically points alias at target, remembering the current target for rollback
+// Fails if the alias was swapped concurrently
+func (db *Database) SwapAlias(alias, target string) (*models.IndexAlias, error) {
+	current, err := db.GetAlias(alias)
+	if err != nil {
+		return nil, err
+	}
+
+	// only match the alias if it still points where we read it pointing
+	filter := bson.M{"_id": alias}
+	if current.UpdatedAt.IsZero() {
+		filter["target"] = bson.M{"$exists": false}
+	} else {
+		filter["target"] = current.Target
+	}
+
+	update := bson.M{"$set": bson.M{
+		"target":     target,
+		"previous":   current.Target,
+		"updated_at": time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var result models.IndexAlias
+	err = db.GetCollection(INDEX_ALIAS_COLLECTION).FindOneAndUpdate(db.ctx, filter, update, opts).Decode(&result)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, fmt.Errorf("Alias %s was swapped concurrently, retry", alias)
+	}
+	return &result, err
+}
+
+// Points alias back at its previous target, the current target becomes the previous one
+// Fails if the previous target was dropped or is a version that did not finish building
+func (db *Database) RollbackAlias(alias string) (*models.IndexAlias, error) {
+	current, err := db.GetAlias(alias)
+	if err != nil {
+		return nil, err
+	}
+	if current.Previous == "" {
+		return nil, fmt.Errorf("Alias %s has no previous target to roll back to", alias)
+	}
+
+	exists, err := db.CollectionExists(current.Previous)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("Previous target %s of alias %s no longer exists", current.Previous, alias)
+	}
+	// the unversioned collection has no build metadata
+	version, err := db.GetIndexVersion(current.Previous)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if err == nil && version.Status != models.INDEX_STATUS_READY {
+		return nil, fmt.Errorf("Previous target %s of alias %s is %s, not %s", current.Previous, alias, version.Status, models.INDEX_STATUS_READY)
+	}
+	return db.SwapAlias(alias, current.Previous)
+}
+
+// Returns true if a collection exists in the database holding the aliases
+func (db *Database) CollectionExists(collectionname string) (bool, error) {
+	names, err := db.GetCollection(INDEX_ALIAS_COLLECTION).Database().ListCollectionNames(db.ctx, bson.M{"name": collectionname})
+	if err != nil {
+		return false, err
+	}
+	return len(names) > 0, nil
+}
+
+// Inserts or replaces build metadata for a versioned index
+func (db *Database) SaveIndexVersion(version *models.IndexVersion) error {
+	_, err := db.GetCollection(INDEX_VERSION_COLLECTION).ReplaceOne(
+		db.ctx,
+		bson.M{"_id": version.Collection},
+		version,
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
+
+// Returns build metadata of a versioned index, mongo.ErrNoDocuments if it has none
+func (db *Database) GetIndexVersion(collectionname string) (*models.IndexVersion, error) {
+	var version models.IndexVersion
+	err := db.GetCollection(INDEX_VERSION_COLLECTION).FindOne(db.ctx, bson.M{"_id": collectionname}).Decode(&version)
+	if err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// Returns build metadata for all versioned indexes, newest first
+func (db *Database) ListIndexVersions() ([]models.IndexVersion, error) {
+	opts := options.Find().SetSort(bson.M{"version": -1})
+	cursor, err := db.GetCollection(INDEX_VERSION_COLLECTION).Find(db.ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	var versions []models.IndexVersion
+	err = cursor.All(db.ctx, &versions)
+	return versions, err
+}
+
+// Drops a collection from the database
+func (db *Database) DropCollection(collectionname string) error {
+	collection := db.GetCollection(collectionname)
+	if collection == nil {
+		return fmt.Errorf("Collection name not found.")
+	}
+	return collection.Drop(db.ctx)
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Capped collection of terms modified by the indexer, oldest changes are dropped once full
+const INDEX_CHANGES_COLLECTION = "index_changes"
+
+// Size bounds of the index change log, ample for readers polling every few seconds
+const INDEX_CHANGES_SIZE_BYTES = 64 << 20
+const INDEX_CHANGES_MAX_DOCS = 100000
+
+// Creates the index change log if it does not exist, then adds it to the database
+func (db *Database) EnsureIndexChanges(dbname string) error {
+	return db.EnsureCappedCollection(dbname, INDEX_CHANGES_COLLECTION, INDEX_CHANGES_SIZE_BYTES, INDEX_CHANGES_MAX_DOCS)
+}
+
+// Records that terms of an index were modified
+func (db *Database) RecordIndexChange(index string, terms []string) error {
+	_, err := db.GetCollection(INDEX_CHANGES_COLLECTION).InsertOne(db.ctx, models.IndexChange{
+		Index: index,
+		Terms: terms,
+		Time:  time.Now(),
+	})
+	return err
+}
+
+// Returns the changes recorded at or after since, oldest first
+func (db *Database) FetchIndexChanges(since time.Time) ([]models.IndexChange, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := db.GetCollection(INDEX_CHANGES_COLLECTION).Find(db.ctx, bson.M{
+		"_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(since)},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	changes := []models.IndexChange{}
+	if err := cursor.All(db.ctx, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
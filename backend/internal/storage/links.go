@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"github.com/Jailior/open-search/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection of the link graph's pages, one document per crawled page with its numbers of links
+const LINKS_COLLECTION = "links"
+
+// Collection of the link graph's edges, one document per link between crawled pages
+const LINK_EDGES_COLLECTION = "link_edges"
+
+// Collection of link stats per host
+const HOST_LINKS_COLLECTION = "host_links"
+
+// Makes the indexes link graph lookups use, edges are listed from either end best PageRank first
+func (db *Database) MakeLinkIndexes() error {
+	_, err := db.GetCollection(LINKS_COLLECTION).Indexes().CreateOne(db.ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "url", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.GetCollection(LINK_EDGES_COLLECTION).Indexes().CreateMany(db.ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "from", Value: 1}, {Key: "to", Value: 1}}},
+		{Keys: bson.D{{Key: "to", Value: 1}, {Key: "from_rank", Value: -1}}},
+		{Keys: bson.D{{Key: "from", Value: 1}, {Key: "to_rank", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = db.GetCollection(HOST_LINKS_COLLECTION).Indexes().CreateOne(db.ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "host", Value: 1}},
+	})
+	return err
+}
+
+// Batch fetches the link graph nodes of urls, keyed by url
+func (db *Database) FetchLinkNodes(urls []string) (map[string]models.LinkNode, error) {
+	cursor, err := db.GetCollection(LINKS_COLLECTION).Find(db.ctx, bson.M{"url": bson.M{"$in": urls}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	var nodes []models.LinkNode
+	if err := cursor.All(db.ctx, &nodes); err != nil {
+		return nil, err
+	}
+	results := make(map[string]models.LinkNode, len(nodes))
+	for _, node := range nodes {
+		results[node.URL] = node
+	}
+	return results, nil
+}
+
+// Returns a page of the links to a page if inbound, else from it, highest PageRank of the other end first
+func (db *Database) FetchLinkEdges(url string, inbound bool, offset, limit int) ([]models.LinkEdge, error) {
+	filter, sort := bson.M{"from": url}, bson.D{{Key: "to_rank", Value: -1}, {Key: "to", Value: 1}}
+	if inbound {
+		filter, sort = bson.M{"to": url}, bson.D{{Key: "from_rank", Value: -1}, {Key: "from", Value: 1}}
+	}
+	opts := options.Find().SetSort(sort).SetSkip(int64(offset)).SetLimit(int64(limit))
+	cursor, err := db.GetCollection(LINK_EDGES_COLLECTION).Find(db.ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	edges := []models.LinkEdge{}
+	err = cursor.All(db.ctx, &edges)
+	return edges, err
+}
+
+// Returns the distinct pages linking to any of urls
+func (db *Database) FetchInlinkURLs(urls []string) ([]string, error) {
+	opts := options.Find().SetProjection(bson.M{"from": 1, "_id": 0})
+	cursor, err := db.GetCollection(LINK_EDGES_COLLECTION).Find(db.ctx, bson.M{"to": bson.M{"$in": urls}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	seen := make(map[string]bool)
+	var inlinks []string
+	for cursor.Next(db.ctx) {
+		var edge models.LinkEdge
+		if err := cursor.Decode(&edge); err != nil {
+			continue
+		}
+		if !seen[edge.From] {
+			seen[edge.From] = true
+			inlinks = append(inlinks, edge.From)
+		}
+	}
+	return inlinks, cursor.Err()
+}
+
+// Returns the link stats of a host, mongo.ErrNoDocuments if it has no crawled pages
+func (db *Database) FetchHostLinks(host string) (*models.HostLinks, error) {
+	var result models.HostLinks
+	err := db.GetCollection(HOST_LINKS_COLLECTION).FindOne(db.ctx, bson.M{"host": host}).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Capped collection holding recent searches, oldest searches are dropped once full
+const QUERY_LOG_COLLECTION = "query_log"
+
+// Searches sampled for latency percentiles when the server cannot compute them
+const LATENCY_SAMPLE_SIZE = 10000
+
+// Selects searches from the query log
+type QueryLogFilter struct {
+	Since       time.Time // zero for the whole log
+	ZeroResults bool      // only searches that found nothing
+	WithResults bool      // only searches that found something
+	FirstPage   bool      // only first pages, so paging through results counts a search once
+}
+
+// Creates a capped collection of at most sizeBytes and maxDocs if it does not exist, then adds it to the database
+func (db *Database) EnsureCappedCollection(dbname, collectionname string, sizeBytes, maxDocs int64) error {
+	opts := options.CreateCollection().SetCapped(true).SetSizeInBytes(sizeBytes).SetMaxDocuments(maxDocs)
+	err := db.client.Database(dbname).CreateCollection(db.ctx, collectionname, opts)
+
+	// NamespaceExists, created by an earlier run
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == 48) {
+		return err
+	}
+	db.AddCollection(dbname, collectionname)
+	return nil
+}
+
+// Makes the indexes analytics queries over the query log use
+func (db *Database) MakeQueryLogIndexes() error {
+	_, err := db.GetCollection(QUERY_LOG_COLLECTION).Indexes().CreateMany(db.ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "time", Value: 1}}},
+		{Keys: bson.D{{Key: "query", Value: 1}}},
+	})
+	return err
+}
+
+// Inserts searches into the query log
+func (db *Database) InsertQueryLogs(entries []models.QueryLogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(entries))
+	for i := range entries {
+		docs[i] = entries[i]
+	}
+	_, err := db.GetCollection(QUERY_LOG_COLLECTION).InsertMany(db.ctx, docs, options.InsertMany().SetOrdered(false))
+	return err
+}
+
+// Returns the limit most searched queries matching filter, most searched first
+func (db *Database) TopQueries(filter QueryLogFilter, limit int) ([]models.QueryCount, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter.match()}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$query",
+			"count":       bson.M{"$sum": 1},
+			"clients":     bson.M{"$addToSet": "$client"},
+			"avg_results": bson.M{"$avg": "$results"},
+			"last_seen":   bson.M{"$max": "$time"},
+		}}},
+		{{Key: "$set", Value: bson.M{"clients": bson.M{"$size": "$clients"}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	cursor, err := db.GetCollection(QUERY_LOG_COLLECTION).Aggregate(db.ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	counts := []models.QueryCount{}
+	if err := cursor.All(db.ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+/*
+Returns the number, mean, max and percentiles of the latency of searches matching filter, percentiles in percent
+
+Percentiles are approximated by Mongo's $percentile. Servers older than 7.0 reject it,
+then percentiles are computed from a random sample of LATENCY_SAMPLE_SIZE searches instead
+*/
+func (db *Database) QueryLatencyStats(filter QueryLogFilter, percentiles []float64) (*models.LatencyStats, error) {
+	p := make([]float64, len(percentiles))
+	for i := range percentiles {
+		p[i] = percentiles[i] / 100
+	}
+	group := bson.M{
+		"_id":      nil,
+		"searches": bson.M{"$sum": 1},
+		"mean_ms":  bson.M{"$avg": "$latency_ms"},
+		"max_ms":   bson.M{"$max": "$latency_ms"},
+	}
+
+	withPercentiles := bson.M{"percentiles": bson.M{"$percentile": bson.M{"input": "$latency_ms", "p": p, "method": "approximate"}}}
+	for key, value := range group {
+		withPercentiles[key] = value
+	}
+	stats, err := db.latencyStats(mongo.Pipeline{
+		{{Key: "$match", Value: filter.match()}},
+		{{Key: "$group", Value: withPercentiles}},
+	})
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return stats, err
+	}
+
+	// $percentile is not supported, count over every search and estimate percentiles from a sample
+	stats, err = db.latencyStats(mongo.Pipeline{
+		{{Key: "$match", Value: filter.match()}},
+		{{Key: "$group", Value: group}},
+	})
+	if err != nil || stats.Searches == 0 {
+		return stats, err
+	}
+	sample, err := db.latencyStats(mongo.Pipeline{
+		{{Key: "$match", Value: filter.match()}},
+		{{Key: "$sample", Value: bson.M{"size": LATENCY_SAMPLE_SIZE}}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "percentiles": bson.M{"$push": "$latency_ms"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	latencies := sample.Percentiles
+	sort.Float64s(latencies)
+	stats.Percentiles = make([]float64, len(percentiles))
+	for i := range percentiles {
+		stats.Percentiles[i] = nearestRank(latencies, percentiles[i])
+	}
+	stats.Sampled = stats.Searches > len(latencies)
+	return stats, nil
+}
+
+// Runs a latency aggregation grouping every search into one document, zero stats if no search matched
+func (db *Database) latencyStats(pipeline mongo.Pipeline) (*models.LatencyStats, error) {
+	cursor, err := db.GetCollection(QUERY_LOG_COLLECTION).Aggregate(db.ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	var stats models.LatencyStats
+	if cursor.Next(db.ctx) {
+		if err := cursor.Decode(&stats); err != nil {
+			return nil, err
+		}
+	}
+	return &stats, cursor.Err()
+}
+
+// Returns the p-th percentile of sorted values by the nearest rank method, 0 if there are none
+func nearestRank(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// Returns the Mongo filter selecting the searches
+func (f QueryLogFilter) match() bson.M {
+	match := bson.M{}
+	if !f.Since.IsZero() {
+		match["time"] = bson.M{"$gte": f.Since}
+	}
+	if f.ZeroResults {
+		match["results"] = 0
+	} else if f.WithResults {
+		match["results"] = bson.M{"$gt": 0}
+	}
+	if f.FirstPage {
+		match["offset"] = 0
+	}
+	return match
+}
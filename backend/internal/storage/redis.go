@@ -60,6 +60,14 @@ func (r *RedisClient) PushToStream(stream string, key string, value string) erro
 	return err
 }
 
+// Pushes a message with several fields to a stream
+func (r *RedisClient) PushValuesToStream(stream string, values map[string]interface{}) error {
+	return r.Client.XAdd(r.Ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: values,
+	}).Err()
+}
+
 // Reads a key value pair from a stream
 func (r *RedisClient) ReadStream(streamName string, group string, consumerName string) ([]redis.XMessage, error) {
 	readIDs := []string{"0", ">"}
@@ -142,3 +150,98 @@ func (r *RedisClient) SetHas(url, setName string) bool {
 	exists, _ := r.Client.SIsMember(r.Ctx, setName, url).Result()
 	return exists
 }
+
+// Returns the number of times each message has been delivered to consumerName
+// Delivery counts are read from the stream's pending entries list via XPENDING
+func (r *RedisClient) DeliveryCounts(streamName, group, consumerName string, messages []redis.XMessage) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(messages) == 0 {
+		return counts, nil
+	}
+
+	// messages are read in id order, so first and last bound the range
+	// other messages owned by the consumer may fall in the range, leave headroom for them
+	pending, err := r.Client.XPendingExt(r.Ctx, &redis.XPendingExtArgs{
+		Stream:   streamName,
+		Group:    group,
+		Start:    messages[0].ID,
+		End:      messages[len(messages)-1].ID,
+		Count:    int64(len(messages)) + 100,
+		Consumer: consumerName,
+	}).Result()
+	if err != nil {
+		return counts, fmt.Errorf("Redis XPending error: %w", err)
+	}
+
+	for _, p := range pending {
+		counts[p.ID] = p.RetryCount
+	}
+	return counts, nil
+}
+
+// Claims messages pending for longer than minIdle from any consumer in the group, i.e. from dead consumers
+// Returns the claimed messages, now owned by consumerName
+func (r *RedisClient) AutoClaim(streamName, group, consumerName string, minIdle time.Duration, count int64) ([]redis.XMessage, error) {
+	var claimed []redis.XMessage
+	start := "0-0"
+
+	// XAUTOCLAIM scans the pending entries list in pages, follow the cursor until exhausted
+	for {
+		messages, next, err := r.Client.XAutoClaim(r.Ctx, &redis.XAutoClaimArgs{
+			Stream:   streamName,
+			Group:    group,
+			Consumer: consumerName,
+			MinIdle:  minIdle,
+			Start:    start,
+			Count:    count,
+		}).Result()
+		if err != nil {
+			return claimed, fmt.Errorf("Redis XAutoClaim error: %w", err)
+		}
+		claimed = append(claimed, messages...)
+
+		if next == "0-0" || int64(len(claimed)) >= count {
+			return claimed, nil
+		}
+		start = next
+	}
+}
+
+// Moves a message to the dead-letter stream with the reason it failed, then acknowledges it on the source stream
+func (r *RedisClient) DeadLetter(streamName, group, deadLetterStream string, message redis.XMessage, reason string, deliveries int64) error {
+	values := make(map[string]interface{}, len(message.Values)+5)
+	for key, value := range message.Values {
+		values[key] = value
+	}
+	values["original_id"] = message.ID
+	values["source_stream"] = streamName
+	values["reason"] = reason
+	values["deliveries"] = deliveries
+	values["failed_at"] = time.Now().UTC().Format(time.RFC3339)
+
+	err := r.Client.XAdd(r.Ctx, &redis.XAddArgs{
+		Stream: deadLetterStream,
+		Values: values,
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("Failed to push message %s to dead-letter stream: %w", message.ID, err)
+	}
+
+	// only ack once the message is safely in the dead-letter stream
+	err = r.Client.XAck(r.Ctx, streamName, group, message.ID).Err()
+	if err != nil {
+		return fmt.Errorf("Failed to ACK dead-lettered message %s: %w", message.ID, err)
+	}
+	return nil
+}
+
+// Returns up to count messages with ids between start and end from a stream, oldest first
+// Use "-" and "+" for the whole stream
+func (r *RedisClient) RangeStream(streamName, start, end string, count int64) ([]redis.XMessage, error) {
+	return r.Client.XRangeN(r.Ctx, streamName, start, end, count).Result()
+}
+
+// Deletes messages with the given ids from a stream
+func (r *RedisClient) DeleteFromStream(streamName string, ids ...string) (int64, error) {
+	return r.Client.XDel(r.Ctx, streamName, ids...).Result()
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/Jailior/open-search/backend/internal/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection of query rewrite rules managed by admins
+const REWRITE_RULES_COLLECTION = "rewrite_rules"
+
+// Returns the rewrite rules in the order they were added, only enabled ones if enabledOnly
+func (db *Database) FetchRewriteRules(enabledOnly bool) ([]models.RewriteRule, error) {
+	filter := bson.M{}
+	if enabledOnly {
+		filter["enabled"] = true
+	}
+	cursor, err := db.GetCollection(REWRITE_RULES_COLLECTION).Find(db.ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	rules := []models.RewriteRule{}
+	err = cursor.All(db.ctx, &rules)
+	return rules, err
+}
+
+// Inserts a rewrite rule and returns its id
+func (db *Database) InsertRewriteRule(rule models.RewriteRule) (string, error) {
+	rule.ID = primitive.NilObjectID
+	rule.UpdatedAt = time.Now()
+	res, err := db.GetCollection(REWRITE_RULES_COLLECTION).InsertOne(db.ctx, rule)
+	if err != nil {
+		return "", err
+	}
+	return res.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// Enables or disables a rewrite rule, mongo.ErrNoDocuments if there is none with the id
+func (db *Database) SetRewriteRuleEnabled(idHex string, enabled bool) error {
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return err
+	}
+	res, err := db.GetCollection(REWRITE_RULES_COLLECTION).UpdateByID(db.ctx, id, bson.M{
+		"$set": bson.M{"enabled": enabled, "updated_at": time.Now()},
+	})
+	if err == nil && res.MatchedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return err
+}
+
+// Deletes a rewrite rule, mongo.ErrNoDocuments if there is none with the id
+func (db *Database) DeleteRewriteRule(idHex string) error {
+	id, err := primitive.ObjectIDFromHex(idHex)
+	if err != nil {
+		return err
+	}
+	res, err := db.GetCollection(REWRITE_RULES_COLLECTION).DeleteOne(db.ctx, bson.M{"_id": id})
+	if err == nil && res.DeletedCount == 0 {
+		err = mongo.ErrNoDocuments
+	}
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 
 	"github.com/Jailior/open-search/backend/internal/models"
 	"go.mongodb.org/mongo-driver/bson"
@@ -18,6 +19,7 @@ import (
 type Database struct {
 	client     *mongo.Client
 	collection map[string]*mongo.Collection
+	mu         sync.RWMutex // guards collection, collections may be added while serving
 	ctx        context.Context
 	Error      error
 }
@@ -63,11 +65,23 @@ func (db *Database) GetContext() *context.Context {
 
 // Adds collection with collection name to database, must be done before any functions called using this collection
 func (db *Database) AddCollection(dbname string, collectionname string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	db.collection[collectionname] = db.client.Database(dbname).Collection(collectionname)
 }
 
+// Adds collection to database only if it has not been added yet, safe to call on every request
+func (db *Database) EnsureCollection(dbname string, collectionname string) {
+	if db.GetCollection(collectionname) != nil {
+		return
+	}
+	db.AddCollection(dbname, collectionname)
+}
+
 // Returns a reference to a collection stored in Database
 func (db *Database) GetCollection(collectionname string) *mongo.Collection {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	return db.collection[collectionname]
 }
 
@@ -110,7 +124,7 @@ func (db *Database) FetchRawPage(idHex string, collectionname string) (*models.P
 	}
 
 	var result models.PageData
-	err = db.collection[collectionname].FindOne(db.ctx, bson.M{"_id": id}).Decode(&result)
+	err = db.GetCollection(collectionname).FindOne(db.ctx, bson.M{"_id": id}).Decode(&result)
 	return &result, err
 }
 
@@ -125,7 +139,7 @@ func (db *Database) FetchRawPageBatch(idHexes []string, collectionname string) (
 		objIDs = append(objIDs, id)
 	}
 
-	cursor, err := db.collection[collectionname].Find(db.ctx, bson.M{
+	cursor, err := db.GetCollection(collectionname).Find(db.ctx, bson.M{
 		"_id": bson.M{"$in": objIDs},
 	})
 	if err != nil {
@@ -148,7 +162,7 @@ func (db *Database) FetchRawPageBatch(idHexes []string, collectionname string) (
 // Gets postings for a term
 func (db *Database) FetchPostings(term string, collectionname string) (*models.TermEntry, error) {
 	var result models.TermEntry
-	err := db.collection[collectionname].FindOne(db.ctx, bson.M{"term": term}).Decode(&result)
+	err := db.GetCollection(collectionname).FindOne(db.ctx, bson.M{"term": term}).Decode(&result)
 	if err != nil || result.DF == 0 {
 		return nil, err
 	}
@@ -157,7 +171,7 @@ func (db *Database) FetchPostings(term string, collectionname string) (*models.T
 
 // Batch fetches all postings for a list of terms
 func (db *Database) FetchPostingsBatch(terms []string, collectionname string) ([]models.TermEntry, error) {
-	cursor, err := db.collection[collectionname].Find(db.ctx, bson.M{
+	cursor, err := db.GetCollection(collectionname).Find(db.ctx, bson.M{
 		"term": bson.M{"$in": terms},
 	})
 	if err != nil {
@@ -178,6 +192,81 @@ func (db *Database) FetchPostingsBatch(terms []string, collectionname string) ([
 	return results, nil
 }
 
+// Returns the document frequency of each of terms present in the index, without fetching postings
+func (db *Database) FetchTermDFsFor(terms []string, collectionname string) (map[string]int, error) {
+	opts := options.Find().SetProjection(bson.M{"term": 1, "DF": 1, "_id": 0})
+	cursor, err := db.GetCollection(collectionname).Find(db.ctx, bson.M{
+		"term": bson.M{"$in": terms},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	dfs := make(map[string]int, len(terms))
+	for cursor.Next(db.ctx) {
+		var entry models.TermEntry
+		if err := cursor.Decode(&entry); err != nil {
+			continue
+		}
+		if entry.DF > 0 {
+			dfs[entry.Term] = entry.DF
+		}
+	}
+	return dfs, cursor.Err()
+}
+
+// Returns the document frequency of every term with at least minDF documents, without fetching postings
+func (db *Database) FetchTermDFs(collectionname string, minDF int) (map[string]int, error) {
+	opts := options.Find().SetProjection(bson.M{"term": 1, "DF": 1, "_id": 0})
+	cursor, err := db.GetCollection(collectionname).Find(db.ctx, bson.M{
+		"term": bson.M{"$exists": true},
+		"DF":   bson.M{"$gte": minDF},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	dfs := make(map[string]int)
+	for cursor.Next(db.ctx) {
+		var entry models.TermEntry
+		if err := cursor.Decode(&entry); err != nil {
+			continue
+		}
+		dfs[entry.Term] = entry.DF
+	}
+	return dfs, cursor.Err()
+}
+
+// Returns the non-empty title of every page crawled after the page with _id after, every page if it is nil
+// Also returns the greatest _id read, or after if there are no such pages
+func (db *Database) FetchPageTitlesAfter(collectionname string, after primitive.ObjectID) ([]string, primitive.ObjectID, error) {
+	opts := options.Find().SetProjection(bson.M{"title": 1}).SetSort(bson.M{"_id": 1})
+	cursor, err := db.GetCollection(collectionname).Find(db.ctx, bson.M{"_id": bson.M{"$gt": after}}, opts)
+	if err != nil {
+		return nil, after, err
+	}
+	defer cursor.Close(db.ctx)
+
+	var titles []string
+	last := after
+	for cursor.Next(db.ctx) {
+		var page struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Title string             `bson:"title"`
+		}
+		if err := cursor.Decode(&page); err != nil {
+			continue
+		}
+		last = page.ID
+		if page.Title != "" {
+			titles = append(titles, page.Title)
+		}
+	}
+	return titles, last, cursor.Err()
+}
+
 // Updates a term in the collection based on a filter and update bson.M
 // Returns a reference to the mongo UpdateResult from the update
 func (db *Database) UpdateTerm(collectionname string, filter bson.M, update bson.M) (*mongo.UpdateResult, error) {
@@ -211,11 +300,13 @@ func (db *Database) IncrementDF(collectionname string, filter bson.M) error {
 // Initalizes a corpus stats document in collection
 func (db *Database) InitializeIndexCorpus(collectionname string) error {
 	meta := bson.M{
-		"_id":         "corpus_stats",
-		"total_pages": 0,
-		"term":        "",
+		"_id":           "corpus_stats",
+		"total_pages":   0,
+		"total_length":  0,
+		"field_lengths": bson.M{},
+		"term":          "",
 	}
-	_, db.Error = db.collection[collectionname].InsertOne(db.ctx, meta)
+	_, db.Error = db.GetCollection(collectionname).InsertOne(db.ctx, meta)
 	return db.Error
 }
 
@@ -224,16 +315,85 @@ func (db *Database) TotalDocCount(collectionname string) (int, error) {
 	var result struct {
 		TotalDocCount int `bson:"total_pages"`
 	}
-	err := db.collection[collectionname].FindOne(db.ctx, bson.M{"_id": "corpus_stats"}).Decode(&result)
+	err := db.GetCollection(collectionname).FindOne(db.ctx, bson.M{"_id": "corpus_stats"}).Decode(&result)
 	return result.TotalDocCount, err
 }
 
 // Increments the total_pages in corpus stats
 func (db *Database) IncrementDocCount(collectionname string) error {
-	_, err := db.collection[collectionname].UpdateByID(db.ctx, "corpus_stats", bson.M{"$inc": bson.M{"total_pages": 1}})
+	_, err := db.GetCollection(collectionname).UpdateByID(db.ctx, "corpus_stats", bson.M{"$inc": bson.M{"total_pages": 1}})
 	return err
 }
 
+// Returns the corpus stats document of an index collection
+func (db *Database) GetCorpusStats(collectionname string) (*models.CorpusStats, error) {
+	var result models.CorpusStats
+	err := db.GetCollection(collectionname).FindOne(db.ctx, bson.M{"_id": "corpus_stats"}).Decode(&result)
+	return &result, err
+}
+
+// Adds a document's lengths to the corpus stats, incrementing total_pages
+func (db *Database) AddDocToCorpus(collectionname string, doc *models.DocStats) error {
+	inc := bson.M{
+		"total_pages":  1,
+		"total_length": doc.Length,
+	}
+	for field, length := range doc.FieldLengths {
+		inc["field_lengths."+field] = length
+	}
+	_, err := db.GetCollection(collectionname).UpdateByID(db.ctx, "corpus_stats", bson.M{"$inc": inc})
+	return err
+}
+
+// Returns the collection holding per-document stats of an index collection
+func DocStatsCollection(indexname string) string {
+	return indexname + "_docs"
+}
+
+// Inserts per-document stats unless the document already has stats
+// Returns true if the stats were new, i.e. the document was not indexed before
+func (db *Database) InsertDocStats(collectionname string, doc *models.DocStats) (bool, error) {
+	result, err := db.GetCollection(collectionname).UpdateByID(
+		db.ctx,
+		doc.DocID,
+		bson.M{"$setOnInsert": bson.M{
+			"url":           doc.URL,
+			"host":          doc.Host,
+			"date":          doc.Date,
+			"filetype":      doc.FileType,
+			"length":        doc.Length,
+			"field_lengths": doc.FieldLengths,
+			"indexed_at":    doc.IndexedAt,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.UpsertedCount > 0, nil
+}
+
+// Batch fetches per-document stats by doc id
+func (db *Database) FetchDocStatsBatch(docIDs []string, collectionname string) (map[string]models.DocStats, error) {
+	cursor, err := db.GetCollection(collectionname).Find(db.ctx, bson.M{
+		"_id": bson.M{"$in": docIDs},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(db.ctx)
+
+	results := make(map[string]models.DocStats)
+	for cursor.Next(db.ctx) {
+		var doc models.DocStats
+		if err := cursor.Decode(&doc); err != nil {
+			continue
+		}
+		results[doc.DocID] = doc
+	}
+	return results, nil
+}
+
 // Gets a pagerank score for a url
 func (db *Database) GetPageRank(url string) float64 {
 	collection := db.GetCollection("pagerank")
@@ -250,7 +410,7 @@ func (db *Database) GetPageRank(url string) float64 {
 
 // Batch fetches pagerank scores for a list of urls
 func (db *Database) FetchPageRankBatch(urls []string) (map[string]float64, error) {
-	cursor, err := db.collection["pagerank"].Find(db.ctx, bson.M{
+	cursor, err := db.GetCollection("pagerank").Find(db.ctx, bson.M{
 		"url": bson.M{"$in": urls},
 	})
 	if err != nil {
@@ -0,0 +1,193 @@
+package suggest
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Weight of each source in completion scores, a popular query outranks a title or a common term
+const (
+	QUERY_WEIGHT = 3.0
+	TITLE_WEIGHT = 1.5
+	TERM_WEIGHT  = 1.0
+)
+
+// Times a query must be searched before it is suggested, so one-off or abusive queries are never shown
+const MIN_QUERY_COUNT = 3
+
+// Most distinct queries counted, further new queries are ignored until the next rebuild
+const MAX_RECORDED_QUERIES = 100000
+
+// Longest title or query suggested, in bytes
+const MAX_COMPLETION_LENGTH = 80
+
+/*
+Autocompletes query prefixes from indexed terms, page titles and popular past queries
+
+Terms and titles come from a full build, then are updated as terms are indexed and pages crawled,
+searched queries are counted and added as they come in. Safe for concurrent use.
+*/
+type Suggester struct {
+	mu      sync.RWMutex
+	phrases *Trie // titles and queries
+	terms   *Trie // single indexed terms, also used to complete the last word of a prefix
+
+	queries map[string]int // times each query was searched, kept across rebuilds
+}
+
+// Returns an empty suggester
+func NewSuggester() *Suggester {
+	return &Suggester{
+		phrases: NewTrie(),
+		terms:   NewTrie(),
+		queries: make(map[string]int),
+	}
+}
+
+// Replaces terms and titles, keeping counted queries
+// The new tries are built before the lock is taken, so completions are served throughout
+func (s *Suggester) Rebuild(termDFs map[string]int, titles []string) {
+	terms := NewTrie()
+	for term, df := range termDFs {
+		if score := termScore(term, df); score > 0 {
+			terms.Add(term, term, KIND_TERM, score)
+		}
+	}
+
+	phrases := NewTrie()
+	for _, title := range titles {
+		addTitle(phrases, title)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, count := range s.queries {
+		if count >= MIN_QUERY_COUNT {
+			phrases.Add(key, key, KIND_QUERY, QUERY_WEIGHT*math.Log1p(float64(count)))
+		}
+	}
+	s.phrases, s.terms = phrases, terms
+}
+
+// Sets the document frequency of modified terms, a term no longer in enough documents is no longer completed
+func (s *Suggester) UpdateTerms(termDFs map[string]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for term, df := range termDFs {
+		s.terms.Set(term, term, KIND_TERM, termScore(term, df))
+	}
+}
+
+// Adds the titles of newly crawled pages
+func (s *Suggester) AddTitles(titles []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, title := range titles {
+		addTitle(s.phrases, title)
+	}
+}
+
+// Returns the completion score of an indexed term, 0 if it is too rare or not a word
+func termScore(term string, df int) float64 {
+	if df < MIN_SUGGEST_DF || !correctable(term) {
+		return 0
+	}
+	return TERM_WEIGHT * math.Log1p(float64(df))
+}
+
+// Adds a page title to completions unless it is empty or too long
+func addTitle(phrases *Trie, title string) {
+	if key := NormalizeKey(title); key != "" && len(key) <= MAX_COMPLETION_LENGTH {
+		phrases.Add(key, strings.Join(strings.Fields(title), " "), KIND_TITLE, TITLE_WEIGHT)
+	}
+}
+
+// Counts a searched query, adding it to completions once searched MIN_QUERY_COUNT times
+func (s *Suggester) RecordQuery(query string) {
+	s.RecordQueryCount(query, 1)
+}
+
+// Counts a query searched count times, e.g. when seeding counts from past searches
+func (s *Suggester) RecordQueryCount(query string, count int) {
+	key := NormalizeKey(query)
+	if key == "" || len(key) > MAX_COMPLETION_LENGTH || count <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, ok := s.queries[key]
+	if !ok && len(s.queries) >= MAX_RECORDED_QUERIES {
+		return
+	}
+	total := prev + count
+	s.queries[key] = total
+	if total < MIN_QUERY_COUNT {
+		return
+	}
+
+	// add the score gained since the query was last scored
+	gained := math.Log1p(float64(total))
+	if prev >= MIN_QUERY_COUNT {
+		gained -= math.Log1p(float64(prev))
+	}
+	s.phrases.Add(key, key, KIND_QUERY, QUERY_WEIGHT*gained)
+}
+
+// Returns up to n completions of prefix, best first
+// Besides titles, queries and terms starting with prefix, the last word of a multi-word prefix is
+// completed from indexed terms, e.g. "distributed sys" to "distributed systems"
+func (s *Suggester) Suggest(prefix string, n int) []Completion {
+	key := strings.ToLower(strings.TrimLeft(prefix, " \t"))
+	key = strings.Join(strings.Fields(key), " ") + trailingSpace(key)
+	if strings.TrimSpace(key) == "" || n <= 0 {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candidates := s.phrases.Complete(key, n)
+
+	words := strings.Fields(key)
+	if len(words) == 1 && !strings.HasSuffix(key, " ") {
+		candidates = append(candidates, s.terms.Complete(key, n)...)
+	} else if len(words) > 1 && !strings.HasSuffix(key, " ") {
+		// complete the last word, keeping the words before it
+		head := strings.Join(words[:len(words)-1], " ") + " "
+		for _, c := range s.terms.Complete(words[len(words)-1], n) {
+			c.Text = head + c.Text
+			candidates = append(candidates, c)
+		}
+	}
+
+	// best first, each text once
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	seen := make(map[string]bool)
+	results := make([]Completion, 0, n)
+	for _, c := range candidates {
+		k := NormalizeKey(c.Text)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		results = append(results, c)
+		if len(results) == n {
+			break
+		}
+	}
+	return results
+}
+
+// Returns " " if s ends in whitespace, a trailing space means the last word is complete
+func trailingSpace(s string) string {
+	if s != strings.TrimRight(s, " \t") {
+		return " "
+	}
+	return ""
+}
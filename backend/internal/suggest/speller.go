@@ -0,0 +1,201 @@
+package suggest
+
+import (
+	"github.com/Jailior/open-search/backend/internal/parsing"
+)
+
+// Largest edit distance between a misspelled word and its correction
+const MAX_EDIT_DISTANCE = 2
+
+// Only the first characters of a word are used to generate deletes, bounding the dictionary's size
+const SPELLER_PREFIX_LENGTH = 7
+
+// Fewest documents a term must occur in to be suggested, rarer terms are likely misspellings themselves
+const MIN_SUGGEST_DF = 2
+
+// A known word is still corrected if a candidate is this many times more frequent, so misspellings found in a few pages are still corrected
+const CORRECT_KNOWN_RATIO = 20
+
+// Words shorter than this are never corrected
+const MIN_CORRECT_LENGTH = 3
+
+/*
+Spelling corrector using symmetric delete lookups
+
+Every dictionary word is stored under each string obtainable by deleting up to MAX_EDIT_DISTANCE characters
+from its prefix. Looking a word up generates the same deletes of the word, so candidates within the distance
+are found by map lookups instead of comparing against the whole dictionary, then verified by edit distance.
+*/
+type Speller struct {
+	words   []string
+	freqs   map[string]int
+	deletes map[string][]int32 // delete -> indices of words generating it
+}
+
+// Builds a speller from the document frequency of every term, terms rarer than MIN_SUGGEST_DF are never suggested
+func NewSpeller(dfs map[string]int) *Speller {
+	s := &Speller{
+		freqs:   dfs,
+		deletes: make(map[string][]int32),
+	}
+
+	for word, df := range dfs {
+		if df < MIN_SUGGEST_DF || !correctable(word) {
+			continue
+		}
+		idx := int32(len(s.words))
+		s.words = append(s.words, word)
+		for del := range deletes(prefix(word), MAX_EDIT_DISTANCE) {
+			s.deletes[del] = append(s.deletes[del], idx)
+		}
+	}
+	return s
+}
+
+// Number of words that can be suggested
+func (s *Speller) Size() int {
+	return len(s.words)
+}
+
+// Returns the document frequency of a word, 0 if unknown
+func (s *Speller) Freq(word string) int {
+	return s.freqs[word]
+}
+
+/*
+Returns the best correction of a lowercased word and true, or false if the word should be kept
+
+Unknown words are corrected to the closest candidate, ties broken by document frequency.
+Known words are only corrected to a candidate CORRECT_KNOWN_RATIO times more frequent.
+*/
+func (s *Speller) Correct(word string) (string, bool) {
+	if !correctable(word) {
+		return word, false
+	}
+	freq := s.freqs[word]
+
+	best, bestDist, bestFreq := "", MAX_EDIT_DISTANCE+1, 0
+	seen := make(map[int32]bool)
+	for del := range deletes(prefix(word), MAX_EDIT_DISTANCE) {
+		for _, idx := range s.deletes[del] {
+			if seen[idx] {
+				continue
+			}
+			seen[idx] = true
+
+			candidate := s.words[idx]
+			if candidate == word {
+				continue
+			}
+			dist := editDistance(word, candidate, MAX_EDIT_DISTANCE)
+			if dist > MAX_EDIT_DISTANCE {
+				continue
+			}
+			candFreq := s.freqs[candidate]
+			if dist < bestDist || (dist == bestDist && candFreq > bestFreq) {
+				best, bestDist, bestFreq = candidate, dist, candFreq
+			}
+		}
+	}
+
+	if best == "" {
+		return word, false
+	}
+	if freq > 0 && bestFreq < freq*CORRECT_KNOWN_RATIO {
+		return word, false
+	}
+	return best, true
+}
+
+// Returns true if a word is a candidate for correction, stopwords and numbers are left alone
+func correctable(word string) bool {
+	if len([]rune(word)) < MIN_CORRECT_LENGTH || parsing.Stopwords[word] {
+		return false
+	}
+	for _, r := range word {
+		if r < '0' || r > '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the first SPELLER_PREFIX_LENGTH characters of a word
+func prefix(word string) string {
+	runes := []rune(word)
+	if len(runes) > SPELLER_PREFIX_LENGTH {
+		runes = runes[:SPELLER_PREFIX_LENGTH]
+	}
+	return string(runes)
+}
+
+// Returns the word and every string obtained by deleting up to distance characters from it
+func deletes(word string, distance int) map[string]bool {
+	result := map[string]bool{word: true}
+	frontier := []string{word}
+	for d := 0; d < distance; d++ {
+		var next []string
+		for _, w := range frontier {
+			runes := []rune(w)
+			if len(runes) <= 1 {
+				continue
+			}
+			for i := range runes {
+				del := string(runes[:i]) + string(runes[i+1:])
+				if !result[del] {
+					result[del] = true
+					next = append(next, del)
+				}
+			}
+		}
+		frontier = next
+	}
+	return result
+}
+
+// Returns the optimal string alignment distance between a and b, insertions, deletions, substitutions and
+// transpositions of adjacent characters each cost 1
+// Stops early and returns limit+1 once the distance exceeds limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if abs(len(ra)-len(rb)) > limit {
+		return limit + 1
+	}
+
+	// three rows of the dynamic programming table
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+// Returns the absolute value of x
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,259 @@
+package suggest
+
+import (
+	"container/heap"
+	"sort"
+	"strings"
+)
+
+// Sources of completions
+const (
+	KIND_QUERY = "query"
+	KIND_TITLE = "title"
+	KIND_TERM  = "term"
+)
+
+// A completion of a prefix
+type Completion struct {
+	Text  string  `json:"text"`
+	Kind  string  `json:"kind"` // source contributing most of the score
+	Score float64 `json:"score"`
+
+	sources map[string]float64 // score contributed by each kind
+}
+
+/*
+Compressed (radix) trie mapping keys to scored completions
+
+Each node stores the best score in its subtree, so the top completions of a prefix are found best first
+with a priority queue, visiting only the nodes that can still hold one of them.
+*/
+type Trie struct {
+	root *trieNode
+	size int
+}
+
+// Node of the trie, label is the edge from its parent
+type trieNode struct {
+	label    string
+	children []*trieNode // sorted by first byte of label
+	entry    *Completion
+	best     float64
+}
+
+// Returns an empty trie
+func NewTrie() *Trie {
+	return &Trie{root: &trieNode{}}
+}
+
+// Number of completions in the trie
+func (t *Trie) Size() int {
+	return t.size
+}
+
+// Adds score from a source to the completion of key, creating it with display text if new
+// Scores only grow, so the subtree bests along the path are raised in place
+func (t *Trie) Add(key, text, kind string, score float64) {
+	path := t.insert(key)
+	entry := t.entry(path[len(path)-1], text)
+	entry.Score += score
+	entry.sources[kind] += score
+	t.update(path, entry, kind)
+}
+
+// Sets the score from a source of the completion of key, e.g. when a term's document frequency changes
+// A completion left without score is no longer returned
+// Bests along the path are only raised, a lowered score leaves them as upper bounds, which is all the search needs
+func (t *Trie) Set(key, text, kind string, score float64) {
+	if score <= 0 && t.Score(key) <= 0 {
+		return
+	}
+	path := t.insert(key)
+	node := path[len(path)-1]
+	if node.entry == nil {
+		node.entry = &Completion{Text: text, sources: make(map[string]float64)}
+	}
+	entry := node.entry
+	wasEmpty := entry.Score <= 0
+	entry.sources[kind] = score
+	entry.Score = 0
+	for _, s := range entry.sources {
+		entry.Score += s
+	}
+	switch {
+	case entry.Score <= 0 && !wasEmpty:
+		t.size--
+	case entry.Score > 0 && wasEmpty:
+		t.size++
+	}
+	t.update(path, entry, kind)
+}
+
+// Returns the path of nodes from the root to the node of key, adding nodes as needed
+func (t *Trie) insert(key string) []*trieNode {
+	node := t.root
+	path := []*trieNode{node}
+	rest := key
+
+	for rest != "" {
+		i := node.childIndex(rest[0])
+		if i == len(node.children) || node.children[i].label[0] != rest[0] {
+			// no edge starts with the next byte, add a leaf
+			leaf := &trieNode{label: rest}
+			node.insertChild(i, leaf)
+			node = leaf
+			path = append(path, node)
+			break
+		}
+
+		child := node.children[i]
+		common := commonPrefix(child.label, rest)
+		if common < len(child.label) {
+			// split the edge at the common prefix
+			split := &trieNode{label: child.label[:common], children: []*trieNode{child}, best: child.best}
+			child.label = child.label[common:]
+			node.children[i] = split
+			child = split
+		}
+		node = child
+		path = append(path, node)
+		rest = rest[common:]
+	}
+	return path
+}
+
+// Returns the completion of a node, creating it with display text if new
+func (t *Trie) entry(node *trieNode, text string) *Completion {
+	if node.entry == nil {
+		node.entry = &Completion{Text: text, sources: make(map[string]float64)}
+		t.size++
+	}
+	return node.entry
+}
+
+// Updates the kind of a completion whose score from kind changed and raises the bests along its path
+func (t *Trie) update(path []*trieNode, entry *Completion, kind string) {
+	if entry.Kind == "" || entry.sources[kind] > entry.sources[entry.Kind] {
+		entry.Kind = kind
+	}
+	for _, n := range path {
+		n.best = max(n.best, entry.Score)
+	}
+}
+
+// Returns the score of a key's completion, 0 if absent
+func (t *Trie) Score(key string) float64 {
+	node, rest := t.find(key)
+	if node == nil || rest != "" || node.entry == nil {
+		return 0
+	}
+	return node.entry.Score
+}
+
+// Returns up to n completions of keys starting with prefix, best first
+func (t *Trie) Complete(prefix string, n int) []Completion {
+	node, _ := t.find(prefix)
+	if node == nil || n <= 0 {
+		return nil
+	}
+
+	var results []Completion
+	queue := &completionQueue{{node: node, score: node.best}}
+	for queue.Len() > 0 && len(results) < n {
+		item := heap.Pop(queue).(queueItem)
+		if item.entry != nil {
+			results = append(results, *item.entry)
+			continue
+		}
+		if item.node.entry != nil && item.node.entry.Score > 0 {
+			heap.Push(queue, queueItem{entry: item.node.entry, score: item.node.entry.Score})
+		}
+		for _, child := range item.node.children {
+			heap.Push(queue, queueItem{node: child, score: child.best})
+		}
+	}
+	return results
+}
+
+// Returns the node whose subtree holds every key starting with prefix, and the part of prefix inside its edge
+// Returns nil if no key starts with prefix
+func (t *Trie) find(prefix string) (*trieNode, string) {
+	node := t.root
+	rest := prefix
+	for rest != "" {
+		i := node.childIndex(rest[0])
+		if i == len(node.children) || node.children[i].label[0] != rest[0] {
+			return nil, ""
+		}
+		child := node.children[i]
+		common := commonPrefix(child.label, rest)
+		if common == len(rest) {
+			// prefix ends within or at the end of the edge
+			return child, rest[common:]
+		}
+		if common < len(child.label) {
+			return nil, ""
+		}
+		node = child
+		rest = rest[common:]
+	}
+	return node, rest
+}
+
+// Returns the index of the child whose label starts with b, or where it would be inserted
+func (n *trieNode) childIndex(b byte) int {
+	return sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].label[0] >= b
+	})
+}
+
+// Inserts a child at index i
+func (n *trieNode) insertChild(i int, child *trieNode) {
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+// Returns the length of the common prefix of a and b in bytes
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Normalizes text into a trie key, lowercased with runs of whitespace collapsed
+func NormalizeKey(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// Node or completion in the best first search, ordered by score
+type queueItem struct {
+	node  *trieNode
+	entry *Completion
+	score float64
+}
+
+// Max-heap of queue items, completions before nodes of equal score
+type completionQueue []queueItem
+
+func (q completionQueue) Len() int { return len(q) }
+func (q completionQueue) Less(i, j int) bool {
+	if q[i].score != q[j].score {
+		return q[i].score > q[j].score
+	}
+	return q[i].entry != nil && q[j].entry == nil
+}
+func (q completionQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *completionQueue) Push(x any) {
+	*q = append(*q, x.(queueItem))
+}
+
+func (q *completionQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
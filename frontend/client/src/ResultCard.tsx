@@ -1,5 +1,12 @@
 import React from "react";
 import styles from "./SearchPage.module.css";
+import { clickHref } from "./api";
+
+// Highlighted span of a snippet, offsets into snippet_text
+export interface Highlight {
+  start: number;
+  end: number;
+}
 
 // Standard interface for a page result
 export interface Result {
@@ -7,19 +14,39 @@ export interface Result {
   title: string;
   url: string;
   snippet: string;
+  snippet_text: string;
+  highlights: Highlight[] | null;
   score: number;
+  click_url?: string;
+  more_from_site?: number;
+  site_query?: string;
 }
 
 interface Props {
     result: Result;
+    // Searches a query, used for "more results from this site"
+    onSearch?: (query: string) => void;
 }
 
+// Splits snippet text into plain and bold parts using the highlight offsets
+const renderSnippet = (text: string, highlights: Highlight[] | null) => {
+    const parts: React.ReactNode[] = [];
+    let last = 0;
+    (highlights ?? []).forEach((h, i) => {
+      parts.push(text.slice(last, h.start));
+      parts.push(<strong key={i}>{text.slice(h.start, h.end)}</strong>);
+      last = h.end;
+    });
+    parts.push(text.slice(last));
+    return parts;
+};
+
 // Result card component, requires Result instance
-const ResultCard: React.FC<Props> = ({ result }) => {
+const ResultCard: React.FC<Props> = ({ result, onSearch }) => {
     return (
     <div className={styles.resultItem}>
       <a
-        href={result.url}
+        href={result.click_url ? clickHref(result.click_url) : result.url}
         className={styles.resultTitle}
         target="_blank"
         rel="noopener noreferrer"
@@ -27,9 +54,14 @@ const ResultCard: React.FC<Props> = ({ result }) => {
         {result.title || result.url}
       </a>
       <p className={styles.resultURL}>{result.url}</p>
-      <p className={styles.resultSnippet}><div dangerouslySetInnerHTML={{__html: result.snippet}}></div></p>
+      <p className={styles.resultSnippet}>{renderSnippet(result.snippet_text ?? "", result.highlights)}</p>
+      {result.site_query && onSearch && (
+        <button className={styles.moreFromSite} onClick={() => onSearch(result.site_query!)}>
+          More results from {new URL(result.url).hostname}
+        </button>
+      )}
     </div>
     );
 };
 
-export default ResultCard;
\ No newline at end of file
+export default ResultCard;
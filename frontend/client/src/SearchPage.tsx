@@ -1,8 +1,8 @@
-import { useEffect, useState } from "react";
+import { useEffect, useState, type KeyboardEvent } from "react";
 import { LuSearchCheck } from "react-icons/lu";
 
 import styles from "./SearchPage.module.css"
-import { fetchMetrics, searchQuery } from "./api";
+import { fetchMetrics, fetchSuggestions, searchQuery } from "./api";
 import ResultCard, { type Result } from "./ResultCard";
 import MetricsCard, {type Metrics } from "./MetricsCard";
 import { FaAngleLeft } from "react-icons/fa";
@@ -27,19 +27,84 @@ export default function SearchPage() {
   // Total number of results returned
   const [total, setTotal] = useState(0);
 
-  // Page offset for pagination
-  const [offset, setOffset] = useState(0);
+  // Bool, false if total is a lower bound
+  const [totalExact, setTotalExact] = useState(true);
+
+  // Corrected query suggested by the backend, empty if none
+  const [suggestion, setSuggestion] = useState("");
+
+  // Query the shown results are for, the corrected query if auto-corrected
+  const [resultsQuery, setResultsQuery] = useState("");
+
+  // Query as typed when results are for the corrected query, empty otherwise
+  const [originalQuery, setOriginalQuery] = useState("");
+
+  // Completions of the query being typed
+  const [completions, setCompletions] = useState<string[]>([]);
+
+  // Index of the completion selected with the arrow keys, -1 if none
+  const [activeCompletion, setActiveCompletion] = useState(-1);
+
+  // Bool, true while the query is being typed, completions are only fetched then
+  const [typing, setTyping] = useState(false);
+
+  // Current page, 0 for the first
+  const [page, setPage] = useState(0);
+
+  // Cursor of each page after the first, from the previous page's next_cursor
+  const [cursors, setCursors] = useState<string[]>([]);
+
+  // Bool, true if a page follows the current one
+  const [hasNext, setHasNext] = useState(false);
+
+  // Query and autocorrect setting the shown pages are for, paging continues them
+  const [searched, setSearched] = useState({ q: "", autocorrect: true });
 
   // Number of results in a page limit
   const limit = 15;
 
   // Search request handler, sets view variables
-  const handleSearch = async () => {
-    const data = await searchQuery(query, offset, limit);
+  const handleSearch = async (q = query, autocorrect = true, pageNum = 0) => {
+    const data = await searchQuery(q, pageNum > 0 ? cursors[pageNum - 1] : undefined, limit, autocorrect);
+    setPage(pageNum);
+    setCursors(data.next_cursor ? [...cursors.slice(0, pageNum), data.next_cursor] : cursors.slice(0, pageNum));
+    setHasNext(!!data.next_cursor);
+    setSearched({ q, autocorrect });
     setResults(data.results);
     setTotal(data.totalResults);
+    setTotalExact(data.totalResultsExact ?? true);
+    setSuggestion(data.suggestion ?? "");
+    setOriginalQuery(data.original_query ?? "");
+    setResultsQuery(data.query);
     setHasSearched(true);
     setShowMetrics(false);
+    setTyping(false);
+    setCompletions([]);
+  };
+
+  // Searches a selected completion
+  const selectCompletion = (completion: string) => {
+    setQuery(completion);
+    handleSearch(completion);
+  };
+
+  // Key handler for the search bar, arrow keys move through completions
+  const handleKeyDown = (e: KeyboardEvent<HTMLInputElement>) => {
+    if (e.key === "ArrowDown" && completions.length > 0) {
+      e.preventDefault();
+      setActiveCompletion((activeCompletion + 1) % completions.length);
+    } else if (e.key === "ArrowUp" && completions.length > 0) {
+      e.preventDefault();
+      setActiveCompletion(activeCompletion <= 0 ? completions.length - 1 : activeCompletion - 1);
+    } else if (e.key === "Escape") {
+      setCompletions([]);
+    } else if (e.key === "Enter") {
+      if (activeCompletion >= 0 && activeCompletion < completions.length) {
+        selectCompletion(completions[activeCompletion]);
+      } else {
+        handleSearch();
+      }
+    }
   };
 
   // Metrics request handler, sets view variables
@@ -49,10 +114,33 @@ export default function SearchPage() {
     setShowMetrics(true);
   }
 
-  // Request search on offset change, page change
+  // Moves to another page of the current search
+  const changePage = (pageNum: number) => {
+    handleSearch(searched.q, searched.autocorrect, pageNum);
+    window.scrollTo({ top: 0, behavior: "smooth" });
+  };
+
+  // Fetch completions once typing pauses, ignoring responses for an outdated query
   useEffect(() => {
-    if (query) handleSearch();
-  }, [offset]);
+    setActiveCompletion(-1);
+    if (!typing || query.trim() === "") {
+      setCompletions([]);
+      return;
+    }
+    let stale = false;
+    const timer = setTimeout(async () => {
+      try {
+        const data = await fetchSuggestions(query);
+        if (!stale) setCompletions(data.map((c: { text: string }) => c.text));
+      } catch {
+        if (!stale) setCompletions([]);
+      }
+    }, 150);
+    return () => {
+      stale = true;
+      clearTimeout(timer);
+    };
+  }, [query, typing]);
 
   // If query is empty return to main page and/or don't process search request
   useEffect(() => {
@@ -60,7 +148,8 @@ export default function SearchPage() {
     setHasSearched(false);
     setResults([]);
     setTotal(0);
-    setOffset(0);
+    setPage(0);
+    setCursors([]);
   }
 }, [query]);
 
@@ -82,7 +171,8 @@ export default function SearchPage() {
           onClick={() => {
               setQuery("");
               setHasSearched(false);
-              setOffset(0);
+              setPage(0);
+              setCursors([]);
               setResults([]);
               setTotal(0);
               setShowMetrics(false);
@@ -96,18 +186,37 @@ export default function SearchPage() {
       {/* Search Bar */}
       {!showMetrics && (
         <div className={`${styles.searchBar} sticky top-16 z-10 bg-transparent`}>
+            <div className="relative w-full">
             <input
             className={`${styles.input} w-full transition-all duration-300`}
             placeholder="Search..."
             value={query}
-            onChange={(e) => setQuery(e.target.value)}
-            onKeyDown={(e) => e.key === "Enter" && handleSearch()}
+            onChange={(e) => { setQuery(e.target.value); setTyping(true); }}
+            onKeyDown={handleKeyDown}
+            onBlur={() => setCompletions([])}
             />
 
+            {/* Completions dropdown */}
+            {completions.length > 0 && (
+              <ul className={styles.suggestions}>
+                {completions.map((c, i) => (
+                  <li
+                  key={c}
+                  className={`${styles.suggestion} ${i === activeCompletion ? styles.suggestionActive : ""}`}
+                  onMouseDown={(e) => { e.preventDefault(); selectCompletion(c); }}
+                  onMouseEnter={() => setActiveCompletion(i)}
+                  >
+                  {c}
+                  </li>
+                ))}
+              </ul>
+            )}
+            </div>
+
             {hasSearched && (
             <button
             className={`${styles.button} ml-4`}
-            onClick={handleSearch}
+            onClick={() => handleSearch()}
             >
             Search
             </button>
@@ -121,7 +230,7 @@ export default function SearchPage() {
         <div className="flex space-x-4 mb-8 justify-center">
             <button
             className={styles.button}
-            onClick={handleSearch}
+            onClick={() => handleSearch()}
             >
             Search
             </button>
@@ -143,11 +252,23 @@ export default function SearchPage() {
         <div
             className={`transition-opacity ${hasSearched && (total != 0) ? "opacity-100" : "opacity-0" }`}
         >
-        <p className={`${styles.totalResults} duration-0`}>Showing {total} results for {query}</p>
+        <p className={`${styles.totalResults} duration-0`}>Showing {total}{totalExact ? "" : "+"} results for {resultsQuery}</p>
+        {originalQuery && (
+          <p className={`${styles.totalResults} duration-0`}>
+            Search instead for{" "}
+            <button className="underline" onClick={() => handleSearch(originalQuery, false)}>{originalQuery}</button>
+          </p>
+        )}
+        {suggestion && !originalQuery && (
+          <p className={`${styles.totalResults} duration-0`}>
+            Did you mean{" "}
+            <button className="underline" onClick={() => { setQuery(suggestion); handleSearch(suggestion); }}>{suggestion}</button>?
+          </p>
+        )}
         
         <div className={`${styles.resultList} duration-500`}>
                 {results.map((r) => (
-                    <ResultCard key={r.doc_id} result={r} />
+                    <ResultCard key={r.doc_id} result={r} onSearch={(q) => { setQuery(q); handleSearch(q); }} />
                 ))}
             </div>
         </div>
@@ -184,21 +305,15 @@ export default function SearchPage() {
     {(hasSearched && (total != 0)) && (
       <div className={styles.pagination}>
         <button
-          onClick={() => {
-            setOffset(Math.max(0, offset - limit));
-            window.scrollTo({ top: 0, behavior: "smooth" });
-          }}
-          disabled={offset === 0}
+          onClick={() => changePage(page - 1)}
+          disabled={page === 0}
           className={styles.pageButton}
         >
           Previous
         </button>
         <button
-          onClick={() => {
-            setOffset(offset + limit);
-            window.scrollTo({ top: 0, behavior: "smooth" });
-          }}
-          disabled={offset + limit >= total}
+          onClick={() => changePage(page + 1)}
+          disabled={!hasNext}
           className={`${styles.pageButton} ml-3`}
         >
           Next
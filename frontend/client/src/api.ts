@@ -3,14 +3,25 @@ import axios from "axios"
 // Base for api requests
 const API_BASE = "/api/"
 
-// Sends search api request and returns json response
-export const searchQuery = async (q: string, offset = 0, limit = 10) => {
+// Sends search api request and returns json response, the page after cursor if given
+export const searchQuery = async (q: string, cursor?: string, limit = 10, autocorrect = true) => {
     const res = await axios.get(`${API_BASE}/search`, {
-        params: { q, offset, limit },
+        params: { q, cursor, limit, autocorrect, track: true },
     });
     return res.data;
 };
 
+// Returns the link to a result's /click redirect, which records the click before opening the page
+export const clickHref = (clickURL: string) => `${API_BASE.replace(/\/$/, "")}${clickURL}`;
+
+// Sends autocomplete api request and returns the completions of a query prefix
+export const fetchSuggestions = async (q: string, limit = 8) => {
+    const res = await axios.get(`${API_BASE}/suggest`, {
+        params: { q, limit },
+    });
+    return res.data.suggestions ?? [];
+};
+
 // Sends metrics api request and returns json response
 export const fetchMetrics = async () => {
     const res = await axios.get(`${API_BASE}/metrics`);